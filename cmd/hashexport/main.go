@@ -0,0 +1,169 @@
+// hashexport writes the challenge-response captures found in JSON event
+// logs in hashcat or John the Ripper formats, for offline cracking
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"imap-honey/event"
+	"imap-honey/sasl"
+)
+
+var Version string
+
+// Exporter collects the lines of one format, duplicates dropped
+type Exporter struct {
+	Format  string // hashcat or john
+	Mode    string // only this hashcat mode or John format if set
+	Counts  map[string]int
+	Skipped map[string]int // captures without a line in Format, by mechanism
+
+	seen map[string]bool
+	w    io.Writer
+}
+
+func NewExporter(w io.Writer, format string, mode string) *Exporter {
+	return &Exporter{Format: format, Mode: mode, Counts: map[string]int{}, Skipped: map[string]int{}, seen: map[string]bool{}, w: w}
+}
+
+// Export reads events, one JSON object per line, and writes the
+// captures. Lines that are not JSON events are skipped.
+func (x *Exporter) Export(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		ev := &event.Event{}
+		if json.Unmarshal(scanner.Bytes(), ev) != nil {
+			continue
+		}
+		c, ok := sasl.FromEvent(ev)
+		if !ok {
+			continue
+		}
+		var mode, line string
+		switch x.Format {
+		case "hashcat":
+			var m int
+			m, line, ok = c.Hashcat()
+			mode = strconv.Itoa(m)
+		case "john":
+			mode, line, ok = c.John()
+		}
+		if !ok {
+			if c.Captured() && x.Mode == "" {
+				x.Skipped[c.Mechanism]++
+			}
+			continue
+		}
+		if (x.Mode != "" && x.Mode != mode) || x.seen[line] {
+			continue
+		}
+		x.seen[line] = true
+		x.Counts[mode]++
+		if _, err := fmt.Fprintln(x.w, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ExportFile reads a log file, gzipped rotated segments included
+func (x *Exporter) ExportFile(path string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+		if strings.HasSuffix(path, ".gz") {
+			z, err := gzip.NewReader(f)
+			if err != nil {
+				return err
+			}
+			defer z.Close()
+			r = z
+		}
+	}
+	return x.Export(r)
+}
+
+/**
+USAGE
+
+./imaphoney -log-format json -log-file /var/log/honey.json
+./hashexport -format hashcat -mode 16400 /var/log/honey.json* > cram.txt
+hashcat -m 16400 cram.txt wordlist.txt
+
+./hashexport -format john /var/log/honey.json > captures.txt
+john --format=dmd5 captures.txt
+**/
+
+func main() {
+	formatFlag := flag.String("format", "hashcat", "output format: hashcat or john")
+	modeFlag := flag.String("mode", "", "only export this hashcat mode or John format")
+	outFlag := flag.String("o", "", "output file (default stdout)")
+	versionFlag := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+
+	if *versionFlag {
+		fmt.Printf("Version: %s\n", Version)
+		return
+	}
+	if *formatFlag != "hashcat" && *formatFlag != "john" {
+		fmt.Fprintf(os.Stderr, "main() ERROR: unknown format %q\n", *formatFlag)
+		os.Exit(2)
+	}
+
+	w := os.Stdout
+	if *outFlag != "" {
+		f, err := os.Create(*outFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "main() ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	x := NewExporter(w, *formatFlag, *modeFlag)
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	status := 0
+	for _, path := range paths {
+		if err := x.ExportFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "ExportFile() ERROR: %s: %v\n", path, err)
+			status = 1
+		}
+	}
+
+	var modes []string
+	for mode := range x.Counts {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	for _, mode := range modes {
+		fmt.Fprintf(os.Stderr, "%s %s: %d\n", *formatFlag, mode, x.Counts[mode])
+	}
+	var skipped []string
+	for mechanism := range x.Skipped {
+		skipped = append(skipped, mechanism)
+	}
+	sort.Strings(skipped)
+	for _, mechanism := range skipped {
+		fmt.Fprintf(os.Stderr, "%s skipped %s: %d, no format or incomplete capture\n", *formatFlag, mechanism, x.Skipped[mechanism])
+	}
+	os.Exit(status)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"imap-honey/event"
+	"imap-honey/sasl"
+)
+
+// capture runs a SASL exchange and returns its login event as logged
+func capture(t *testing.T, mechanism string, respond func(challenge string) string) []byte {
+	s, _ := sasl.NewServer(mechanism, &sasl.Config{Hostname: "mail.example.com"})
+	var challenge string
+	creds, err := sasl.Authenticate(s, nil,
+		func(c string) error {
+			d, _ := base64.StdEncoding.DecodeString(c)
+			challenge = string(d)
+			return nil
+		},
+		func() (string, error) {
+			return base64.StdEncoding.EncodeToString([]byte(respond(challenge))), nil
+		})
+	if err != nil {
+		t.Fatalf("%s: %v", mechanism, err)
+	}
+	return creds.Log(event.New(event.Login, "imap", nil)).JSON()
+}
+
+func TestExport(t *testing.T) {
+	key := []byte("tanstaaftanstaaf")
+	var log bytes.Buffer
+	cram := capture(t, "CRAM-MD5", func(c string) string {
+		h := hmac.New(md5.New, key)
+		h.Write([]byte(c))
+		return "tim " + hex.EncodeToString(h.Sum(nil))
+	})
+	log.Write(cram)
+	log.WriteString("\n")
+	log.Write(cram) // duplicate
+	log.WriteString("\nnot json\n")
+	log.Write(capture(t, "DIGEST-MD5", func(c string) string {
+		d, _ := sasl.Directives(c)
+		return `username="chris",realm="` + d["realm"] + `",nonce="` + d["nonce"] +
+			`",nc=00000001,cnonce="OA6MHXh6VqTrRk",digest-uri="imap/mail.example.com",response=d388dad90d4bbd760a152321f2143af7,qop=auth`
+	}))
+	log.WriteString("\n")
+	log.Write(capture(t, "PLAIN", func(string) string { return "\x00joe\x00secret" }))
+	log.WriteString("\n")
+	log.Write(capture(t, "SCRAM-SHA-256", func(c string) string {
+		if c == "" {
+			return "n,,n=user,r=abc"
+		}
+		return "c=biws," + strings.Split(c, ",")[0] + ",p=cHJvb2Y="
+	}))
+	log.WriteString("\n")
+
+	var out bytes.Buffer
+	x := NewExporter(&out, "hashcat", "")
+	if err := x.Export(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 || x.Counts["16400"] != 1 {
+		t.Fatalf("hashcat export: %q", lines)
+	}
+	if len(x.Skipped) != 2 || x.Skipped["DIGEST-MD5"] != 1 || x.Skipped["SCRAM-SHA-256"] != 1 {
+		t.Errorf("hashcat skipped %v", x.Skipped)
+	}
+	sp := strings.Split(lines[0], "$")
+	challenge, _ := base64.StdEncoding.DecodeString(sp[2])
+	response, _ := base64.StdEncoding.DecodeString(sp[3])
+	h := hmac.New(md5.New, key)
+	h.Write(challenge)
+	if sp[1] != "cram_md5" || string(response) != "tim "+hex.EncodeToString(h.Sum(nil)) {
+		t.Errorf("hashcat 16400 line %q does not verify", lines[0])
+	}
+
+	out.Reset()
+	x = NewExporter(&out, "john", "")
+	if err := x.Export(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || x.Counts["hmac-md5"] != 1 || x.Counts["dmd5"] != 1 {
+		t.Fatalf("john export: %q", lines)
+	}
+	if len(x.Skipped) != 1 || x.Skipped["SCRAM-SHA-256"] != 1 {
+		t.Errorf("john skipped %v", x.Skipped)
+	}
+	if !strings.HasPrefix(lines[0], "tim:<") || !strings.Contains(lines[0], "@mail.example.com>#") {
+		t.Errorf("john hmac-md5 line %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "chris:$DIGEST-MD5$chris$mail.example.com$") ||
+		!strings.HasSuffix(lines[1], "$imap/mail.example.com$OA6MHXh6VqTrRk$00000001$auth$d388dad90d4bbd760a152321f2143af7") {
+		t.Errorf("john dmd5 line %q", lines[1])
+	}
+
+	out.Reset()
+	x = NewExporter(&out, "john", "dmd5")
+	x.Export(bytes.NewReader(log.Bytes()))
+	if strings.Count(out.String(), "\n") != 1 || len(x.Skipped) != 0 {
+		t.Errorf("-mode dmd5: %q, skipped %v", out.String(), x.Skipped)
+	}
+}
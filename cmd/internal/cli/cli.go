@@ -0,0 +1,6 @@
+// Package cli holds the command lines of the honeypot binaries, shared by
+// the single protocol binaries and the mailhoney subcommands
+package cli
+
+// Version is printed at startup, set by the binaries
+var Version string
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"imap-honey/config"
+	"imap-honey/output"
+	"imap-honey/persona"
+)
+
+// IMAP runs the imaphoney command line, name is used in its usage
+func IMAP(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+
+	fmt.Printf("Version: %s\n", Version)
+	hostnameFlag := fs.String("hostname", "localhost", "hostname")
+	addressFlag := fs.String("addr", ":1993", "ipaddr:port")
+	certFlag := fs.String("cert", "", "cert file")
+	keyFlag := fs.String("key", "", "cert file")
+	capFlag := fs.String("cap", "ACL ID IDLE IMAP4rev1 AUTH=PLAIN", "imap CAPABILITY")
+	authOk := fs.Bool("aok", false, "auth ok - accept logins and serve a decoy mailbox")
+	usersFlag := fs.String("aok-users", "", "logins accepted with -aok, user or user:password, comma separated (default all)")
+	decoyFlag := fs.Int("decoy", 0, "generate a decoy mailbox spread over N months for each login, instead of the built-in one")
+	canaryURLFlag := fs.String("canary-url", "", "base of canary URLs (default https://HOSTNAME/s/)")
+	canaryKeyFlag := fs.String("canary-key", "", "HMAC key deriving canary tokens (default random)")
+	mailboxFlag := fs.String("mailbox", "", "decoy mailbox for -aok, Maildir or mbox path, or user=path, comma separated (default built-in)")
+	startTLSFlag := fs.Bool("starttls", false, "offer STARTTLS on a plaintext port instead of implicit TLS (with -cert and -key)")
+	ntlmDomainFlag := fs.String("ntlm-domain", "", "NetBIOS domain in NTLM challenges (default from -hostname)")
+	ntlmDNSFlag := fs.String("ntlm-dns-domain", "", "DNS domain in NTLM challenges (default from -hostname)")
+	loginDisabledFlag := fs.Bool("logindisabled", false, "refuse LOGIN before STARTTLS")
+	debugFlag := fs.Bool("d", false, "debug")
+	quietFlag := fs.Bool("q", false, "quiet - no msg in console")
+	spoolFlag := fs.String("spool", "", "spool directory for APPENDed messages")
+	sensorFlag := fs.String("sensor", "", "sensor ID in events (default hostname)")
+	configFlag := fs.String("config", "", "YAML configuration file of all listeners, instead of the flags")
+	checkConfigFlag := fs.Bool("check-config", false, "validate the -config file and exit")
+	personaFlag := fs.String("persona", "imaphoney", "server product to mimic or YAML/JSON persona file, \"list\" lists them")
+	outputFlags := output.RegisterFlags(fs)
+	fs.Parse(args)
+
+	if *personaFlag == "list" {
+		for _, name := range persona.Names("imap") {
+			p, _ := persona.Get("imap", name)
+			fmt.Printf("%-10s %s\n", p.Name, p.Product)
+		}
+		return
+	}
+	if *configFlag != "" {
+		c, err := config.Load(*configFlag)
+		if err != nil {
+			fmt.Printf("config ERROR:\n%v\n", err)
+			os.Exit(1)
+		}
+		if *checkConfigFlag {
+			fmt.Printf("%s: OK, %d listeners\n", *configFlag, len(c.Listeners))
+			return
+		}
+		c.Debug = c.Debug || *debugFlag
+		c.Quiet = c.Quiet || *quietFlag
+		runIMAP(c)
+		return
+	} else if *checkConfigFlag {
+		fmt.Printf("-check-config ERROR: needs -config\n")
+		os.Exit(1)
+	}
+
+	// the flags describe a single listener
+	if *startTLSFlag && (*certFlag == "" || *keyFlag == "") {
+		fmt.Printf("-starttls ERROR: needs -cert and -key\n")
+		return
+	}
+	l := &config.Listener{
+		Protocol: "imap",
+		Addr:     *addressFlag,
+		Hostname: *hostnameFlag,
+		TLS: config.TLS{Cert: *certFlag, Key: *keyFlag, StartTLS: *startTLSFlag,
+			LoginDisabled: *loginDisabledFlag && *startTLSFlag},
+		Persona: *personaFlag,
+		Auth: config.Auth{Accept: *authOk, Users: strings.Split(*usersFlag, ","),
+			NTLMDomain: *ntlmDomainFlag, NTLMDNSDomain: *ntlmDNSFlag},
+		Spool: *spoolFlag,
+		Decoy: config.Decoy{Generate: *decoyFlag, Mailboxes: map[string]string{},
+			CanaryURL: *canaryURLFlag, CanaryKey: *canaryKeyFlag},
+	}
+	pers, err := persona.Find("imap", *personaFlag)
+	if err != nil {
+		fmt.Printf("-persona ERROR: %v\n", err)
+		return
+	}
+	capSet := pers == persona.Default("imap")
+	fs.Visit(func(f *flag.Flag) { capSet = capSet || f.Name == "cap" })
+	if capSet {
+		l.Capability = *capFlag
+	}
+	for _, m := range strings.Split(*mailboxFlag, ",") {
+		if m = strings.TrimSpace(m); m == "" {
+			continue
+		}
+		if i := strings.IndexByte(m, '='); i >= 0 {
+			l.Decoy.Mailboxes[m[:i]] = m[i+1:]
+		} else {
+			l.Decoy.Mailbox = m
+		}
+	}
+	runIMAP(&config.Config{
+		Sensor:    *sensorFlag,
+		Debug:     *debugFlag,
+		Quiet:     *quietFlag,
+		Outputs:   map[string]*output.Flags{"flags": outputFlags},
+		Listeners: []*config.Listener{l},
+	})
+}
+
+func runIMAP(c *config.Config) {
+	if err := config.Run(c, "imaphoney", map[string]config.Starter{"imap": StartIMAP}); err != nil {
+		fmt.Printf("config ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"imap-honey/config"
+	"imap-honey/output"
+	"imap-honey/persona"
+)
+
+// SMTP runs the smtphoney command line, name is used in its usage
+func SMTP(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+
+	fmt.Printf("Version: %s\n", Version)
+	hostnameFlag := fs.String("hostname", "localhost", "hostname")
+	addressFlag := fs.String("addr", ":1993", "ipaddr:port")
+	certFlag := fs.String("cert", "", "cert file")
+	keyFlag := fs.String("key", "", "cert file")
+	var capFlag string
+	fs.StringVar(&capFlag, "cap", "250-localhost;250-PIPELINING;250-SIZE 5242880;250-ETRN;250 8BITMIME;250 DSN;", "smtp CAPABILITY")
+	startTLSFlag := fs.Bool("starttls", false, "offer STARTTLS on a plaintext port instead of implicit TLS (with -cert and -key)")
+	ntlmDomainFlag := fs.String("ntlm-domain", "", "NetBIOS domain in NTLM challenges (default from -hostname)")
+	ntlmDNSFlag := fs.String("ntlm-dns-domain", "", "DNS domain in NTLM challenges (default from -hostname)")
+	logAuthFlag := fs.Bool("la", false, "log auth")
+	logDataFlag := fs.Bool("ld", false, "log data")
+	authOk := fs.Bool("aok", false, "auth ok")
+	spoolFlag := fs.String("spool", "", "spool directory for captured messages (with -ld)")
+	debugFlag := fs.Bool("d", false, "debug")
+	quietFlag := fs.Bool("q", false, "quiet - no msg in console")
+	sensorFlag := fs.String("sensor", "", "sensor ID in events (default hostname)")
+	configFlag := fs.String("config", "", "YAML configuration file of all listeners, instead of the flags")
+	checkConfigFlag := fs.Bool("check-config", false, "validate the -config file and exit")
+	personaFlag := fs.String("persona", "smtphoney", "server product to mimic or YAML/JSON persona file, \"list\" lists them")
+	outputFlags := output.RegisterFlags(fs)
+	fs.Parse(args)
+
+	if *personaFlag == "list" {
+		for _, name := range persona.Names("smtp") {
+			p, _ := persona.Get("smtp", name)
+			fmt.Printf("%-10s %s\n", p.Name, p.Product)
+		}
+		return
+	}
+	if *configFlag != "" {
+		c, err := config.Load(*configFlag)
+		if err != nil {
+			fmt.Printf("config ERROR:\n%v\n", err)
+			os.Exit(1)
+		}
+		if *checkConfigFlag {
+			fmt.Printf("%s: OK, %d listeners\n", *configFlag, len(c.Listeners))
+			return
+		}
+		c.Debug = c.Debug || *debugFlag
+		c.Quiet = c.Quiet || *quietFlag
+		runSMTP(c)
+		return
+	} else if *checkConfigFlag {
+		fmt.Printf("-check-config ERROR: needs -config\n")
+		os.Exit(1)
+	}
+
+	// the flags describe a single listener
+	if *startTLSFlag && (*certFlag == "" || *keyFlag == "") {
+		fmt.Printf("-starttls ERROR: needs -cert and -key\n")
+		return
+	}
+	l := &config.Listener{
+		Protocol: "smtp",
+		Addr:     *addressFlag,
+		Hostname: *hostnameFlag,
+		TLS:      config.TLS{Cert: *certFlag, Key: *keyFlag, StartTLS: *startTLSFlag},
+		Persona:  *personaFlag,
+		Auth: config.Auth{Log: *logAuthFlag, Accept: *authOk,
+			NTLMDomain: *ntlmDomainFlag, NTLMDNSDomain: *ntlmDNSFlag},
+		LogData: *logDataFlag,
+		Spool:   *spoolFlag,
+	}
+	pers, err := persona.Find("smtp", *personaFlag)
+	if err != nil {
+		fmt.Printf("-persona ERROR: %v\n", err)
+		return
+	}
+	capSet := pers == persona.Default("smtp")
+	fs.Visit(func(f *flag.Flag) { capSet = capSet || f.Name == "cap" })
+	if capSet {
+		l.Capability = strings.ReplaceAll(capFlag, ";", "\r\n")
+	}
+	runSMTP(&config.Config{
+		Sensor:    *sensorFlag,
+		Debug:     *debugFlag,
+		Quiet:     *quietFlag,
+		Outputs:   map[string]*output.Flags{"flags": outputFlags},
+		Listeners: []*config.Listener{l},
+	})
+}
+
+func runSMTP(c *config.Config) {
+	if err := config.Run(c, "smtphoney", map[string]config.Starter{"smtp": StartSMTP}); err != nil {
+		fmt.Printf("config ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
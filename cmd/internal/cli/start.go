@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"imap-honey/config"
+	"imap-honey/core"
+	"imap-honey/decoy"
+	"imap-honey/event"
+	"imap-honey/imap"
+	"imap-honey/mailbox"
+	"imap-honey/persona"
+	"imap-honey/smtp"
+	"imap-honey/spool"
+)
+
+// Starters open the listeners of both protocols, see config.Run
+var Starters = map[string]config.Starter{"imap": StartIMAP, "smtp": StartSMTP}
+
+// listenerTLS loads the certificate of a listener, nil without TLS
+func listenerTLS(l *config.Listener) (*tls.Config, error) {
+	if l.TLSMode() == "off" {
+		return nil, nil
+	}
+	c, err := core.LoadTLS(l.TLS.Cert, l.TLS.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", l, err)
+	}
+	return c, nil
+}
+
+// imapOptions translates an IMAP listener to server options
+func imapOptions(l *config.Listener, logger *event.Logger, debug bool) ([]imap.Option, error) {
+	pers, err := persona.Find("imap", l.Persona)
+	if err != nil {
+		return nil, err
+	}
+	opts := []imap.Option{
+		imap.WithHostname(l.Hostname),
+		imap.WithPersona(pers),
+		imap.WithLogger(logger),
+		imap.WithNTLMDomain(l.Auth.NTLMDomain, l.Auth.NTLMDNSDomain),
+		imap.WithDecoy(l.Decoy.Generate),
+	}
+	if l.Capability != "" {
+		opts = append(opts, imap.WithCapability(l.Capability))
+	}
+	if debug {
+		opts = append(opts, imap.WithDebug())
+	}
+	tlsConfig, err := listenerTLS(l)
+	if err != nil {
+		return nil, err
+	}
+	switch l.TLSMode() {
+	case "implicit":
+		opts = append(opts, imap.WithTLS(tlsConfig))
+	case "starttls":
+		opts = append(opts, imap.WithStartTLS(tlsConfig))
+	}
+	if l.TLS.LoginDisabled {
+		opts = append(opts, imap.WithLoginDisabled())
+	}
+	if l.Auth.Accept {
+		opts = append(opts, imap.WithAccept(l.Auth.Users...))
+	}
+	canaryURL := l.Decoy.CanaryURL
+	if canaryURL == "" {
+		canaryURL = "https://" + l.Hostname + "/s/"
+	}
+	opts = append(opts, imap.WithCanary(decoy.NewCanary(l.Decoy.CanaryKey, canaryURL)))
+	if l.Decoy.Mailbox != "" {
+		store, err := mailbox.Load(l.Decoy.Mailbox)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, imap.WithStore("", store))
+	}
+	for user, path := range l.Decoy.Mailboxes {
+		store, err := mailbox.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, imap.WithStore(user, store))
+	}
+	if l.Spool != "" {
+		sp, err := spool.New(l.Spool)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, imap.WithSpool(sp))
+	}
+	return opts, nil
+}
+
+// StartIMAP opens an IMAP listener of a configuration
+func StartIMAP(l *config.Listener, logger *event.Logger, debug bool) (config.Server, error) {
+	opts, err := imapOptions(l, logger, debug)
+	if err != nil {
+		return nil, err
+	}
+	s, err := imap.New(l.Addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Listen(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// smtpOptions translates an SMTP listener to server options
+func smtpOptions(l *config.Listener, logger *event.Logger, debug bool) ([]smtp.Option, error) {
+	pers, err := persona.Find("smtp", l.Persona)
+	if err != nil {
+		return nil, err
+	}
+	opts := []smtp.Option{
+		smtp.WithHostname(l.Hostname),
+		smtp.WithPersona(pers),
+		smtp.WithLogger(logger),
+		smtp.WithNTLMDomain(l.Auth.NTLMDomain, l.Auth.NTLMDNSDomain),
+	}
+	if l.Capability != "" {
+		opts = append(opts, smtp.WithCapability(l.Capability))
+	}
+	if debug {
+		opts = append(opts, smtp.WithDebug())
+	}
+	tlsConfig, err := listenerTLS(l)
+	if err != nil {
+		return nil, err
+	}
+	switch l.TLSMode() {
+	case "implicit":
+		opts = append(opts, smtp.WithTLS(tlsConfig))
+	case "starttls":
+		opts = append(opts, smtp.WithStartTLS(tlsConfig))
+	}
+	if l.Auth.Log {
+		opts = append(opts, smtp.WithAuth(l.Auth.Accept))
+	}
+	if l.LogData {
+		opts = append(opts, smtp.WithData())
+	}
+	if l.Spool != "" {
+		sp, err := spool.New(l.Spool)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, smtp.WithSpool(sp))
+	}
+	return opts, nil
+}
+
+// StartSMTP opens an SMTP listener of a configuration
+func StartSMTP(l *config.Listener, logger *event.Logger, debug bool) (config.Server, error) {
+	opts, err := smtpOptions(l, logger, debug)
+	if err != nil {
+		return nil, err
+	}
+	s, err := smtp.New(l.Addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Listen(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
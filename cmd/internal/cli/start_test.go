@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"imap-honey/config"
+	"imap-honey/event"
+	"imap-honey/imap"
+	"imap-honey/smtp"
+)
+
+func TestOptions(t *testing.T) {
+	c, err := config.Load("../../../config/testdata/honey.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := &event.Logger{Quiet: true}
+	for _, l := range c.Listeners {
+		var err error
+		switch l.Protocol {
+		case "imap":
+			var opts []imap.Option
+			if opts, err = imapOptions(l, logger, false); err == nil {
+				_, err = imap.New(l.Addr, opts...)
+			}
+		case "smtp":
+			var opts []smtp.Option
+			if opts, err = smtpOptions(l, logger, true); err == nil {
+				_, err = smtp.New(l.Addr, opts...)
+			}
+		}
+		if err != nil {
+			t.Errorf("%s: %v", l, err)
+		}
+	}
+
+	l := c.Listeners[0]
+	l.TLS.Cert = "missing.pem"
+	if _, err := StartSMTP(l, logger, false); err == nil || !strings.HasPrefix(err.Error(), "smtp: ") {
+		t.Errorf("missing certificate: %v", err)
+	}
+	l.Persona = "qmail"
+	if _, err := StartSMTP(l, logger, false); err == nil {
+		t.Errorf("unknown persona: no error")
+	}
+}
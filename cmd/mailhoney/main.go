@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"imap-honey/cmd/internal/cli"
+	"imap-honey/config"
+)
+
+var Version string
+
+/**
+
+USAGE
+
+./mailhoney -config honey.yaml      all the listeners of honey.yaml
+./mailhoney imap -addr :143         same as imaphoney
+./mailhoney smtp -addr :25 -la      same as smtphoney
+
+**/
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "imap":
+			cli.Version = Version
+			cli.IMAP(os.Args[0]+" imap", os.Args[2:])
+			return
+		case "smtp":
+			cli.Version = Version
+			cli.SMTP(os.Args[0]+" smtp", os.Args[2:])
+			return
+		}
+	}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configFlag := fs.String("config", "", "YAML configuration file of all listeners")
+	checkConfigFlag := fs.Bool("check-config", false, "validate the -config file and exit")
+	debugFlag := fs.Bool("d", false, "debug")
+	quietFlag := fs.Bool("q", false, "quiet - no msg in console")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nSubcommands, with the flags of the single protocol binaries:\n")
+		fmt.Fprintf(fs.Output(), "  imap\tserve IMAP like imaphoney\n  smtp\tserve SMTP like smtphoney\n")
+	}
+	fs.Parse(os.Args[1:])
+
+	fmt.Printf("Version: %s\n", Version)
+	if *configFlag == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	c, err := config.Load(*configFlag)
+	if err != nil {
+		fmt.Printf("config ERROR:\n%v\n", err)
+		os.Exit(1)
+	}
+	if *checkConfigFlag {
+		fmt.Printf("%s: OK, %d listeners\n", *configFlag, len(c.Listeners))
+		return
+	}
+	c.Debug = c.Debug || *debugFlag
+	c.Quiet = c.Quiet || *quietFlag
+	err = config.Run(c, "mailhoney", cli.Starters)
+	if err != nil {
+		fmt.Printf("config ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
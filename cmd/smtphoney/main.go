@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+
+	"imap-honey/cmd/internal/cli"
+)
+
+var Version string
+
+/**
+
+USAGE
+
+openssl genrsa -out server.key 2048
+openssl req -new -x509 -sha256 -key server.key -out server.pem -days 3650
+
+./honey -d -cert server.pem -key server.key -addr :9443 -server server:514
+
+**/
+func main() {
+	cli.Version = Version
+	cli.SMTP(os.Args[0], os.Args[1:])
+}
@@ -0,0 +1,416 @@
+// Package config reads the YAML file describing every listener of a
+// sensor: protocol, address, TLS, persona, auth policy and outputs
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"imap-honey/output"
+	"imap-honey/persona"
+)
+
+// Config is a whole configuration file
+//
+//	sensor: mx-paris-01
+//	outputs:
+//	  siem: {log_format: json, server: "siem:6514", syslog_proto: tls}
+//	listeners:
+//	  - protocol: smtp
+//	    addr: :25
+//	    persona: postfix
+//	    auth: {log: true}
+type Config struct {
+	Sensor    string                   `yaml:"sensor"`
+	Debug     bool                     `yaml:"debug"`
+	Quiet     bool                     `yaml:"quiet"`
+	Metrics   string                   `yaml:"metrics"` // address serving /metrics
+	Outputs   map[string]*output.Flags `yaml:"outputs"` // default local syslog
+	Listeners []*Listener              `yaml:"listeners"`
+}
+
+// Listener is one honeypot port
+type Listener struct {
+	Name       string   `yaml:"name"`     // in messages, default protocol/addr
+	Protocol   string   `yaml:"protocol"` // imap or smtp
+	Addr       string   `yaml:"addr"`
+	Hostname   string   `yaml:"hostname"`
+	TLS        TLS      `yaml:"tls"`
+	Persona    string   `yaml:"persona"`    // name or persona file
+	Capability string   `yaml:"capability"` // overrides the persona's, EHLO lines for SMTP
+	Auth       Auth     `yaml:"auth"`
+	LogData    bool     `yaml:"log_data"` // SMTP: accept and log DATA
+	Spool      string   `yaml:"spool"`
+	Decoy      Decoy    `yaml:"decoy"`   // IMAP
+	Outputs    []string `yaml:"outputs"` // default all
+
+	node *yaml.Node
+}
+
+// TLS is implicit unless StartTLS is set, off without a certificate
+type TLS struct {
+	Cert          string `yaml:"cert"`
+	Key           string `yaml:"key"`
+	StartTLS      bool   `yaml:"starttls"`
+	LoginDisabled bool   `yaml:"login_disabled"` // IMAP: refuse LOGIN before STARTTLS
+}
+
+// Auth is the authentication policy
+type Auth struct {
+	Log           bool     `yaml:"log"`    // SMTP: offer AUTH, IMAP always does
+	Accept        bool     `yaml:"accept"` // report logins as successful
+	Users         []string `yaml:"users"`  // IMAP: logins accepted, user or user:password, default all
+	NTLMDomain    string   `yaml:"ntlm_domain"`
+	NTLMDNSDomain string   `yaml:"ntlm_dns_domain"`
+}
+
+// Decoy is the mailbox served to accepted IMAP logins
+type Decoy struct {
+	Mailbox   string            `yaml:"mailbox"`   // Maildir or mbox, default built-in
+	Mailboxes map[string]string `yaml:"mailboxes"` // per user
+	Generate  int               `yaml:"generate"`  // months of generated mail instead of the built-in mailbox
+	CanaryURL string            `yaml:"canary_url"`
+	CanaryKey string            `yaml:"canary_key"`
+}
+
+// TLSMode is "implicit", "starttls" or "off"
+func (l *Listener) TLSMode() string {
+	switch {
+	case l.TLS.Cert == "" || l.TLS.Key == "":
+		return "off"
+	case l.TLS.StartTLS:
+		return "starttls"
+	}
+	return "implicit"
+}
+
+func (l *Listener) String() string {
+	if l.Name != "" {
+		return l.Name
+	}
+	return l.Protocol + "/" + l.Addr
+}
+
+// Error is a configuration error at a line of a file
+type Error struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg) }
+
+// Errors are all the errors of a file
+type Errors []*Error
+
+func (e Errors) Error() string {
+	var l []string
+	for _, err := range e {
+		l = append(l, err.Error())
+	}
+	return strings.Join(l, "\n")
+}
+
+// flagName matches the -flag-names in output errors, keys in a file
+var flagName = regexp.MustCompile(`\B-[a-z]+(-[a-z]+)*`)
+
+// Load reads and validates a configuration file, relative paths in it are
+// relative to the file. The error is an Errors listing every problem.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, b)
+}
+
+// Parse reads and validates a configuration, path is used in errors and
+// to resolve relative paths
+func Parse(path string, b []byte) (*Config, error) {
+	c := &Config{}
+	var errs Errors
+	errorf := func(line int, format string, a ...interface{}) {
+		errs = append(errs, &Error{path, line, fmt.Sprintf(format, a...)})
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		for _, e := range yamlErrors(err) {
+			errorf(e.Line, "%s", e.Msg)
+		}
+		return nil, errs
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		errorf(root.Line, "configuration is not a mapping")
+		return nil, errs
+	}
+	doc := root.Content[0]
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		for _, e := range yamlErrors(err) {
+			errorf(e.Line, "%s", e.Msg)
+		}
+		return nil, errs
+	}
+	// outputs start from the flag defaults
+	if outputs := value(doc, "outputs"); outputs != nil && outputs.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(outputs.Content); i += 2 {
+			f := output.DefaultFlags()
+			outputs.Content[i+1].Decode(f)
+			c.Outputs[outputs.Content[i].Value] = f
+		}
+	}
+	if l := value(doc, "listeners"); l != nil && l.Kind == yaml.SequenceNode {
+		for i, n := range l.Content {
+			if i < len(c.Listeners) && c.Listeners[i] != nil {
+				c.Listeners[i].node = n
+			}
+		}
+	}
+
+	dir := filepath.Dir(path)
+	rel := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	outputs := value(doc, "outputs")
+	for _, name := range c.outputNames() {
+		f := c.Outputs[name]
+		f.File, f.SyslogCA, f.QueueDir, f.WebhookTemplate = rel(f.File), rel(f.SyslogCA), rel(f.QueueDir), rel(f.WebhookTemplate)
+		if err := f.Check(); err != nil {
+			errorf(line(outputs, name), "output %s: %s", name, flagName.ReplaceAllStringFunc(err.Error(), func(f string) string {
+				return strings.ReplaceAll(strings.TrimPrefix(f, "-"), "-", "_")
+			}))
+		}
+	}
+
+	if len(c.Listeners) == 0 {
+		errorf(line(doc, "listeners"), "no listeners")
+	}
+	addrs := map[string]*Listener{}
+	for _, l := range c.Listeners {
+		if l == nil {
+			errorf(line(doc, "listeners"), "empty listener")
+			continue
+		}
+		at := func(keys ...string) int { return line(l.node, keys...) }
+		fail := func(line int, format string, a ...interface{}) {
+			errorf(line, "%s: %s", l, fmt.Sprintf(format, a...))
+		}
+
+		switch l.Protocol {
+		case "imap", "smtp":
+		case "":
+			fail(at("protocol"), "protocol is missing")
+			continue
+		default:
+			fail(at("protocol"), "protocol %q is not imap or smtp", l.Protocol)
+			continue
+		}
+		if l.Addr == "" {
+			fail(at("addr"), "addr is missing")
+		} else if other, ok := addrs[l.Addr]; ok {
+			fail(at("addr"), "addr %s already used by %s", l.Addr, other)
+		} else {
+			addrs[l.Addr] = l
+		}
+		if l.Hostname == "" {
+			l.Hostname = "localhost"
+		}
+
+		l.TLS.Cert, l.TLS.Key = rel(l.TLS.Cert), rel(l.TLS.Key)
+		switch {
+		case (l.TLS.Cert == "") != (l.TLS.Key == ""):
+			fail(at("tls"), "tls needs both cert and key")
+		case l.TLS.Cert != "":
+			if _, err := tls.LoadX509KeyPair(l.TLS.Cert, l.TLS.Key); err != nil {
+				fail(at("tls", "cert"), "%v", err)
+			}
+		case l.TLS.StartTLS:
+			fail(at("tls", "starttls"), "starttls needs a cert and key")
+		}
+		if l.TLS.LoginDisabled && !l.TLS.StartTLS {
+			fail(at("tls", "login_disabled"), "login_disabled needs starttls")
+		}
+
+		if l.Persona == "" {
+			l.Persona = persona.Default(l.Protocol).Name
+		}
+		if persona.IsFile(l.Persona) {
+			l.Persona = rel(l.Persona)
+		}
+		if _, err := persona.Find(l.Protocol, l.Persona); err != nil {
+			fail(at("persona"), "%v", err)
+		}
+		if l.Capability != "" {
+			if err := persona.Check(l.Capability); err != nil {
+				fail(at("capability"), "capability: %v", err)
+			}
+		}
+
+		l.Spool = rel(l.Spool)
+		l.Decoy.Mailbox = rel(l.Decoy.Mailbox)
+		for user, p := range l.Decoy.Mailboxes {
+			l.Decoy.Mailboxes[user] = rel(p)
+		}
+		switch l.Protocol {
+		case "imap":
+			if l.Auth.Log {
+				fail(at("auth", "log"), "IMAP always logs logins, auth.log is for SMTP")
+			}
+			if l.LogData {
+				fail(at("log_data"), "log_data is for SMTP, IMAP spools APPENDed messages")
+			}
+			if l.Decoy.Generate < 0 {
+				fail(at("decoy", "generate"), "decoy.generate is negative")
+			}
+			for _, p := range append([]string{l.Decoy.Mailbox}, values(l.Decoy.Mailboxes)...) {
+				if _, err := os.Stat(p); p != "" && err != nil {
+					fail(at("decoy"), "%v", err)
+				}
+			}
+		case "smtp":
+			if len(l.Auth.Users) > 0 {
+				fail(at("auth", "users"), "auth.users is for IMAP")
+			}
+			if l.TLS.LoginDisabled {
+				fail(at("tls", "login_disabled"), "login_disabled is for IMAP")
+			}
+			if value(l.node, "decoy") != nil {
+				fail(at("decoy"), "decoy is for IMAP")
+			}
+		}
+
+		for i, name := range l.Outputs {
+			if _, ok := c.Outputs[name]; !ok {
+				n := value(l.node, "outputs")
+				ln := at("outputs")
+				if n != nil && i < len(n.Content) {
+					ln = n.Content[i].Line
+				}
+				errorf(ln, "%s: unknown output %q", l, name)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if c.Outputs == nil {
+		c.Outputs = map[string]*output.Flags{"default": output.DefaultFlags()}
+	}
+	return c, nil
+}
+
+// Protocol are the listeners of a protocol
+func (c *Config) Protocol(protocol string) []*Listener {
+	var l []*Listener
+	for _, ln := range c.Listeners {
+		if ln.Protocol == protocol {
+			l = append(l, ln)
+		}
+	}
+	return l
+}
+
+// OutputNames are the outputs of a listener, all by default
+func (c *Config) OutputNames(l *Listener) []string {
+	if len(l.Outputs) > 0 {
+		return l.Outputs
+	}
+	return c.outputNames()
+}
+
+func (c *Config) outputNames() []string {
+	var names []string
+	for name := range c.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// value is the node of a key path in YAML mappings, nil if there is none
+func value(n *yaml.Node, keys ...string) *yaml.Node {
+	for _, key := range keys {
+		if n == nil || n.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				next = n.Content[i+1]
+			}
+		}
+		n = next
+	}
+	return n
+}
+
+// line is the line of the deepest key of a path found in YAML mappings
+func line(n *yaml.Node, keys ...string) int {
+	if n == nil {
+		return 1
+	}
+	at := n.Line
+	for _, key := range keys {
+		if n.Kind != yaml.MappingNode {
+			break
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				at, next = n.Content[i].Line, n.Content[i+1]
+			}
+		}
+		if next == nil {
+			break
+		}
+		n = next
+	}
+	return at
+}
+
+// yamlErrors splits "yaml: line 3: ..." messages
+func yamlErrors(err error) []*Error {
+	var msgs []string
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = te.Errors
+	} else {
+		msgs = []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+	var errs []*Error
+	for _, m := range msgs {
+		e := &Error{Line: 1, Msg: m}
+		if n, _ := fmt.Sscanf(m, "line %d: ", &e.Line); n == 1 {
+			e.Msg = m[strings.Index(m, ": ")+2:]
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+func values(m map[string]string) []string {
+	var keys, l []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		l = append(l, m[k])
+	}
+	return l
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"imap-honey/event"
+)
+
+func TestLoad(t *testing.T) {
+	c, err := Load("testdata/honey.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Sensor != "mx-paris-01" || len(c.Listeners) != 3 || len(c.Protocol("smtp")) != 2 {
+		t.Fatalf("config %+v", c)
+	}
+
+	local := c.Outputs["local"]
+	if !local.JSON() || local.File != "testdata/honey.json" || local.FileKeep != 7 || !local.FileCompress {
+		t.Errorf("local output %+v", local)
+	}
+	if w := c.Outputs["alerts"]; w.WebhookBatchWait != 30*time.Second || w.WebhookRate != 1 || w.JSON() {
+		t.Errorf("alerts output %+v", w)
+	}
+
+	smtp, submission, imaps := c.Listeners[0], c.Listeners[1], c.Listeners[2]
+	if smtp.TLSMode() != "starttls" || smtp.TLS.Cert != "testdata/server.pem" || !smtp.Auth.Log || !smtp.LogData {
+		t.Errorf("smtp listener %+v", smtp)
+	}
+	if got := strings.Join(c.OutputNames(smtp), ","); got != "local,siem" {
+		t.Errorf("smtp outputs %s", got)
+	}
+	if got := strings.Join(c.OutputNames(submission), ","); got != "alerts,local,siem" {
+		t.Errorf("submission outputs %s", got)
+	}
+	if imaps.TLSMode() != "implicit" || imaps.Persona != "dovecot" || !imaps.Auth.Accept || len(imaps.Auth.Users) != 2 || imaps.Decoy.Generate != 6 {
+		t.Errorf("imaps listener %+v", imaps)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var tests = []struct {
+		config string
+		errs   []string
+	}{
+		{"listeners:\n  - protocol: pop3\n    addr: :110\n", []string{"2: pop3/:110: protocol \"pop3\" is not imap or smtp"}},
+		{"sensor: x\n", []string{"1: no listeners"}},
+		{"listeners:\n  - protocol: imap\n    adr: :143\n", []string{"3: field adr not found in type config.Listener"}},
+		{"listeners:\n  - protocol: imap\n    addr: :143\n    tls:\n      starttls: yes\n      login_disabled: true\n",
+			[]string{"5: imap/:143: starttls needs a cert and key"}},
+		{"listeners:\n  - {protocol: imap, addr: ':143', tls: {login_disabled: true}}\n",
+			[]string{"2: imap/:143: login_disabled needs starttls"}},
+		{"listeners:\n  - protocol: smtp\n    addr: :25\n  - name: second\n    protocol: imap\n    addr: :25\n",
+			[]string{"6: second: addr :25 already used by smtp/:25"}},
+		{"listeners:\n  - protocol: smtp\n    addr: :25\n    persona: qmail\n    auth:\n      users: [joe]\n    decoy: {generate: 1}\n",
+			[]string{"4: smtp/:25: unknown smtp persona \"qmail\"", "6: smtp/:25: auth.users is for IMAP", "7: smtp/:25: decoy is for IMAP"}},
+		{"listeners:\n  - protocol: imap\n    addr: :143\n    tls: {cert: server.pem}\n    persona: nothere.yaml\n",
+			[]string{"4: imap/:143: tls needs both cert and key", "5: imap/:143: open testdata/nothere.yaml"}},
+		{"outputs:\n  a: {log_format: xml}\n  b:\n    syslog_proto: quic\nlisteners:\n  - protocol: imap\n    addr: :143\n    outputs: [a,\n      c]\n",
+			[]string{"2: output a: unknown log_format \"xml\"", "3: output b: unknown syslog_proto \"quic\"", "9: imap/:143: unknown output \"c\""}},
+		{"outputs:\n  a: {log_keep: many}\n", []string{"2: cannot unmarshal !!str `many` into int"}},
+		{"sensor: x\nlisteners:\n\t- protocol: imap\n", []string{"3: found character that cannot start any token"}},
+		{"sensor: x\nquiet: true\nsensor: y\n", []string{"3: mapping key \"sensor\" already defined at line 1"}},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse("testdata/test.yaml", []byte(tt.config))
+		errs, _ := err.(Errors)
+		if len(errs) != len(tt.errs) {
+			t.Errorf("%q: %v, want %q", tt.config, err, tt.errs)
+			continue
+		}
+		for i, e := range errs {
+			if !strings.HasPrefix(e.Error(), "testdata/test.yaml:"+tt.errs[i]) {
+				t.Errorf("%q: %v, want %s", tt.config, e, tt.errs[i])
+			}
+		}
+	}
+}
+
+type fakeServer struct {
+	served chan bool
+	err    error
+}
+
+func (s *fakeServer) Serve() error { s.served <- true; return s.err }
+func (s *fakeServer) Close()       {}
+
+// blockingServer serves until closed
+type blockingServer struct{ stop chan struct{} }
+
+func (s *blockingServer) Serve() error { <-s.stop; return nil }
+func (s *blockingServer) Close()       { close(s.stop) }
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Parse(dir+"/honey.yaml", []byte("quiet: true\noutputs:\n  file: {log_format: json, log_file: events.json}\n"+
+		"listeners:\n  - {protocol: imap, addr: ':143'}\n  - {protocol: smtp, addr: ':25'}\n  - {protocol: smtp, addr: ':587', name: submission}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan bool, 3)
+	var started []string
+	start := func(l *Listener, logger *event.Logger, debug bool) (Server, error) {
+		started = append(started, l.String())
+		logger.Log(event.New(event.Login, l.Protocol, nil))
+		return &fakeServer{served: served}, nil
+	}
+	if err := Run(c, "honeytest", map[string]Starter{"imap": start, "smtp": start}); err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 3 || strings.Join(started, ",") != "imap/:143,smtp/:25,submission" {
+		t.Errorf("started %q, served %d", started, len(served))
+	}
+	b, _ := os.ReadFile(dir + "/events.json")
+	if strings.Count(string(b), `"event":"login"`) != 3 {
+		t.Errorf("events.json %s", b)
+	}
+
+	err = Run(c, "honeytest", map[string]Starter{"pop3": start})
+	if err == nil {
+		t.Errorf("Run() without listeners")
+	}
+
+	c.Metrics = "localhost:-1"
+	started = nil
+	if err := Run(c, "honeytest", map[string]Starter{"imap": start}); err == nil || !strings.HasPrefix(err.Error(), "metrics: ") {
+		t.Errorf("Run() with a bad metrics address: %v", err)
+	}
+	if len(started) != 1 || len(served) != 3 {
+		t.Errorf("started %q, served %d", started, len(served))
+	}
+
+	c.Metrics = ""
+	blocking := &blockingServer{make(chan struct{})}
+	starters := map[string]Starter{
+		"imap": func(l *Listener, logger *event.Logger, debug bool) (Server, error) { return blocking, nil },
+		"smtp": func(l *Listener, logger *event.Logger, debug bool) (Server, error) {
+			if l.Name == "submission" {
+				return &fakeServer{served: make(chan bool, 1), err: errors.New("accept failed")}, nil
+			}
+			return &blockingServer{make(chan struct{})}, nil
+		},
+	}
+	if err := Run(c, "honeytest", starters); err == nil || err.Error() != "submission: accept failed" {
+		t.Errorf("Run() with a failing listener: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"time"
+
+	"imap-honey/event"
+	"imap-honey/output"
+)
+
+// Outputs are the running outputs of a configuration
+type Outputs struct {
+	config *Config
+	fans   map[string]*output.Fanout
+}
+
+// Start builds the outputs used by the listeners of the protocols given,
+// appName tags syslog messages
+func (c *Config) Start(appName string, protocols ...string) (*Outputs, error) {
+	o := &Outputs{config: c, fans: map[string]*output.Fanout{}}
+	for _, protocol := range protocols {
+		for _, l := range c.Protocol(protocol) {
+			for _, name := range c.OutputNames(l) {
+				if o.fans[name] != nil {
+					continue
+				}
+				fan, err := c.Outputs[name].Build(appName)
+				if err != nil {
+					o.Close(0)
+					return nil, err
+				}
+				o.fans[name] = fan
+			}
+		}
+	}
+	return o, nil
+}
+
+// Logger sends the events of a listener to its outputs
+func (o *Outputs) Logger(l *Listener) *event.Logger {
+	logger := &event.Logger{Sensor: o.config.Sensor, Quiet: o.config.Quiet}
+	if logger.Sensor == "" {
+		logger.Sensor, _ = os.Hostname()
+	}
+	for _, name := range o.config.OutputNames(l) {
+		logger.JSON = logger.JSON || o.config.Outputs[name].JSON()
+		logger.Outputs = append(logger.Outputs, o.fans[name])
+	}
+	return logger
+}
+
+// all sends the events of the process itself to every output
+func (o *Outputs) all() *event.Logger {
+	logger := &event.Logger{Sensor: o.config.Sensor, Quiet: o.config.Quiet}
+	if logger.Sensor == "" {
+		logger.Sensor, _ = os.Hostname()
+	}
+	for name, fan := range o.fans {
+		logger.JSON = logger.JSON || o.config.Outputs[name].JSON()
+		logger.Outputs = append(logger.Outputs, fan)
+	}
+	return logger
+}
+
+// Reopen reopens the log files, on SIGHUP
+func (o *Outputs) Reopen() error {
+	var first error
+	for _, fan := range o.fans {
+		if err := fan.Reopen(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close flushes the outputs, waiting up to timeout
+func (o *Outputs) Close(timeout time.Duration) {
+	for _, fan := range o.fans {
+		fan.Close(timeout)
+	}
+}
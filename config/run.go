@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"imap-honey/event"
+	"imap-honey/metrics"
+)
+
+// Server is a listener opened by its protocol package
+type Server interface {
+	Serve() error
+	Close()
+}
+
+// Starter opens the listener l of a protocol, logging to logger
+type Starter func(l *Listener, logger *event.Logger, debug bool) (Server, error)
+
+// Run serves the listeners of the protocols of starters until they all
+// stop, with shared outputs, metrics and session IDs. The first Serve
+// error closes the other listeners and is returned.
+func Run(c *Config, appName string, starters map[string]Starter) error {
+	var protocols []string
+	for protocol := range starters {
+		protocols = append(protocols, protocol)
+	}
+	outputs, err := c.Start(appName, protocols...)
+	if err != nil {
+		return fmt.Errorf("output: %v", err)
+	}
+	defer outputs.Close(5 * time.Second)
+	hup, done := make(chan os.Signal, 1), make(chan struct{})
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				if err := outputs.Reopen(); err != nil {
+					ev := event.New(event.Error, "", nil).Set("error", err.Error())
+					ev.Text = fmt.Sprintf("REOPEN ERROR: %v", err)
+					outputs.all().Log(ev)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	m := metrics.New()
+	for name, fan := range outputs.fans {
+		m.AddDropped(name, fan.Dropped)
+	}
+	var servers []Server
+	var names []string // listener of each server
+	closeAll := func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+	for _, l := range c.Listeners {
+		start, ok := starters[l.Protocol]
+		if !ok {
+			continue
+		}
+		logger := outputs.Logger(l)
+		logger.Outputs = append(logger.Outputs, m.Output(l.String()))
+		s, err := start(l, logger, c.Debug)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("%s: %v", l, err)
+		}
+		servers, names = append(servers, s), append(names, l.String())
+	}
+	if len(servers) == 0 {
+		return fmt.Errorf("no %v listener", protocols)
+	}
+	if c.Metrics != "" {
+		ln, err := net.Listen("tcp", c.Metrics)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("metrics: %v", err)
+		}
+		defer ln.Close()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m)
+		go http.Serve(ln, mux)
+	}
+
+	// the first listener failing stops the others
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+	for i, s := range servers {
+		wg.Add(1)
+		go func(name string, s Server) {
+			defer wg.Done()
+			if err := s.Serve(); err != nil {
+				once.Do(func() {
+					first = fmt.Errorf("%s: %v", name, err)
+					closeAll()
+				})
+			}
+		}(names[i], s)
+	}
+	wg.Wait()
+	return first
+}
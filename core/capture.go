@@ -0,0 +1,73 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"imap-honey/event"
+	"imap-honey/indicator"
+	"imap-honey/mailparse"
+	"imap-honey/spool"
+)
+
+// Session is what the captures need of an imap or smtp session
+type Session interface {
+	Event(typ string, text string) *event.Event
+	Emit(ev *event.Event)
+	RemoteIP() string
+}
+
+// Store writes a message received by sess to sp, if any, with meta as
+// its sidecar
+func Store(sess Session, sp *spool.Spool, meta *spool.Meta, data []byte) {
+	if sp == nil {
+		return
+	}
+	path, err := sp.Store(meta, data)
+	if err != nil {
+		sess.Emit(sess.Event(event.Error, fmt.Sprintf("IP: %s, STORE ERROR: %v", sess.RemoteIP(), err)).
+			Set("error", err.Error()))
+		return
+	}
+	sess.Emit(sess.Event(event.Stored, fmt.Sprintf("IP: %s, STORED: %s", sess.RemoteIP(), path)).
+		Set("path", path).Set("sha256", meta.SHA256))
+}
+
+// Extract logs every MIME part of a message received by sess and stores
+// them in sp, if any, then logs indicators found in the subject and text
+// parts
+func Extract(sess Session, sp *spool.Spool, data []byte) {
+	m, err := mailparse.Parse(data)
+	if err != nil {
+		sess.Emit(sess.Event(event.Error, fmt.Sprintf("IP: %s, MIME ERROR: %v", sess.RemoteIP(), err)).
+			Set("error", err.Error()))
+		return
+	}
+	indicators := indicator.Extract(m.Header.Get("Subject"), false)
+	for _, p := range m.Parts {
+		sess.Emit(sess.Event(event.Attachment, fmt.Sprintf("IP: %s, PART: %s", sess.RemoteIP(), p)).
+			Set("filename", p.Filename).Set("content_type", p.ContentType).Set("sniffed_type", p.Sniffed).
+			Set("size", p.Size).Set("md5", p.MD5).Set("sha1", p.SHA1).Set("sha256", p.SHA256).
+			Set("members", p.Members))
+		if strings.HasPrefix(p.ContentType, "text/") {
+			indicators = append(indicators, indicator.Extract(string(p.Data), p.ContentType == "text/html")...)
+		}
+		if sp == nil {
+			continue
+		}
+		if _, err := sp.StoreBlob(p.SHA256, p.Data); err != nil {
+			sess.Emit(sess.Event(event.Error, fmt.Sprintf("IP: %s, STORE ERROR: %v", sess.RemoteIP(), err)).
+				Set("error", err.Error()))
+		}
+	}
+
+	seen := map[indicator.Indicator]bool{}
+	for _, i := range indicators {
+		if seen[i] {
+			continue
+		}
+		seen[i] = true
+		sess.Emit(sess.Event(event.Indicator, fmt.Sprintf("IP: %s, INDICATOR: %s, VALUE: %s", sess.RemoteIP(), i.Type, i.Defanged())).
+			Set("type", i.Type).Set("value", i.Defanged()))
+	}
+}
@@ -0,0 +1,73 @@
+// Package core is shared by the imap and smtp servers and by the programs
+// embedding them: event subscribers and TLS setup
+package core
+
+import (
+	"crypto/tls"
+
+	"imap-honey/event"
+	"imap-honey/sasl"
+)
+
+// Subscriber receives every event of the servers it is given to, from
+// the session goroutines, after the outputs. It must not block.
+type Subscriber interface {
+	Log(ev *event.Event)
+}
+
+// SubscriberFunc is a function receiving events
+type SubscriberFunc func(ev *event.Event)
+
+func (f SubscriberFunc) Log(ev *event.Event) { f(ev) }
+
+// Only passes the events of some types to s
+func Only(s Subscriber, types ...string) Subscriber {
+	set := map[string]bool{}
+	for _, t := range types {
+		set[t] = true
+	}
+	return SubscriberFunc(func(ev *event.Event) {
+		if set[ev.Type] {
+			s.Log(ev)
+		}
+	})
+}
+
+// Credentials calls f with the credentials of every login, whatever the
+// protocol and mechanism
+func Credentials(f func(ev *event.Event, c *sasl.Credentials)) Subscriber {
+	return SubscriberFunc(func(ev *event.Event) {
+		if ev.Type != event.Login {
+			return
+		}
+		c, ok := sasl.FromEvent(ev)
+		if !ok { // events without a mechanism
+			c = &sasl.Credentials{Mechanism: "LOGIN", Authcid: ev.Username, Secret: ev.Password}
+		}
+		f(ev, c)
+	})
+}
+
+// Sessions calls f with the summary of every session once it is closed
+func Sessions(f func(ev *event.Event)) Subscriber {
+	return Only(SubscriberFunc(f), event.Close)
+}
+
+// Subscribers are the subscribers of a server
+type Subscribers []Subscriber
+
+// Log passes ev to every subscriber
+func (l Subscribers) Log(ev *event.Event) {
+	for _, s := range l {
+		s.Log(ev)
+	}
+}
+
+// LoadTLS loads a certificate and key pair as a server TLS configuration
+func LoadTLS(certFile string, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
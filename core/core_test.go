@@ -0,0 +1,99 @@
+package core
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"imap-honey/event"
+	"imap-honey/sasl"
+	"imap-honey/spool"
+)
+
+func TestSubscribers(t *testing.T) {
+	var events []*event.Event
+	var logins []*sasl.Credentials
+	var closed []*event.Event
+	subs := Subscribers{
+		Only(SubscriberFunc(func(ev *event.Event) { events = append(events, ev) }), event.Command, event.Close),
+		Credentials(func(ev *event.Event, c *sasl.Credentials) { logins = append(logins, c) }),
+		Sessions(func(ev *event.Event) { closed = append(closed, ev) }),
+	}
+
+	plain := &event.Event{Type: event.Login, Username: "joe", Password: "secret", Data: map[string]interface{}{"mechanism": "PLAIN", "authcid": "joe"}}
+	login := &event.Event{Type: event.Login, Username: "ann", Password: "pass"}
+	for _, ev := range []*event.Event{
+		{Type: event.Command},
+		plain,
+		login,
+		{Type: event.Close},
+	} {
+		subs.Log(ev)
+	}
+
+	if len(events) != 2 || events[0].Type != event.Command || events[1].Type != event.Close {
+		t.Errorf("Only: %d events", len(events))
+	}
+	var listTests = []struct {
+		mechanism string
+		authcid   string
+		secret    string
+	}{
+		{"PLAIN", "joe", "secret"},
+		{"LOGIN", "ann", "pass"},
+	}
+	if len(logins) != len(listTests) {
+		t.Fatalf("Credentials: %d logins", len(logins))
+	}
+	for i, tt := range listTests {
+		c := logins[i]
+		if c.Mechanism != tt.mechanism || c.Authcid != tt.authcid || c.Secret != tt.secret {
+			t.Errorf("wait: %+v\n receive: %+v\n", tt, c)
+		}
+	}
+	if len(closed) != 1 {
+		t.Errorf("Sessions: %d closed", len(closed))
+	}
+}
+
+func TestLoadTLS(t *testing.T) {
+	c, err := LoadTLS("../config/testdata/server.pem", "../config/testdata/server.key")
+	if err != nil || len(c.Certificates) != 1 {
+		t.Errorf("LoadTLS: %v", err)
+	}
+	if _, err := LoadTLS("missing.pem", "missing.key"); err == nil {
+		t.Errorf("LoadTLS: no error for missing files")
+	}
+}
+
+type session struct{ events []*event.Event }
+
+func (s *session) Event(typ string, text string) *event.Event {
+	return &event.Event{Type: typ, Text: text}
+}
+func (s *session) Emit(ev *event.Event) { s.events = append(s.events, ev) }
+func (s *session) RemoteIP() string     { return "192.0.2.1" }
+
+func TestCapture(t *testing.T) {
+	sp, err := spool.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("Subject: invoice\r\nContent-Type: text/plain\r\n\r\nsee https://evil.example.com/pay\r\n")
+	sess := &session{}
+	Store(sess, sp, &spool.Meta{Protocol: "smtp"}, data)
+	Extract(sess, sp, data)
+	Store(sess, nil, &spool.Meta{}, data)
+
+	var types []string
+	for _, ev := range sess.events {
+		types = append(types, ev.Type)
+	}
+	want := []string{event.Stored, event.Attachment, event.Indicator, event.Indicator} // url and domain
+	if strings.Join(types, " ") != strings.Join(want, " ") {
+		t.Errorf("events %q, want %q", types, want)
+	}
+	if _, err := os.Stat(sess.events[0].Data["path"].(string)); err != nil {
+		t.Errorf("stored message: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+// Package decoy builds synthetic mailboxes and seeds them with canary
+// tokens unique to the session that reads them
+package decoy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"imap-honey/mailbox"
+)
+
+// Placeholders replaced in every message of a served mailbox, generated
+// or loaded with -mailbox
+var Placeholders = []string{"canary_url", "beacon_url", "aws_key_id", "aws_secret", "api_key"}
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type Canary struct {
+	Key []byte // HMAC key deriving the tokens
+	URL string // base of canary and beacon URLs
+}
+
+// NewCanary uses a random key if key is empty
+func NewCanary(key string, url string) *Canary {
+	c := &Canary{Key: []byte(key), URL: url}
+	if key == "" {
+		c.Key = make([]byte, 32)
+		rand.Read(c.Key)
+	}
+	return c
+}
+
+// Token derives the value of the n-th placeholder of a message for a
+// session, so that anyone holding the key can check where it leaked from
+func (c *Canary) Token(session string, mailbox string, uid uint32, kind string, n int) string {
+	h := hmac.New(sha256.New, c.Key)
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s\x00%d", session, mailbox, uid, kind, n)
+	sum := h.Sum(nil)
+	id := strings.ToLower(b32.EncodeToString(sum))
+	switch kind {
+	case "canary_url":
+		return c.URL + id[:20]
+	case "beacon_url":
+		return c.URL + id[:20] + ".gif"
+	case "aws_key_id":
+		return "AKIA" + strings.ToUpper(id[:16])
+	case "aws_secret":
+		return base64.StdEncoding.EncodeToString(sum)[:40]
+	case "api_key":
+		return "sk_live_" + id[:24]
+	}
+	return ""
+}
+
+// Personalize replaces the placeholders of every message of s by tokens
+// of session and records them in the message
+func (c *Canary) Personalize(s *mailbox.Store, session string) {
+	for _, mb := range s.Mailboxes {
+		for _, m := range mb.Messages {
+			data := m.Data
+			for _, kind := range Placeholders {
+				ph := []byte("{{" + kind + "}}")
+				for n := 0; bytes.Contains(data, ph); n++ {
+					v := c.Token(session, mb.Name, m.UID, kind, n)
+					data = bytes.Replace(data, ph, []byte(v), 1)
+					m.Tokens = append(m.Tokens, mailbox.Token{Kind: kind, Value: v})
+				}
+			}
+			m.Data = data
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package decoy
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"imap-honey/mailbox"
+)
+
+func TestPersonaFor(t *testing.T) {
+	p := PersonaFor("jane.miller", "example.com")
+	if p.Name != "Jane Miller" || p.Email != "jane.miller@example.com" || p.Company != "Example" {
+		t.Errorf("PersonaFor() = %+v", p)
+	}
+	p = PersonaFor("Bob_Smith@corp.example.org", "example.com")
+	if p.Name != "Bob Smith" || p.Email != "bob_smith@corp.example.org" || p.Domain != "corp.example.org" {
+		t.Errorf("PersonaFor() = %+v", p)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	p := PersonaFor("jane.miller", "example.com")
+	s := Generate(p, 6, 42)
+	again := Generate(p, 6, 42)
+
+	total := 0
+	for _, mb := range s.Mailboxes {
+		other := again.Get(mb.Name)
+		if other.UIDValidity != mb.UIDValidity || len(other.Messages) != len(mb.Messages) {
+			t.Fatalf("%s not reproducible", mb.Name)
+		}
+		for i, m := range mb.Messages {
+			total++
+			subject := m.Header().Get("Subject")
+			if subject == "" || other.Messages[i].Header().Get("Subject") != subject {
+				t.Errorf("%s UID %d: subject %q", mb.Name, m.UID, subject)
+			}
+			if !bytes.Contains(m.Data, []byte("{{canary_url}}")) {
+				t.Errorf("%s UID %d: no canary URL", mb.Name, m.UID)
+			}
+			if i > 0 && m.InternalDate.Before(mb.Messages[i-1].InternalDate) {
+				t.Errorf("%s UID %d: not in date order", mb.Name, m.UID)
+			}
+		}
+	}
+	if total < 20 || len(s.Get("INBOX").Messages) == 0 || len(s.Get("Sent").Messages) == 0 {
+		t.Errorf("%d messages generated", total)
+	}
+}
+
+func TestPersonalize(t *testing.T) {
+	s := mailbox.NewStore()
+	mb := mailbox.New("INBOX")
+	data := "Subject: keys\n\n{{aws_key_id}} {{aws_secret}}\n{{api_key}}\n{{canary_url}} {{canary_url}} {{beacon_url}}\n"
+	mb.Append(mailbox.NewMessage([]byte(data), time.Now()))
+	s.Add(mb)
+	c := &Canary{Key: []byte("secret"), URL: "https://docs.example.com/s/"}
+
+	one, two := s.Clone(), s.Clone()
+	c.Personalize(one, "01G0EZ1XTM37C5X11SQTDNCTM1")
+	c.Personalize(two, "01G0EZ1XTM37C5X11SQTDNCTM2")
+	m := one.Get("INBOX").Messages[0]
+	if strings.Contains(string(m.Data), "{{") || len(m.Tokens) != 6 {
+		t.Fatalf("placeholders left: %q, tokens %v", m.Data, m.Tokens)
+	}
+	if bytes.Contains(s.Get("INBOX").Messages[0].Data, []byte(m.Tokens[0].Value)) {
+		t.Errorf("Personalize changed the shared store")
+	}
+	formats := map[string]string{
+		"aws_key_id": `^AKIA[A-Z2-7]{16}$`,
+		"aws_secret": `^[A-Za-z0-9+/]{40}$`,
+		"api_key":    `^sk_live_[a-z2-7]{24}$`,
+		"canary_url": `^https://docs\.example\.com/s/[a-z2-7]{20}$`,
+		"beacon_url": `^https://docs\.example\.com/s/[a-z2-7]{20}\.gif$`,
+	}
+	seen := map[string]bool{}
+	for i, tok := range m.Tokens {
+		if !regexp.MustCompile(formats[tok.Kind]).MatchString(tok.Value) {
+			t.Errorf("%s token %q", tok.Kind, tok.Value)
+		}
+		if seen[tok.Value] {
+			t.Errorf("%s token %q not unique", tok.Kind, tok.Value)
+		}
+		seen[tok.Value] = true
+		if other := two.Get("INBOX").Messages[0].Tokens[i]; other.Value == tok.Value {
+			t.Errorf("%s token shared between sessions", tok.Kind)
+		}
+		if !bytes.Contains(m.Data, []byte(tok.Value)) {
+			t.Errorf("%s token %q not in message", tok.Kind, tok.Value)
+		}
+	}
+	if v := c.Token("01G0EZ1XTM37C5X11SQTDNCTM1", "INBOX", 1, "canary_url", 0); v != m.Tokens[0].Value {
+		t.Errorf("Token() = %q, not derivable from the session", v)
+	}
+}
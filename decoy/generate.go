@@ -0,0 +1,224 @@
+package decoy
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"imap-honey/mailbox"
+)
+
+// Persona is the owner of a generated mailbox
+type Persona struct {
+	Name    string
+	Email   string
+	Title   string
+	Company string
+	Domain  string
+}
+
+// PersonaFor derives a persona from a login such as jane.miller or
+// jane.miller@example.com
+func PersonaFor(username string, domain string) Persona {
+	local := username
+	if i := strings.LastIndexByte(username, '@'); i >= 0 {
+		local, domain = username[:i], username[i+1:]
+	}
+	var words []string
+	for _, w := range strings.FieldsFunc(local, func(r rune) bool { return r == '.' || r == '_' || r == '-' }) {
+		words = append(words, strings.ToUpper(w[:1])+strings.ToLower(w[1:]))
+	}
+	company := strings.Split(domain, ".")[0]
+	if company == "" {
+		company = "Company"
+	}
+	p := Persona{
+		Name:    strings.Join(words, " "),
+		Email:   strings.ToLower(local) + "@" + domain,
+		Title:   "Operations Manager",
+		Company: strings.ToUpper(company[:1]) + company[1:],
+		Domain:  domain,
+	}
+	if p.Name == "" {
+		p.Name = local
+	}
+	return p
+}
+
+var firstNames = []string{"Anna", "Brian", "Carla", "David", "Emma", "Frank", "Grace", "Hugo", "Irene", "Jonas", "Karen", "Liam", "Maria", "Nathan", "Olivia", "Paul", "Rachel", "Sam", "Tina", "Victor"}
+var lastNames = []string{"Adams", "Baker", "Clark", "Dubois", "Evans", "Fischer", "Garcia", "Hughes", "Jensen", "Keller", "Lopez", "Martin", "Novak", "Owens", "Petit", "Rossi", "Schmidt", "Turner", "Walsh", "Young"}
+var customers = []string{"Northwind", "Contoso", "Globex", "Initech", "Umbrella Health", "Stark Logistics", "Wayne Retail", "Acme Foods"}
+
+// thread templates, the first message comes from the peer, replies
+// alternate with the persona. [first], [peer], [peerfirst], [company],
+// [domain], [customer], [quarter] and [title] are filled in, {{...}}
+// placeholders are left for Personalize.
+var threads = []struct {
+	from       string // fixed sender, a peer if empty
+	subject    string
+	bodies     []string
+	attachment string // file name of an HTML document beacon
+}{
+	{"IT Service Desk <servicedesk@[domain]>", "Password reset requested for your [company] account", []string{
+		"Hello [first],\n\nA password reset was requested for your account. If this was you,\ncomplete it within 24 hours:\n\n{{canary_url}}\n\nIf you did not ask for it, contact the Service Desk.\n\nIT Service Desk",
+	}, ""},
+	{"", "AWS access for the reporting pipeline", []string{
+		"Hi [first],\n\nHere are the keys for the reporting bucket, please keep them out of\nthe wiki:\n\naws_access_key_id = {{aws_key_id}}\naws_secret_access_key = {{aws_secret}}\nregion = eu-west-1\n\nRunbook: {{canary_url}}\n\n[peerfirst]",
+		"Thanks [peerfirst], it works. I will move them to the vault next week.\n\n[first]",
+	}, ""},
+	{"", "[quarter] budget review", []string{
+		"Hi [first],\n\nAttached is the [quarter] budget with the headcount changes. The full\nworkbook is on the finance share: {{canary_url}}\n\nCan we go through it before Thursday?\n\n[peerfirst]",
+		"Looks fine except travel, I will send comments tomorrow.\n\n[first]",
+		"Great, I booked 30 minutes on Thursday.\n\n[peerfirst]",
+	}, "Budget_[quarter].htm"},
+	{"", "[customer] contract - draft v2", []string{
+		"[first],\n\nPlease find the second draft of the [customer] contract attached, legal\nchanged the liability clause. Signed copies will go to {{canary_url}}\n\nBest,\n[peer]",
+		"Thanks, I will review it with [customer] on Monday.\n\n[first]",
+	}, "[customer]_contract_v2.htm"},
+	{"Accounts Payable <ap@[domain]>", "Updated bank details for [customer]", []string{
+		"Hello [first],\n\n[customer] sent new bank details for their invoices. Please confirm the\nchange in the supplier portal before the next payment run:\n\n{{canary_url}}\n\nAccounts Payable",
+	}, ""},
+	{"", "Stripe key rotation", []string{
+		"Hi [first],\n\nWe rotated the Stripe keys after the audit. The new live key for the\nbilling service is:\n\n{{api_key}}\n\nDashboard: {{canary_url}}\n\n[peerfirst]",
+	}, ""},
+	{"", "Weekly sync notes", []string{
+		"Hi all,\n\nNotes from today are in the team folder: {{canary_url}}\n\nAction items:\n- [first]: follow up with [customer]\n- [peerfirst]: update the forecast\n\n[peerfirst]",
+		"Thanks [peerfirst], I will ping [customer] today.\n\n[first]",
+	}, ""},
+	{"IT Security <security@[domain]>", "VPN certificate renewal", []string{
+		"Dear [first],\n\nYour VPN certificate expires in 7 days. Download the new profile from\n{{canary_url}} and import it before it expires.\n\nIT Security",
+	}, ""},
+	{"", "Re: [customer] escalation", []string{
+		"[first],\n\n[customer] is unhappy with the delivery delays, their CFO wants a call.\nTimeline and tickets: {{canary_url}}\n\n[peer]",
+		"Let's do the call Wednesday, I will prepare the timeline.\n\n[first]",
+		"Confirmed for Wednesday 10:00.\n\n[peer]",
+	}, ""},
+	{"HR <hr@[domain]>", "Salary review [quarter]", []string{
+		"Dear [first],\n\nThe salary review for your team is open until the end of the month.\nThe proposal sheet is attached and on the HR portal: {{canary_url}}\n\nHR",
+	}, "Salary_review_[quarter].htm"},
+}
+
+const beacon = `<html><head><title>[name]</title></head>
+<body>
+<img src="{{beacon_url}}" width="1" height="1" alt="">
+<p>This document is protected. <a href="{{canary_url}}">Open it in the
+[company] document portal</a> to view its content.</p>
+</body></html>
+`
+
+type draft struct {
+	mailbox string
+	date    time.Time
+	data    string
+	flags   []string
+}
+
+// Generate builds a mailbox for p with about per threads per month spread
+// over months, seed makes it reproducible
+func Generate(p Persona, months int, seed int64) *mailbox.Store {
+	r := rand.New(rand.NewSource(seed))
+	now := time.Now().Truncate(time.Minute)
+	start := now.AddDate(0, -months, 0)
+
+	var drafts []draft
+	for i := 0; i < months*4; i++ {
+		t := threads[r.Intn(len(threads))]
+		peer := firstNames[r.Intn(len(firstNames))] + " " + lastNames[r.Intn(len(lastNames))]
+		peerAddr := strings.ToLower(strings.Replace(peer, " ", ".", 1)) + "@" + p.Domain
+		date := start.Add(time.Duration(r.Int63n(int64(now.Sub(start)))))
+		q := (int(date.Month())-1)/3 + 1
+		repl := strings.NewReplacer(
+			"[first]", strings.Split(p.Name, " ")[0], "[name]", p.Name, "[title]", p.Title,
+			"[company]", p.Company, "[domain]", p.Domain,
+			"[peer]", peer, "[peerfirst]", strings.Split(peer, " ")[0],
+			"[customer]", customers[r.Intn(len(customers))],
+			"[quarter]", fmt.Sprintf("Q%d", q))
+
+		from := fmt.Sprintf("%s <%s>", peer, peerAddr)
+		if t.from != "" {
+			from = repl.Replace(t.from)
+		}
+		subject := repl.Replace(t.subject)
+		var refs []string
+		var prevText, prevSender string
+		var prevDate time.Time
+		for n, body := range t.bodies {
+			if date.After(now) {
+				break
+			}
+			id := fmt.Sprintf("<%x.%d@%s>", r.Int63(), n, p.Domain)
+			sender, to, box := from, fmt.Sprintf("%s <%s>", p.Name, p.Email), "INBOX"
+			if n%2 == 1 {
+				sender, to, box = to, from, "Sent"
+			}
+			var h strings.Builder
+			fmt.Fprintf(&h, "From: %s\nTo: %s\n", sender, to)
+			if n > 0 {
+				fmt.Fprintf(&h, "Subject: Re: %s\nIn-Reply-To: %s\nReferences: %s\n", strings.TrimPrefix(subject, "Re: "), refs[len(refs)-1], strings.Join(refs, " "))
+			} else {
+				fmt.Fprintf(&h, "Subject: %s\n", subject)
+			}
+			fmt.Fprintf(&h, "Date: %s\nMessage-ID: %s\nMIME-Version: 1.0\n", date.Format(time.RFC1123Z), id)
+			refs = append(refs, id)
+
+			text := repl.Replace(body) + "\n"
+			if t.from == "" || n%2 == 1 { // services sign in the body
+				text += "\n--\n" + repl.Replace(signature(sender, p)) + "\n"
+			}
+			if n > 0 { // quote the previous message, its tokens included
+				text += fmt.Sprintf("\nOn %s, %s wrote:\n> %s", prevDate.Format("Mon, Jan 2, 2006 at 15:04"),
+					prevSender, strings.ReplaceAll(strings.TrimSuffix(prevText, "\n"), "\n", "\n> ")+"\n")
+			}
+			prevText, prevSender, prevDate = text, sender, date
+			if n == 0 && t.attachment != "" {
+				boundary := fmt.Sprintf("=_%x", r.Int63())
+				name := repl.Replace(t.attachment)
+				fmt.Fprintf(&h, "Content-Type: multipart/mixed; boundary=\"%s\"\n\n--%s\nContent-Type: text/plain; charset=utf-8\n\n%s--%s\n", boundary, boundary, text, boundary)
+				fmt.Fprintf(&h, "Content-Type: text/html; charset=utf-8; name=\"%s\"\nContent-Disposition: attachment; filename=\"%s\"\n\n%s--%s--\n", name, name, strings.NewReplacer("[name]", name, "[company]", p.Company).Replace(beacon), boundary)
+			} else {
+				fmt.Fprintf(&h, "Content-Type: text/plain; charset=utf-8\n\n%s", text)
+			}
+
+			var flags []string
+			if box == "Sent" || now.Sub(date) > 48*time.Hour {
+				flags = append(flags, mailbox.Seen)
+			}
+			if box == "INBOX" && n+1 < len(t.bodies) {
+				flags = append(flags, mailbox.Answered)
+			}
+			drafts = append(drafts, draft{box, date, h.String(), flags})
+			date = date.Add(time.Duration(30+r.Intn(24*60)) * time.Minute)
+		}
+	}
+	sort.SliceStable(drafts, func(i, j int) bool { return drafts[i].date.Before(drafts[j].date) })
+
+	s := mailbox.NewStore()
+	for _, n := range []string{"INBOX", "Archive", "Drafts", "Sent", "Junk", "Trash"} {
+		var attrs []string
+		if n != "INBOX" {
+			attrs = append(attrs, `\`+n)
+		}
+		mb := mailbox.New(n, attrs...)
+		mb.UIDValidity = (mb.UIDValidity ^ uint32(seed)) | 1
+		s.Add(mb)
+	}
+	for _, d := range drafts {
+		s.Get(d.mailbox).Append(mailbox.NewMessage([]byte(d.data), d.date, d.flags...))
+	}
+	return s
+}
+
+func signature(sender string, p Persona) string {
+	if strings.Contains(sender, p.Email) {
+		return fmt.Sprintf("%s\n%s, %s", p.Name, p.Title, p.Company)
+	}
+	return strings.TrimSpace(sender[:strings.IndexByte(sender, '<')]) + "\n[company]"
+}
+
+// Seed derives a stable generator seed from a login and hostname
+func Seed(username string, hostname string) int64 {
+	return int64(crc32.ChecksumIEEE([]byte(username + "@" + hostname)))
+}
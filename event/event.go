@@ -0,0 +1,161 @@
+package event
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	Open       = "session.open"
+	Close      = "session.close"
+	Command    = "command"
+	Login      = "login"
+	Fetch      = "fetch"
+	Canary     = "canary"
+	Message    = "message"
+	Stored     = "stored"
+	Attachment = "attachment"
+	Indicator  = "indicator"
+	Error      = "error"
+)
+
+// TLS parameters negotiated by the client
+type TLS struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ServerName  string `json:"server_name,omitempty"`
+}
+
+// tlsVersions names the protocol versions crypto/tls can negotiate, the
+// same way tls.VersionName does from go 1.21 on
+var tlsVersions = map[uint16]string{
+	0x0300:           "SSLv3",
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+func versionName(v uint16) string {
+	if name, ok := tlsVersions[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", v)
+}
+
+func NewTLS(cs tls.ConnectionState) *TLS {
+	return &TLS{
+		Version:     versionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ServerName:  cs.ServerName,
+	}
+}
+
+// Event is the stable schema shared by both honeypots. Type specific
+// values go in Data.
+type Event struct {
+	Type     string                 `json:"event"`
+	Time     time.Time              `json:"timestamp"`
+	Sensor   string                 `json:"sensor,omitempty"`
+	Session  string                 `json:"session_id,omitempty"`
+	Protocol string                 `json:"protocol"`
+	SrcIP    string                 `json:"src_ip,omitempty"`
+	SrcPort  int                    `json:"src_port,omitempty"`
+	DstIP    string                 `json:"dst_ip,omitempty"`
+	DstPort  int                    `json:"dst_port,omitempty"`
+	Username string                 `json:"username,omitempty"`
+	Password string                 `json:"password,omitempty"`
+	Command  string                 `json:"command,omitempty"`
+	TLS      *TLS                   `json:"tls,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Text     string                 `json:"message,omitempty"` // legacy text line
+}
+
+func New(typ string, protocol string, conn net.Conn) *Event {
+	ev := &Event{Type: typ, Time: time.Now().UTC(), Protocol: protocol}
+	if conn != nil {
+		ev.SrcIP, ev.SrcPort = splitAddr(conn.RemoteAddr())
+		ev.DstIP, ev.DstPort = splitAddr(conn.LocalAddr())
+		if c, ok := conn.(*tls.Conn); ok {
+			ev.TLS = NewTLS(c.ConnectionState())
+		}
+	}
+	return ev
+}
+
+func splitAddr(a net.Addr) (string, int) {
+	if a == nil {
+		return "", 0
+	}
+	host, port, err := net.SplitHostPort(a.String())
+	if err != nil {
+		return a.String(), 0
+	}
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+// Set adds a type specific value
+func (ev *Event) Set(key string, value interface{}) *Event {
+	if ev.Data == nil {
+		ev.Data = map[string]interface{}{}
+	}
+	ev.Data[key] = value
+	return ev
+}
+
+func (ev *Event) JSON() []byte {
+	b, _ := json.Marshal(ev)
+	return b
+}
+
+// Line returns ev as its legacy text line with the session, or as JSON
+func (ev *Event) Line(json bool) string {
+	if json {
+		return string(ev.JSON())
+	}
+	if ev.Session != "" {
+		return ev.Text + ", SESSION: " + ev.Session
+	}
+	return ev.Text
+}
+
+// Output receives every event logged
+type Output interface {
+	Log(ev *Event)
+}
+
+// Logger writes events to the console unless quiet, as text or one JSON
+// object per line, and passes them to Outputs.
+type Logger struct {
+	JSON    bool
+	Sensor  string
+	Quiet   bool
+	Outputs []Output
+
+	mu sync.Mutex
+}
+
+func (l *Logger) Log(ev *Event) {
+	if ev.Sensor == "" {
+		ev.Sensor = l.Sensor
+	}
+	if !l.Quiet {
+		line := ev.Line(l.JSON)
+		if !l.JSON {
+			line = fmt.Sprintf("%s - %s", ev.Time.Local().Format(time.RFC3339), line)
+		}
+		l.mu.Lock()
+		fmt.Println(line) // console
+		l.mu.Unlock()
+	}
+	for _, o := range l.Outputs {
+		o.Log(ev)
+	}
+}
@@ -0,0 +1,96 @@
+package event
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recorder []*Event
+
+func (r *recorder) Log(ev *Event) { *r = append(*r, ev) }
+
+func TestLoggerJSON(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	var r recorder
+	l := &Logger{JSON: true, Sensor: "sensor1", Quiet: true, Outputs: []Output{&r}}
+	ev := New(Login, "imap", c1)
+	ev.Username, ev.Password = "joe", "secret"
+	ev.Text = "IP: pipe, LOGIN: joe secret"
+	l.Log(ev.Set("mechanism", "LOGIN"))
+
+	if len(r) != 1 {
+		t.Fatalf("wait 1 event, receive %d", len(r))
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(r[0].Line(true)), &got); err != nil {
+		t.Fatalf("bad json %q: %v", r[0].Line(true), err)
+	}
+	for k, v := range map[string]string{"event": Login, "sensor": "sensor1", "protocol": "imap",
+		"username": "joe", "password": "secret"} {
+		if got[k] != v {
+			t.Errorf("%s: wait %q, receive %v", k, v, got[k])
+		}
+	}
+	if got["data"].(map[string]interface{})["mechanism"] != "LOGIN" {
+		t.Errorf("missing data field: %v", got)
+	}
+}
+
+func TestLineText(t *testing.T) {
+	ev := New(Login, "smtp", nil)
+	ev.Text = "IP: 192.0.2.1, LOGIN: joe"
+	ev.Session = "01G0EZ1XTM37C5X11SQTDNCTM1"
+	if l := ev.Line(false); l != "IP: 192.0.2.1, LOGIN: joe, SESSION: 01G0EZ1XTM37C5X11SQTDNCTM1" {
+		t.Errorf("bad text line %q", l)
+	}
+}
+
+func TestNewID(t *testing.T) {
+	a := NewID()
+	time.Sleep(2 * time.Millisecond)
+	b := NewID()
+	if len(a) != 26 || len(b) != 26 {
+		t.Fatalf("bad ULID length %q %q", a, b)
+	}
+	if a >= b {
+		t.Errorf("ULID not sortable: %q >= %q", a, b)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s := NewSummary()
+	io.WriteString(s.Writer(io.Discard), "* OK\r\n")
+	io.ReadAll(s.Reader(strings.NewReader("a LOGIN joe secret\r\n")))
+	s.AddCommand("a LOGIN joe secret")
+	ev := New(Login, "imap", nil)
+	ev.Username, ev.Password = "joe", "secret"
+	s.Record(ev)
+
+	ev = s.Close(New(Close, "imap", nil), ClientQuit)
+	if ev.Data["bytes_in"] != int64(20) || ev.Data["bytes_out"] != int64(6) {
+		t.Errorf("bad byte counts: %v", ev.Data)
+	}
+	if ev.Data["command_count"] != 1 || ev.Data["reason"] != ClientQuit {
+		t.Errorf("bad summary: %v", ev.Data)
+	}
+	if c := ev.Data["credentials"].([]Credential); len(c) != 1 || c[0].Password != "secret" {
+		t.Errorf("bad credentials: %v", c)
+	}
+}
+
+func TestNewTLS(t *testing.T) {
+	for v, want := range map[uint16]string{tls.VersionTLS12: "TLS 1.2", tls.VersionTLS13: "TLS 1.3", 0x0305: "0x0305"} {
+		got := NewTLS(tls.ConnectionState{Version: v, CipherSuite: tls.TLS_AES_128_GCM_SHA256})
+		if got.Version != want || got.CipherSuite != "TLS_AES_128_GCM_SHA256" {
+			t.Errorf("%#04x: wait %q, receive %+v", v, want, got)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package event
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Close reasons
+const (
+	ClientQuit    = "client quit"
+	ClientClose   = "client close"
+	Timeout       = "timeout"
+	ProtocolError = "protocol error"
+	ServerClose   = "server close"
+)
+
+// bound memory used by a chatty client
+const maxCommands = 1000
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewID returns a ULID: 48 bits of milliseconds then 80 random bits,
+// 26 characters that sort by creation time.
+func NewID() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint16(b[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:], uint32(ms))
+	rand.Read(b[6:])
+
+	var id [26]byte
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	for i := 25; i >= 0; i-- {
+		id[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(id[:])
+}
+
+// Reason maps a connection error to a close reason
+func Reason(err error) string {
+	var ne net.Error
+	switch {
+	case errors.As(err, &ne) && ne.Timeout():
+		return Timeout
+	case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed), errors.As(err, new(*net.OpError)):
+		return ClientClose
+	}
+	return ProtocolError
+}
+
+type Credential struct {
+	Mechanism string `json:"mechanism,omitempty"`
+	Username  string `json:"username"`
+	Password  string `json:"password,omitempty"`
+}
+
+// Summary records a session for the close event
+type Summary struct {
+	In          int64 // bytes, first for 64-bit atomic alignment on 386
+	Out         int64
+	ID          string
+	Start       time.Time
+	Commands    []string
+	Count       int
+	Credentials []Credential
+
+	mu sync.Mutex
+}
+
+func NewSummary() *Summary {
+	return &Summary{ID: NewID(), Start: time.Now()}
+}
+
+func (s *Summary) AddCommand(c string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Count++
+	if len(s.Commands) < maxCommands {
+		s.Commands = append(s.Commands, c)
+	}
+}
+
+// Record keeps credentials of login events
+func (s *Summary) Record(ev *Event) {
+	if ev.Type != Login {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mech, _ := ev.Data["mechanism"].(string)
+	s.Credentials = append(s.Credentials, Credential{mech, ev.Username, ev.Password})
+}
+
+// Close fills ev as the session summary
+func (s *Summary) Close(ev *Event, reason string) *Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := time.Since(s.Start)
+	ev.Text = fmt.Sprintf("IP: %s, CLOSED: %s, DURATION: %s, IN: %d, OUT: %d, COMMANDS: %d, LOGINS: %d",
+		ev.SrcIP, reason, d.Round(time.Millisecond), atomic.LoadInt64(&s.In), atomic.LoadInt64(&s.Out), s.Count, len(s.Credentials))
+	return ev.Set("reason", reason).
+		Set("duration", d.Seconds()).
+		Set("bytes_in", atomic.LoadInt64(&s.In)).
+		Set("bytes_out", atomic.LoadInt64(&s.Out)).
+		Set("command_count", s.Count).
+		Set("commands", s.Commands).
+		Set("credentials", s.Credentials)
+}
+
+// Reader counts bytes read from r in s.In
+func (s *Summary) Reader(r io.Reader) io.Reader {
+	return &counter{r, nil, &s.In}
+}
+
+// Writer counts bytes written to w in s.Out
+func (s *Summary) Writer(w io.Writer) io.Writer {
+	return &counter{nil, w, &s.Out}
+}
+
+type counter struct {
+	r io.Reader
+	w io.Writer
+	n *int64
+}
+
+func (c *counter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
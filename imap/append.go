@@ -0,0 +1,86 @@
+package imap
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"imap-honey/core"
+	"imap-honey/event"
+	"imap-honey/mailbox"
+	"imap-honey/spool"
+)
+
+// appendMessage answers APPEND: the message is logged, spooled and added
+// to the session copy of the mailbox
+func (sess *Session) appendMessage(c *Command) {
+	args := c.Args
+	if len(args) < 2 || !args[0].IsString() {
+		sess.Sendf("%s BAD invalid arguments\r\n", c.Tag)
+		return
+	}
+	name, rest := args[0].Value, args[1:]
+	var flags []string
+	if rest[0].Kind == List {
+		for _, f := range rest[0].List {
+			if !strings.EqualFold(f.Value, `\Recent`) {
+				flags = append(flags, f.Value)
+			}
+		}
+		rest = rest[1:]
+	}
+	date, dated := time.Now(), false
+	if len(rest) == 2 && rest[0].Kind == Quoted {
+		d, err := time.Parse("_2-Jan-2006 15:04:05 -0700", rest[0].Value)
+		if err != nil {
+			sess.Sendf("%s BAD invalid date-time\r\n", c.Tag)
+			return
+		}
+		date, dated, rest = d, true, rest[1:]
+	}
+	if len(rest) != 1 || !rest[0].IsString() {
+		sess.Sendf("%s BAD invalid arguments\r\n", c.Tag)
+		return
+	}
+	data := []byte(rest[0].Value)
+
+	ev := sess.Event(event.Message, fmt.Sprintf("IP: %s, APPEND: %s, FLAGS: %s, SIZE: %d", sess.RemoteIP(), name, strings.Join(flags, " "), len(data)))
+	ev.Username = sess.username
+	ev.Set("mailbox", name).Set("flags", flags).Set("size", len(data))
+	if dated {
+		ev.Set("date", date)
+	}
+	sess.Emit(ev)
+	sess.Store(name, flags, data)
+	sess.Extract(data)
+
+	mb := sess.store.Get(name)
+	if mb == nil {
+		sess.Sendf("%s NO [TRYCREATE] Mailbox doesn't exist: %s\r\n", c.Tag, name)
+		return
+	}
+	mb.Append(mailbox.NewMessage(data, date, flags...))
+	if sess.selected == mb {
+		sess.Sendf("* %d EXISTS\r\n", len(mb.Messages))
+	}
+	sess.Sendf("%s OK APPEND completed\r\n", c.Tag)
+}
+
+// Store writes an appended message to the server spool, if any
+func (sess *Session) Store(mbox string, flags []string, data []byte) {
+	core.Store(sess, sess.server.spool, &spool.Meta{
+		SessionID:    sess.summary.ID,
+		Protocol:     "imap",
+		RemoteIP:     sess.RemoteIP(),
+		Username:     sess.username,
+		Mailbox:      mbox,
+		Flags:        flags,
+		SessionStart: sess.summary.Start,
+		Received:     time.Now(),
+	}, data)
+}
+
+// Extract logs the MIME parts and indicators of an appended message
+func (sess *Session) Extract(data []byte) {
+	core.Extract(sess, sess.server.spool, data)
+}
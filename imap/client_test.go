@@ -0,0 +1,548 @@
+package imap
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"imap-honey/core"
+	"imap-honey/decoy"
+	"imap-honey/event"
+	"imap-honey/indicator"
+	"imap-honey/mailbox"
+	"imap-honey/persona"
+	"imap-honey/sasl"
+	"imap-honey/spool"
+)
+
+type Client struct {
+	socket net.Conn
+	reader *bufio.Reader
+}
+
+func (client *Client) Send(msg string) {
+	client.socket.Write([]byte(msg + "\r\n"))
+}
+
+func (client *Client) Read() string {
+	for {
+		message, err := client.reader.ReadString('\n')
+		if err != nil {
+			client.socket.Close()
+			return ""
+		}
+		return string(message)
+	}
+}
+
+func NewClient(addr string) (*Client, string) {
+	connection, err := net.Dial("tcp", addr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	client := &Client{socket: connection, reader: bufio.NewReader(connection)}
+	hello := client.Read()
+	return client, hello
+}
+
+func TestMail(t *testing.T) {
+	var listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		//{"A01 CAPABILITY", "* CAPABILITY IMAP4rev1 AUTH=PLAIN"},
+		{"A02 NOOP", "A02 OK"},
+		{"A03 LOGIN joe password", "A03 NO LOGIN failed"},
+		{"A04 CLOSE", ""},
+	}
+
+	s := NewServer("localhost", ":1992",
+		"", "", false)
+	//s.SetDebug(true)
+	//s.SetQuiet(false)
+
+	//u := strings.ReplaceAll(capFlag, ";", "\r\n")
+	//s.SetCapability(u)
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+
+	go Serve(s)
+
+	client, hello := NewClient("localhost:1992")
+	println("hello from server : ", hello)
+	client.Send("A01 CAPABILITY")
+	r1 := client.Read()
+	if strings.TrimSuffix(r1,"\r\n") != "* CAPABILITY IMAP4rev1 AUTH=PLAIN" {
+			t.Errorf("send: \"A01 CAPABILITY\"\n wait: \"* CAPABILITY IMAP4rev1 AUTH=PLAIN\"\n receive: \"%s\"\n", r1)
+	}
+	r1 = client.Read()
+	if strings.TrimSuffix(r1,"\r\n") != "A01 OK CAPABILITY" {
+		t.Errorf(" wait: \"A01 OK CAPABILITY\"\n receive: \"%s\"\n", r1)
+	}
+
+	for _, tt := range listTests {
+		println("write to server: ", tt.message)
+
+		client.Send(tt.message)
+		reply := client.Read()
+
+		println(" wait from server: ", tt.response)
+		println("reply from server: ", reply)
+
+		if strings.TrimSuffix(reply,"\r\n") != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+	}
+
+}
+
+
+func TestLiteralLogin(t *testing.T) {
+	var listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		{"A01 LOGIN {4}", "+ Ready for literal data"},
+		{"john {8}", "+ Ready for literal data"},
+		{"p@ss wrd", "A01 NO LOGIN failed"},
+	}
+
+	s := NewServer("localhost", "127.0.0.1:0",
+		"", "", false)
+	s.SetQuiet(true)
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+
+	go Serve(s)
+
+	client, _ := NewClient(s.Addr().String())
+
+	for _, tt := range listTests {
+		client.Send(tt.message)
+		reply := client.Read()
+
+		if strings.TrimSuffix(reply, "\r\n") != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+	}
+}
+
+// ReadTagged reads lines up to the tagged response
+func TestAuthenticate(t *testing.T) {
+	rec := &recorder{}
+	s := NewServer("mail.example.com", "127.0.0.1:0",
+		"", "", false)
+	s.SetLogger(&event.Logger{Quiet: true, Outputs: []event.Output{rec}})
+	if e := Listen(s); e != nil {
+		t.Fatalf("Listen() ERROR: %v", e)
+	}
+	go Serve(s)
+	defer s.Close()
+
+	cram := func(challenge string) string {
+		c, _ := base64.StdEncoding.DecodeString(challenge)
+		h := hmac.New(md5.New, []byte("tanstaaftanstaaf"))
+		h.Write(c)
+		return base64.StdEncoding.EncodeToString([]byte("tim " + hex.EncodeToString(h.Sum(nil))))
+	}
+	var listTests = []struct {
+		message  string                  // input
+		response string                  // expected result, prefix when reply is set
+		reply    func(prev string) string // next message computed from the response
+	}{
+		{"A01 AUTHENTICATE GSSAPI", "A01 NO unsupported authentication mechanism", nil},
+		{"A02 AUTHENTICATE LOGIN", "+ VXNlcm5hbWU6", nil},
+		{"am9l", "+ UGFzc3dvcmQ6", nil},
+		{"*", "A02 BAD AUTHENTICATE cancelled", nil},
+		{"A03 AUTHENTICATE PLAIN !!", "A03 BAD invalid SASL response", nil},
+		{"A04 AUTHENTICATE CRAM-MD5", "+ ", cram},
+		{"", "A04 NO LOGIN failed", nil},
+	}
+
+	client, _ := NewClient(s.Addr().String())
+	next := ""
+	for _, tt := range listTests {
+		if tt.message != "" {
+			next = tt.message
+		}
+		client.Send(next)
+		reply := strings.TrimSuffix(client.Read(), "\r\n")
+		if tt.reply != nil && strings.HasPrefix(reply, tt.response) {
+			next = tt.reply(reply[len(tt.response):])
+			continue
+		}
+		if reply != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", next, tt.response, reply)
+		}
+	}
+
+	var logins []*event.Event
+	for _, ev := range rec.Events() {
+		if ev.Type == event.Login {
+			logins = append(logins, ev)
+		}
+	}
+	if len(logins) != 2 || logins[0].Data["malformed"] != true {
+		t.Fatalf("login events: %+v", logins)
+	}
+	ev := logins[1]
+	challenge, _ := ev.Data["challenge"].(string)
+	if ev.Username != "tim" || ev.Data["mechanism"] != "CRAM-MD5" || !strings.HasSuffix(challenge, "@mail.example.com>") || ev.Data["response"] == nil {
+		t.Errorf("CRAM-MD5 login event: %+v", ev)
+	}
+}
+
+func (client *Client) ReadTagged(tag string) []string {
+	var lines []string
+	for {
+		l := client.Read()
+		if l == "" {
+			return lines
+		}
+		lines = append(lines, strings.TrimSuffix(l, "\r\n"))
+		if strings.HasPrefix(l, tag+" ") {
+			return lines
+		}
+	}
+}
+
+func TestMailbox(t *testing.T) {
+	var listTests = []struct {
+		message  string // input
+		response string // expected line
+	}{
+		{"A01 LOGIN joe wrong", "A01 NO LOGIN failed"},
+	}
+
+	rec := &recorder{}
+	s := NewServer("mail.example.com", "127.0.0.1:0",
+		"", "", false)
+	s.SetLogger(&event.Logger{Outputs: []event.Output{rec}})
+	s.SetQuiet(true)
+	s.SetAuthOK(true)
+	s.SetUsers("joe:secret,eve")
+	s.SetStore("joe", mailbox.Default("mail.example.com", "joe"))
+	s.SetDecoy(2)
+	s.SetCanary(decoy.NewCanary("key", "https://mail.example.com/s/"))
+	dir := t.TempDir()
+	sp, _ := spool.New(dir)
+	s.SetSpool(sp)
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+
+	go Serve(s)
+
+	client, _ := NewClient(s.Addr().String())
+	for _, tt := range listTests {
+		client.Send(tt.message)
+		reply := client.ReadTagged("A01")
+		if len(reply) == 0 || reply[len(reply)-1] != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: %q\n", tt.message, tt.response, reply)
+		}
+	}
+
+	listTests = []struct {
+		message  string // input
+		response string // expected line
+	}{
+		{"A00 FETCH 1 FLAGS", "A00 BAD invalid command"},
+		{"A01 LOGIN joe secret", "A01 OK [CAPABILITY IMAP4rev1 AUTH=PLAIN] Logged in"},
+		{`A02 LIST "" *`, `* LIST (\HasNoChildren \Sent) "/" "Sent"`},
+		{`A03 LIST "" %`, `* LIST (\HasNoChildren) "/" "INBOX"`},
+		{"A04 FETCH 1 FLAGS", "A04 BAD No mailbox selected"},
+		{"A05 SELECT inbox", "* 4 EXISTS"},
+		{"A06 UID SEARCH UNSEEN", "* SEARCH 4"},
+		{"A07 SEARCH OR FROM jane SUBJECT vpn", "* SEARCH 1 3"},
+		{"A08 SEARCH NOT SEEN", "* SEARCH 4"},
+		{"A09 FETCH 4 (FLAGS BODY.PEEK[HEADER.FIELDS (SUBJECT)])", "* 4 FETCH (FLAGS () BODY[HEADER.FIELDS (SUBJECT)] {35}"},
+		{"A10 UID FETCH 4 BODY[TEXT]<0.9>", "* 4 FETCH (UID 4 BODY[TEXT]<0> {9}"},
+		{"A11 FETCH 4 FLAGS", `* 4 FETCH (FLAGS (\Seen))`},
+		{"A12 FETCH 2 BODY", `* 2 FETCH (BODY (("text" "plain" ("charset" "utf-8") NIL NIL "7bit" 156 7)("application" "pdf" ("name" "INV-20417.pdf") NIL NIL "base64" 196) "mixed"))`},
+		{"A13 FETCH 2 BODY.PEEK[2.MIME]", "* 2 FETCH (BODY[2.MIME] {149}"},
+		{`A13 FETCH 1 "BODY["`, "A13 BAD invalid fetch item"},
+		{"A14 STORE 1 +FLAGS (\\Deleted)", `* 1 FETCH (FLAGS (\Seen \Deleted))`},
+		{"A15 EXPUNGE", "* 1 EXPUNGE"},
+		{"A16 STATUS INBOX (MESSAGES UNSEEN UIDNEXT)", `* STATUS "INBOX" (MESSAGES 3 UNSEEN 0 UIDNEXT 5)`},
+		{"A17 EXAMINE Sent", "A17 OK [READ-ONLY] EXAMINE completed"},
+		{"A18 STORE 1 +FLAGS (\\Deleted)", "A18 NO [READ-ONLY] Mailbox is read-only"},
+		{"A19 SELECT Archive", "A19 NO [NONEXISTENT] Mailbox doesn't exist: Archive"},
+		{"A20 LOGOUT", "* BYE mail.example.com"},
+	}
+
+	client, _ = NewClient(s.Addr().String())
+	for _, tt := range listTests {
+		client.Send(tt.message)
+		reply := client.ReadTagged(strings.Fields(tt.message)[0])
+		found := false
+		for _, l := range reply {
+			found = found || l == tt.response
+		}
+		if !found {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: %q\n", tt.message, tt.response, reply)
+		}
+	}
+
+	// eve gets a generated mailbox, fetching a message logs its tokens
+	client, _ = NewClient(s.Addr().String())
+	client.Send("B01 LOGIN eve anything")
+	client.ReadTagged("B01")
+	client.Send("B02 SELECT INBOX")
+	client.ReadTagged("B02")
+	client.Send("B03 FETCH 1 BODY[]")
+	body := strings.Join(client.ReadTagged("B03"), "\n")
+	msg := "Subject: invoice\r\n\r\nPay at https://evil.example.net/pay"
+	client.Send(fmt.Sprintf("B04 APPEND Drafts (\\Draft) \"22-Apr-2022 10:00:00 +0000\" {%d}", len(msg)))
+	if r := client.Read(); r != "+ Ready for literal data\r\n" {
+		t.Errorf("APPEND continuation %q", r)
+	}
+	client.Send(msg)
+	if r := client.ReadTagged("B04"); r[len(r)-1] != "B04 OK APPEND completed" {
+		t.Errorf("APPEND %q", r)
+	}
+	client.Send("B05 STATUS Drafts (MESSAGES)")
+	if r := client.ReadTagged("B05"); r[0] != `* STATUS "Drafts" (MESSAGES 1)` {
+		t.Errorf("STATUS after APPEND %q", r)
+	}
+	client.Send("B06 APPEND Nowhere {3+}\r\nabc")
+	if r := client.ReadTagged("B06"); r[len(r)-1] != "B06 NO [TRYCREATE] Mailbox doesn't exist: Nowhere" {
+		t.Errorf("APPEND to missing mailbox %q", r)
+	}
+	client.Send("B07 LOGOUT")
+	client.ReadTagged("B07")
+
+	var canary *event.Event
+	for _, ev := range rec.Events() {
+		if ev.Type == event.Canary {
+			canary = ev
+		}
+	}
+	if canary == nil || canary.Username != "eve" {
+		t.Fatalf("no canary event for eve")
+	}
+	tokens := canary.Data["tokens"].([]mailbox.Token)
+	if len(tokens) == 0 || !strings.Contains(body, tokens[0].Value) || !strings.HasPrefix(tokens[0].Value, "https://mail.example.com/s/") {
+		t.Errorf("canary tokens %v not in fetched message", tokens)
+	}
+	var appended, stored, indicators int
+	for _, ev := range rec.Events() {
+		switch {
+		case ev.Type == event.Message && ev.Data["mailbox"] == "Drafts" && ev.Data["size"] == len(msg):
+			appended++
+		case ev.Type == event.Stored:
+			stored++
+		case ev.Type == event.Indicator && ev.Data["type"] == indicator.URL:
+			indicators++
+		}
+	}
+	if appended != 1 || stored != 2 || indicators != 1 {
+		t.Errorf("APPEND events: %d message, %d stored, %d indicator", appended, stored, indicators)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*", "*", "*", "*.eml")); len(files) != 2 {
+		t.Errorf("%d messages spooled", len(files))
+	}
+	s.Close()
+}
+
+type recorder struct {
+	sync.Mutex
+	events []*event.Event
+}
+
+func (r *recorder) Log(ev *event.Event) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func (r *recorder) Events() []*event.Event {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*event.Event(nil), r.events...)
+}
+
+// writeCert writes a self-signed certificate and its key to a temp dir
+func writeCert(t *testing.T) (string, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour),
+		DNSNames: []string{"localhost"}}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	k, _ := x509.MarshalECPrivateKey(key)
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: k}), 0o600)
+	return certPath, keyPath
+}
+
+func TestStartTLS(t *testing.T) {
+	certPath, keyPath := writeCert(t)
+	s := NewServer("localhost", "127.0.0.1:0",
+		certPath, keyPath, false)
+	s.SetQuiet(true)
+	s.SetStartTLS(true)
+	s.SetLoginDisabled(true)
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+	go Serve(s)
+	defer s.Close()
+
+	var listTests = []struct {
+		message  string // input
+		response string // expected line
+	}{
+		{"A01 CAPABILITY", "* CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED"},
+		{"A02 LOGIN joe secret", "A02 NO [PRIVACYREQUIRED] Plaintext authentication disallowed on non-secure (SSL/TLS) connections."},
+		{"A03 STARTTLS\r\nA04 NOOP", "A03 OK Begin TLS negotiation now"},
+	}
+	client, _ := NewClient(s.Addr().String())
+	for _, tt := range listTests {
+		client.Send(tt.message)
+		reply := client.ReadTagged(strings.Fields(tt.message)[0])
+		if len(reply) == 0 || reply[0] != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: %q\n", tt.message, tt.response, reply)
+		}
+	}
+
+	conn := tls.Client(client.socket, &tls.Config{InsecureSkipVerify: true})
+	if err := conn.Handshake(); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	client = &Client{socket: conn, reader: bufio.NewReader(conn)}
+	listTests = []struct {
+		message  string // input
+		response string // expected line
+	}{
+		{"A05 CAPABILITY", "* CAPABILITY IMAP4rev1 AUTH=PLAIN"},
+		{"A06 STARTTLS", "A06 BAD STARTTLS not available"},
+	}
+	for _, tt := range listTests {
+		client.Send(tt.message)
+		reply := client.ReadTagged(strings.Fields(tt.message)[0])
+		if len(reply) == 0 || reply[0] != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: %q\n", tt.message, tt.response, reply)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	logins := make(chan *sasl.Credentials, 4)
+	closed := make(chan *event.Event, 1)
+	pers := *persona.Default("imap")
+	pers.LoginDelay, pers.MaxErrors = 0, 0
+	s, err := New("127.0.0.1:0",
+		WithHostname("mail.example.com"),
+		WithPersona(&pers),
+		WithCapability("IMAP4rev1 AUTH=PLAIN ID"),
+		WithAccept("joe:secret"),
+		WithSubscriber(core.Credentials(func(ev *event.Event, c *sasl.Credentials) { logins <- c })),
+		WithSubscriber(core.Sessions(func(ev *event.Event) { closed <- ev })))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go s.Serve()
+	defer s.Close()
+
+	var listTests = []struct {
+		message  string // input
+		response string // expected line
+		username string // login seen by the subscriber
+		password string
+	}{
+		{"A01 CAPABILITY", "* CAPABILITY IMAP4rev1 AUTH=PLAIN ID", "", ""},
+		{"A02 LOGIN joe wrong", "A02 NO LOGIN failed", "joe", "wrong"},
+		{"A03 LOGIN joe secret", "A03 OK [CAPABILITY IMAP4rev1 AUTH=PLAIN ID] Logged in", "joe", "secret"},
+		{"A04 LOGOUT", "* BYE mail.example.com", "", ""},
+	}
+	client, _ := NewClient(s.Addr().String())
+	for _, tt := range listTests {
+		client.Send(tt.message)
+		reply := client.ReadTagged(strings.Fields(tt.message)[0])
+		if len(reply) == 0 || reply[0] != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: %q\n", tt.message, tt.response, reply)
+		}
+		if tt.username == "" {
+			continue
+		}
+		select {
+		case c := <-logins:
+			if c.Mechanism != "LOGIN" || c.Authcid != tt.username || c.Secret != tt.password {
+				t.Errorf("%s: credentials %+v", tt.message, c)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: no credentials", tt.message)
+		}
+	}
+	select {
+	case ev := <-closed:
+		if ev.Data["reason"] != event.ClientQuit {
+			t.Errorf("close reason %v", ev.Data["reason"])
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no session close")
+	}
+
+	if _, err := New("127.0.0.1:0", WithStartTLS(nil)); err == nil {
+		t.Errorf("New: no error without a TLS configuration")
+	}
+	if _, err := New("127.0.0.1:0", WithPersona(persona.Default("smtp"))); err == nil {
+		t.Errorf("New: no error with an smtp persona")
+	}
+}
+
+func TestReadline(t *testing.T) {
+	var listTests = []struct {
+		data string // client input
+		line string // expected line
+		err  error
+	}{
+		{"DONE\r\nNEXT\r\n", "DONE\r\n", nil},
+		{strings.Repeat("A", maxLine-2) + "\r\nNEXT\r\n", strings.Repeat("A", maxLine-2) + "\r\n", nil},
+		{strings.Repeat("A", maxLine) + "\r\n", "", ErrLineTooLong},
+		{"DONE", "DONE", io.EOF},
+	}
+	for _, tt := range listTests {
+		sess := &Session{reader: bufio.NewReaderSize(strings.NewReader(tt.data), 16)}
+		line, err := sess.Readline()
+		if line != tt.line || err != tt.err {
+			t.Errorf("read %.20q\n wait: %.20q, %v\n receive: %.20q, %v\n", tt.data, tt.line, tt.err, line, err)
+		}
+	}
+}
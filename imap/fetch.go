@@ -0,0 +1,434 @@
+package imap
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"imap-honey/event"
+	"imap-honey/mailbox"
+)
+
+const dateTime = "02-Jan-2006 15:04:05 -0700"
+
+// nstring renders s as NIL, a quoted string or a literal
+func nstring(s string) string {
+	if s == "" {
+		return "NIL"
+	}
+	return str(s)
+}
+
+func str(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return literal([]byte(s))
+		}
+	}
+	return quote(s)
+}
+
+func literal(b []byte) string {
+	return fmt.Sprintf("{%d}\r\n%s", len(b), b)
+}
+
+func flags(m *mailbox.Message) string {
+	return "(" + strings.Join(m.Flags, " ") + ")"
+}
+
+// fetchItem is a FETCH data item such as BODY.PEEK[HEADER]<0.512>
+type fetchItem struct {
+	name    string
+	section string
+	body    bool // has a [section]
+	partial bool
+	offset  int
+	length  int
+}
+
+var fetchMacros = map[string][]string{
+	"ALL":  {"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE"},
+	"FAST": {"FLAGS", "INTERNALDATE", "RFC822.SIZE"},
+	"FULL": {"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE", "BODY"},
+}
+
+func parseFetchItems(a Arg) ([]fetchItem, error) {
+	var names []string
+	switch {
+	case a.Kind == List:
+		for _, i := range a.List {
+			if i.Kind != Atom {
+				return nil, fmt.Errorf("invalid fetch item")
+			}
+			names = append(names, i.Value)
+		}
+	case a.Kind == Atom && fetchMacros[strings.ToUpper(a.Value)] != nil:
+		names = fetchMacros[strings.ToUpper(a.Value)]
+	case a.Kind == Atom:
+		names = []string{a.Value}
+	default:
+		return nil, fmt.Errorf("invalid fetch item")
+	}
+
+	var items []fetchItem
+	for _, n := range names {
+		n = strings.ToUpper(n)
+		i := strings.IndexByte(n, '[')
+		if i < 0 {
+			switch n {
+			case "UID", "FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE", "BODYSTRUCTURE", "BODY",
+				"RFC822", "RFC822.HEADER", "RFC822.TEXT":
+				items = append(items, fetchItem{name: n})
+				continue
+			}
+			return nil, fmt.Errorf("invalid fetch item %s", n)
+		}
+		it := fetchItem{name: n[:i], body: true}
+		if it.name != "BODY" && it.name != "BODY.PEEK" {
+			return nil, fmt.Errorf("invalid fetch item %s", n)
+		}
+		j := strings.LastIndexByte(n, ']')
+		if j < i {
+			return nil, fmt.Errorf("invalid fetch item %s", n)
+		}
+		it.section = n[i+1 : j]
+		if rest := n[j+1:]; rest != "" {
+			p := strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(rest, "<"), ">"), ".", 2)
+			var err1, err2 error
+			it.offset, err1 = strconv.Atoi(p[0])
+			if len(p) == 2 {
+				it.length, err2 = strconv.Atoi(p[1])
+			}
+			if len(p) != 2 || err1 != nil || err2 != nil || it.offset < 0 || it.length <= 0 || !strings.HasPrefix(rest, "<") || !strings.HasSuffix(rest, ">") {
+				return nil, fmt.Errorf("invalid partial %s", rest)
+			}
+			it.partial = true
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// fetch answers FETCH and UID FETCH, messages whose content is read are
+// marked \Seen and logged
+func (sess *Session) fetch(c *Command, args []Arg, uid bool) {
+	if len(args) != 2 || args[0].Kind != Atom {
+		sess.Sendf("%s BAD invalid arguments\r\n", c.Tag)
+		return
+	}
+	seqs, err := sess.messages(args[0].Value, uid)
+	if err != nil {
+		sess.Sendf("%s BAD %v\r\n", c.Tag, err)
+		return
+	}
+	items, err := parseFetchItems(args[1])
+	if err != nil {
+		sess.Sendf("%s BAD %v\r\n", c.Tag, err)
+		return
+	}
+	if uid {
+		items = append([]fetchItem{{name: "UID"}}, items...)
+	}
+
+	for _, seq := range seqs {
+		m := sess.selected.Messages[seq-1]
+		var out, sections []string
+		var fetched []byte
+		seen := false
+		done := map[string]bool{}
+		for _, it := range items {
+			var s string
+			switch it.name {
+			case "UID":
+				s = fmt.Sprintf("UID %d", m.UID)
+			case "FLAGS":
+				s = "FLAGS " + flags(m)
+			case "INTERNALDATE":
+				s = fmt.Sprintf("INTERNALDATE %q", m.InternalDate.Format(dateTime))
+			case "RFC822.SIZE":
+				s = fmt.Sprintf("RFC822.SIZE %d", m.Size())
+			case "ENVELOPE":
+				s = "ENVELOPE " + envelope(m.Structure().Fields)
+			case "BODYSTRUCTURE":
+				s = "BODYSTRUCTURE " + bodyStructure(m.Structure(), true)
+			case "BODY":
+				if !it.body {
+					s = "BODY " + bodyStructure(m.Structure(), false)
+					break
+				}
+				fallthrough
+			case "BODY.PEEK":
+				data, ok := section(m, it.section)
+				if !ok {
+					data = nil
+				}
+				label := "BODY[" + it.section + "]"
+				if it.partial {
+					label += fmt.Sprintf("<%d>", it.offset)
+					data = partial(data, it.offset, it.length)
+				}
+				s = label + " " + literal(data)
+				sections, fetched = append(sections, it.section), append(fetched, data...)
+				seen = seen || it.name == "BODY"
+			case "RFC822":
+				s = "RFC822 " + literal(m.Data)
+				sections, fetched = append(sections, ""), append(fetched, m.Data...)
+				seen = true
+			case "RFC822.HEADER":
+				data, _ := section(m, "HEADER")
+				s = "RFC822.HEADER " + literal(data)
+				sections, fetched = append(sections, "HEADER"), append(fetched, data...)
+			case "RFC822.TEXT":
+				data, _ := section(m, "TEXT")
+				s = "RFC822.TEXT " + literal(data)
+				sections, fetched = append(sections, "TEXT"), append(fetched, data...)
+				seen = true
+			}
+			if !done[s] {
+				out = append(out, s)
+				done[s] = true
+			}
+		}
+		if seen && !sess.readOnly && !m.HasFlag(mailbox.Seen) {
+			m.AddFlags(mailbox.Seen)
+			out = append(out, "FLAGS "+flags(m))
+		}
+		sess.Sendf("* %d FETCH (%s)\r\n", seq, strings.Join(out, " "))
+		if len(sections) > 0 {
+			sess.logFetch(m, sections, fetched)
+		}
+	}
+	sess.Sendf("%s OK %sFETCH completed\r\n", c.Tag, uidPrefix(uid))
+}
+
+func uidPrefix(uid bool) string {
+	if uid {
+		return "UID "
+	}
+	return ""
+}
+
+// logFetch records a downloaded message and the canary tokens it held
+func (sess *Session) logFetch(m *mailbox.Message, sections []string, data []byte) {
+	h := m.Structure().Fields
+	ev := sess.Event(event.Fetch, fmt.Sprintf("IP: %s, FETCH: %s UID %d %q, SUBJECT: %q",
+		sess.RemoteIP(), sess.selected.Name, m.UID, sections, h.Get("Subject")))
+	ev.Username = sess.username
+	ev.Set("mailbox", sess.selected.Name).Set("uid", m.UID).Set("sections", sections)
+	ev.Set("subject", h.Get("Subject")).Set("message_id", h.Get("Message-Id"))
+	sess.Emit(ev)
+
+	var tokens []mailbox.Token
+	var l []string
+	for _, t := range m.Tokens {
+		if bytes.Contains(data, []byte(t.Value)) {
+			tokens = append(tokens, t)
+			l = append(l, t.Kind+"="+t.Value)
+		}
+	}
+	if len(tokens) == 0 {
+		return
+	}
+	ev = sess.Event(event.Canary, fmt.Sprintf("IP: %s, CANARY: %s UID %d, TOKENS: %s",
+		sess.RemoteIP(), sess.selected.Name, m.UID, strings.Join(l, " ")))
+	ev.Username = sess.username
+	sess.Emit(ev.Set("mailbox", sess.selected.Name).Set("uid", m.UID).Set("tokens", tokens))
+}
+
+func partial(data []byte, offset int, length int) []byte {
+	if offset >= len(data) {
+		return nil
+	}
+	data = data[offset:]
+	if length < len(data) {
+		data = data[:length]
+	}
+	return data
+}
+
+// section returns a BODY[section] of m
+func section(m *mailbox.Message, spec string) ([]byte, bool) {
+	if spec == "" {
+		return m.Data, true
+	}
+	root := m.Structure()
+	cur := root
+	var nums []int
+	for spec != "" && spec[0] >= '0' && spec[0] <= '9' {
+		i := strings.IndexByte(spec, '.')
+		if i < 0 {
+			i = len(spec)
+		}
+		n, err := strconv.Atoi(spec[:i])
+		if err != nil || n < 1 {
+			return nil, false
+		}
+		nums = append(nums, n)
+		spec = strings.TrimPrefix(spec[i:], ".")
+	}
+	for k, n := range nums {
+		if k > 0 && cur.Message != nil {
+			cur = cur.Message
+		}
+		if cur.Type == "multipart" && len(cur.Parts) > 0 {
+			if n > len(cur.Parts) {
+				return nil, false
+			}
+			cur = cur.Parts[n-1]
+		} else if n != 1 {
+			return nil, false
+		}
+	}
+
+	msg := root
+	if len(nums) > 0 {
+		msg = cur.Message
+	}
+	switch {
+	case spec == "":
+		return cur.Body, true
+	case spec == "MIME" && len(nums) > 0:
+		return cur.Header, true
+	case msg == nil:
+		return nil, false
+	case spec == "HEADER":
+		return msg.Header, true
+	case spec == "TEXT":
+		return msg.Body, true
+	case strings.HasPrefix(spec, "HEADER.FIELDS"):
+		i, j := strings.IndexByte(spec, '('), strings.LastIndexByte(spec, ')')
+		if i < 0 || j < i {
+			return nil, false
+		}
+		not := strings.HasPrefix(spec, "HEADER.FIELDS.NOT")
+		return headerFields(msg.Header, strings.Fields(spec[i+1:j]), not), true
+	}
+	return nil, false
+}
+
+// headerFields filters the fields of a raw header, keeping continuation lines
+func headerFields(header []byte, names []string, not bool) []byte {
+	want := map[string]bool{}
+	for _, n := range names {
+		want[strings.ToLower(n)] = true
+	}
+	var b bytes.Buffer
+	keep := false
+	for _, line := range bytes.SplitAfter(header, []byte("\r\n")) {
+		if len(line) == 0 || bytes.Equal(line, []byte("\r\n")) {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			name := line
+			if i := bytes.IndexByte(line, ':'); i >= 0 {
+				name = line[:i]
+			}
+			keep = want[strings.ToLower(string(bytes.TrimSpace(name)))] != not
+		}
+		if keep {
+			b.Write(line)
+		}
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+func envelope(h textproto.MIMEHeader) string {
+	from := addresses(h.Get("From"))
+	sender, replyTo := addresses(h.Get("Sender")), addresses(h.Get("Reply-To"))
+	if sender == "NIL" {
+		sender = from
+	}
+	if replyTo == "NIL" {
+		replyTo = from
+	}
+	return fmt.Sprintf("(%s %s %s %s %s %s %s %s %s %s)",
+		nstring(h.Get("Date")), nstring(h.Get("Subject")), from, sender, replyTo,
+		addresses(h.Get("To")), addresses(h.Get("Cc")), addresses(h.Get("Bcc")),
+		nstring(h.Get("In-Reply-To")), nstring(h.Get("Message-Id")))
+}
+
+func addresses(s string) string {
+	if s == "" {
+		return "NIL"
+	}
+	l, err := mail.ParseAddressList(s)
+	if err != nil || len(l) == 0 {
+		return "NIL"
+	}
+	var b strings.Builder
+	b.WriteString("(")
+	for _, a := range l {
+		box, host := a.Address, ""
+		if i := strings.LastIndexByte(box, '@'); i >= 0 {
+			box, host = box[:i], box[i+1:]
+		}
+		fmt.Fprintf(&b, "(%s NIL %s %s)", nstring(a.Name), nstring(box), nstring(host))
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func params(p map[string]string) string {
+	if len(p) == 0 {
+		return "NIL"
+	}
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var l []string
+	for _, k := range keys {
+		l = append(l, str(k), str(p[k]))
+	}
+	return "(" + strings.Join(l, " ") + ")"
+}
+
+func disposition(p *mailbox.Part) string {
+	d, dp, err := mime.ParseMediaType(p.Field("Content-Disposition"))
+	if err != nil {
+		return "NIL"
+	}
+	return fmt.Sprintf("(%s %s)", str(d), params(dp))
+}
+
+// bodyStructure renders BODYSTRUCTURE, or BODY without extension data
+func bodyStructure(p *mailbox.Part, ext bool) string {
+	var b strings.Builder
+	b.WriteString("(")
+	if p.Type == "multipart" && len(p.Parts) > 0 {
+		for _, c := range p.Parts {
+			b.WriteString(bodyStructure(c, ext))
+		}
+		b.WriteString(" " + str(p.Subtype))
+		if ext {
+			fmt.Fprintf(&b, " %s %s NIL NIL", params(p.Params), disposition(p))
+		}
+		b.WriteString(")")
+		return b.String()
+	}
+
+	enc := strings.ToLower(p.Field("Content-Transfer-Encoding"))
+	if enc == "" {
+		enc = "7bit"
+	}
+	fmt.Fprintf(&b, "%s %s %s %s %s %s %d", str(p.Type), str(p.Subtype), params(p.Params),
+		nstring(p.Field("Content-Id")), nstring(p.Field("Content-Description")), str(enc), len(p.Body))
+	switch {
+	case p.Type == "text":
+		fmt.Fprintf(&b, " %d", p.Lines())
+	case p.Message != nil:
+		fmt.Fprintf(&b, " %s %s %d", envelope(p.Message.Fields), bodyStructure(p.Message, ext), p.Lines())
+	}
+	if ext {
+		fmt.Fprintf(&b, " %s %s NIL NIL", nstring(p.Field("Content-Md5")), disposition(p))
+	}
+	b.WriteString(")")
+	return b.String()
+}
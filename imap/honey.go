@@ -0,0 +1,581 @@
+package imap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"imap-honey/core"
+	"imap-honey/decoy"
+	"imap-honey/event"
+	"imap-honey/mailbox"
+	"imap-honey/persona"
+	"imap-honey/sasl"
+	"imap-honey/spool"
+)
+
+type Server struct {
+	debug         bool
+	addr          string
+	hostname      string
+	capability    string
+	listener      net.Listener
+	closed        bool
+	withTLS       bool
+	tlsConfig     *tls.Config
+	startTLS      bool
+	loginDisabled bool
+	logger        *event.Logger
+	authOK        bool
+	users         map[string]string         // accepted logins, any if empty
+	stores        map[string]*mailbox.Store // decoy mailboxes by login, "" for any
+	decoyMonths   int                       // generate mailboxes instead of the built-in one
+	canary        *decoy.Canary
+	spool         *spool.Spool
+	ntlmDomain    string // NetBIOS domain in NTLM challenges
+	ntlmDNSDomain string
+	persona       *persona.Persona
+	subscribers   core.Subscribers
+}
+
+func (server *Server) IsDebug() bool {
+	return server.debug
+}
+func (server *Server) SetDebug(d bool) {
+	server.debug = d
+}
+func (server *Server) IsQuiet() bool {
+	return server.logger.Quiet
+}
+func (server *Server) SetQuiet(q bool) {
+	server.logger.Quiet = q
+}
+func (server *Server) SetLogger(l *event.Logger) {
+	server.logger = l
+}
+
+// Subscribe passes every event to s after the logger
+func (server *Server) Subscribe(s core.Subscriber) {
+	server.subscribers = append(server.subscribers, s)
+}
+func (server *Server) SetCapability(s string) {
+	server.capability = s
+}
+
+// SetStartTLS offers STARTTLS on a plaintext listener
+func (server *Server) SetStartTLS(ok bool) {
+	server.startTLS = ok
+}
+
+// SetLoginDisabled refuses LOGIN and AUTHENTICATE before STARTTLS
+func (server *Server) SetLoginDisabled(ok bool) {
+	server.loginDisabled = ok
+}
+
+// SetPersona switches the replies, capability and timing to those of a
+// server product, -cap is to be set afterwards
+func (server *Server) SetPersona(p *persona.Persona) {
+	server.persona = p
+	server.capability = p.Capability
+}
+
+// SetNTLMDomain sets the domain names sent in NTLM challenges, they are
+// derived from the hostname when empty
+func (server *Server) SetNTLMDomain(domain string, dnsDomain string) {
+	server.ntlmDomain, server.ntlmDNSDomain = domain, dnsDomain
+}
+func (server *Server) SetAuthOK(ok bool) {
+	server.authOK = ok
+}
+
+// SetUsers restricts accepted logins to a comma separated list of
+// user or user:password
+func (server *Server) SetUsers(list string) {
+	server.users = map[string]string{}
+	for _, u := range strings.Split(list, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			p := strings.SplitN(u, ":", 2)
+			server.users[p[0]] = strings.Join(p[1:], "")
+		}
+	}
+}
+
+// SetStore serves s to username, or to logins without their own store
+// if username is empty. The built-in mailbox is used by default.
+func (server *Server) SetStore(username string, s *mailbox.Store) {
+	if server.stores == nil {
+		server.stores = map[string]*mailbox.Store{}
+	}
+	server.stores[username] = s
+}
+
+// SetDecoy generates a mailbox spread over months for logins without a
+// store, instead of the built-in one
+func (server *Server) SetDecoy(months int) {
+	server.decoyMonths = months
+}
+
+func (server *Server) SetSpool(sp *spool.Spool) {
+	server.spool = sp
+}
+
+// SetCanary replaces the {{...}} placeholders of served messages by
+// tokens unique to each session
+func (server *Server) SetCanary(c *decoy.Canary) {
+	server.canary = c
+}
+
+// Accept reports whether a login opens the decoy mailbox
+func (server *Server) Accept(username string, password string) bool {
+	if !server.authOK {
+		return false
+	}
+	if len(server.users) == 0 {
+		return true
+	}
+	p, ok := server.users[username]
+	return ok && (p == "" || p == password)
+}
+func (server *Server) SASL() *sasl.Config {
+	return &sasl.Config{Hostname: server.hostname, Domain: server.ntlmDomain, DNSDomain: server.ntlmDNSDomain}
+}
+func (server *Server) Closed() bool { return server.closed }
+func (server *Server) Close() {
+	server.closed = true
+	server.listener.Close()
+}
+
+// NewServer loads the certificate of a TLS listener, nil if it fails. See
+// New and core.LoadTLS for the options and errors of a server embedded in
+// another program.
+func NewServer(hostname string, addr string, certPath string, keyPath string, withTLS bool) *Server {
+	var tlsConfig *tls.Config
+	if withTLS || (certPath != "" && keyPath != "") {
+		var err error
+		if tlsConfig, err = core.LoadTLS(certPath, keyPath); err != nil {
+			return nil
+		}
+
+	}
+	server := &Server{false, addr, hostname, "IMAP4rev1 AUTH=PLAIN", nil, false, withTLS, tlsConfig, false, false, &event.Logger{}, false, nil, nil, 0, nil, nil, "", "", persona.Default("imap"), nil}
+	return server
+}
+
+// Session
+
+const (
+	// commandTimeout is how long a client may take to send a command
+	commandTimeout = 3 * time.Minute
+	// idleTimeout bounds an IDLE, RFC 2177 clients re-issue it every 29 minutes
+	idleTimeout = 29 * time.Minute
+	// writeTimeout is how long a reply may wait on a client not reading
+	writeTimeout = time.Minute
+)
+
+type Session struct {
+	server  *Server
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	summary *event.Summary
+	// Stateful stuff
+	state    int
+	username string
+	store    *mailbox.Store
+	selected *mailbox.Mailbox
+	readOnly bool
+	failures int // failed logins
+}
+
+func NewSession(
+	server *Server, conn net.Conn,
+	reader *bufio.Reader, writer *bufio.Writer,
+) *Session {
+	s := &Session{server, conn, reader, writer, event.NewSummary(), stateNotAuth, "", nil, nil, false, 0}
+	return s
+}
+func (sess *Session) Sendf(format string, args ...interface{}) {
+	fmt.Fprintf(sess.writer, format, args...)
+	sess.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	sess.writer.Flush()
+}
+
+// SetTimeout gives the client d to send what the session reads next
+func (sess *Session) SetTimeout(d time.Duration) {
+	sess.conn.SetReadDeadline(time.Now().Add(d))
+}
+
+// Readline reads a line of at most maxLine bytes, CRLF included, as SASL
+// responses and the DONE of IDLE
+func (sess *Session) Readline() (string, error) {
+	var line []byte
+	for {
+		l, err := sess.reader.ReadSlice('\n')
+		if len(line)+len(l) > maxLine {
+			return "", ErrLineTooLong
+		}
+		line = append(line, l...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+func (sess *Session) SetUsername(username string) {
+	sess.username = username
+}
+func (sess *Session) RemoteIP() string {
+	s := sess.conn.RemoteAddr().String()
+	ip, _, _ := net.SplitHostPort(s)
+	return ip
+}
+
+func (sess *Session) IsTLS() bool {
+	_, ok := sess.conn.(*tls.Conn)
+	return ok
+}
+
+// LoginDisabled reports whether plaintext logins are refused
+func (sess *Session) LoginDisabled() bool {
+	return sess.server.loginDisabled && !sess.IsTLS()
+}
+
+// Capability is the server capability, STARTTLS and LOGINDISABLED
+// included until TLS is active
+func (sess *Session) Capability() string {
+	c := sess.server.capability
+	if sess.IsTLS() || !sess.server.startTLS {
+		return c
+	}
+	var l []string
+	for _, w := range strings.Fields(c) {
+		if !sess.LoginDisabled() || !strings.HasPrefix(strings.ToUpper(w), "AUTH=") || !sasl.Cleartext(w[5:]) {
+			l = append(l, w)
+		}
+	}
+	l = append(l, "STARTTLS")
+	if sess.LoginDisabled() {
+		l = append(l, "LOGINDISABLED")
+	}
+	return strings.Join(l, " ")
+}
+
+// StartTLS upgrades the connection, data pipelined after the command
+// is dropped with the plaintext reader
+func (sess *Session) StartTLS() error {
+	conn := tls.Server(sess.conn, sess.server.tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	sess.conn = conn
+	sess.reader = bufio.NewReader(sess.summary.Reader(conn))
+	sess.writer = bufio.NewWriter(sess.summary.Writer(conn))
+	return nil
+}
+
+// literalMax bounds commands, messages can be APPENDed after login
+func (sess *Session) literalMax() int {
+	if sess.state == stateNotAuth {
+		return maxLiteral
+	}
+	return maxAppend
+}
+
+// Event returns a new event for this session, text is the legacy log line
+func (sess *Session) Event(typ string, text string) *event.Event {
+	ev := event.New(typ, "imap", sess.conn)
+	ev.Text = text
+	return ev
+}
+func (sess *Session) Emit(ev *event.Event) {
+	ev.Session = sess.summary.ID
+	sess.summary.Record(ev)
+	sess.server.logger.Log(ev)
+	sess.server.subscribers.Log(ev)
+}
+
+// Summarize emits the session summary
+func (sess *Session) Summarize(reason string) {
+	sess.Emit(sess.summary.Close(sess.Event(event.Close, ""), reason))
+}
+
+// Vars are the persona template variables answering command
+func (sess *Session) Vars(command *Command) *persona.Vars {
+	v := &persona.Vars{
+		Hostname:   sess.server.hostname,
+		ClientIP:   sess.RemoteIP(),
+		Session:    sess.summary.ID,
+		Capability: sess.Capability(),
+		Date:       time.Now(),
+	}
+	if sess.state != stateNotAuth {
+		v.Username = sess.username
+	}
+	if command != nil {
+		v.Tag, v.Command = command.Tag, command.Command
+		if command.Command == "AUTHENTICATE" && len(command.Args) > 0 {
+			v.Mechanism = command.Args[0].Value
+		}
+	}
+	return v
+}
+
+// Reply sends the persona response id
+func (sess *Session) Reply(id string, command *Command) {
+	sess.send(sess.server.persona.Reply(id, sess.Vars(command)))
+}
+
+// send writes a persona reply, template errors are logged
+func (sess *Session) send(reply string, err error) {
+	if err != nil {
+		sess.Error(err)
+	}
+	sess.Sendf("%s", reply)
+}
+
+// Error logs a server side error of the session
+func (sess *Session) Error(err error) {
+	sess.Emit(sess.Event(event.Error, fmt.Sprintf("IP: %s, ERROR: %v", sess.RemoteIP(), err)).
+		Set("error", err.Error()))
+}
+
+// loginResult answers a login after the persona delays, false when the
+// connection is to be closed
+func (sess *Session) loginResult(command *Command, ok bool) bool {
+	p := sess.server.persona
+	time.Sleep(p.LoginDelay)
+	if ok {
+		sess.Reply("login_ok", command)
+		return true
+	}
+	time.Sleep(p.FailDelay)
+	sess.Reply("login_failed", command)
+	sess.failures++
+	if p.MaxErrors > 0 && sess.failures >= p.MaxErrors {
+		sess.Reply("too_many_errors", command)
+		return false
+	}
+	return true
+}
+
+func handle_session(sess *Session) error {
+	if sess.server.IsDebug() {
+		sess.Emit(sess.Event(event.Open, fmt.Sprintf("IP: %s, OPENED", sess.RemoteIP())))
+	}
+
+	// Send greeting
+	time.Sleep(sess.server.persona.GreetingDelay)
+	sess.Reply("greeting", nil)
+
+	var command *Command
+	var e error
+	reason := event.ServerClose
+
+command:
+	sess.SetTimeout(commandTimeout)
+	command, e = ReadCommand(sess.reader, func() { sess.Sendf("+ Ready for literal data\r\n") }, sess.literalMax())
+	if command != nil {
+		if sess.server.IsDebug() || sess.state != stateNotAuth {
+			ev := sess.Event(event.Command, fmt.Sprintf("IP: %s, COMMAND: %s", sess.RemoteIP(), command.Raw))
+			ev.Command, ev.Username = command.Raw, sess.username
+			if sess.selected != nil {
+				ev.Set("mailbox", sess.selected.Name)
+			}
+			sess.Emit(ev)
+		}
+		sess.summary.AddCommand(command.Raw)
+	}
+	if serr, ok := e.(*SyntaxError); ok {
+		v := sess.Vars(&Command{Tag: serr.Tag})
+		v.Error = serr.Msg
+		sess.send(sess.server.persona.Reply("syntax_error", v))
+		goto command
+	}
+	if e != nil {
+		reason = event.ProtocolError
+		goto err
+	}
+
+	// Handle commands
+
+	switch command.Command {
+	case "CAPABILITY":
+		//sess.Sendf("* CAPABILITY ACL ID IDLE IMAP4rev1 AUTH=PLAIN\r\n")
+		sess.Reply("capability", command)
+		goto command
+	case "NOOP":
+		sess.Reply("noop", command)
+		goto command
+	case "ID":
+		sess.Reply("id", command)
+		goto command
+	case "STARTTLS":
+		if sess.IsTLS() {
+			sess.Reply("starttls_active", command)
+			goto command
+		}
+		if !sess.server.startTLS || sess.state != stateNotAuth {
+			sess.Reply("starttls_unavailable", command)
+			goto command
+		}
+		sess.Reply("starttls", command)
+		if e = sess.StartTLS(); e != nil {
+			reason = event.ProtocolError
+			goto err
+		}
+		goto command
+	case "AUTHENTICATE":
+		if sess.state != stateNotAuth {
+			sess.Reply("already_authenticated", command)
+			goto command
+		}
+		if len(command.Args) == 0 {
+			sess.Reply("auth_mechanism_missing", command)
+			goto command
+		}
+		mechanism, merr := sasl.NewServer(command.Args[0].Value, sess.server.SASL())
+		if merr != nil {
+			sess.Reply("auth_unsupported", command)
+			goto command
+		}
+		var initial *string
+		if len(command.Args) > 1 { // SASL-IR
+			initial = &command.Args[1].Value
+		}
+		creds, aerr := sasl.Authenticate(mechanism, initial,
+			func(challenge string) error {
+				sess.Sendf("+ %s\r\n", challenge)
+				return nil
+			},
+			func() (string, error) {
+				line, err := sess.Readline()
+				sess.summary.AddCommand(strings.TrimRight(line, "\r\n"))
+				return line, err
+			})
+		switch aerr {
+		case nil, sasl.ErrMalformed:
+		case sasl.ErrCancelled:
+			sess.Reply("auth_cancelled", command)
+			goto command
+		default:
+			e = aerr
+			goto err
+		}
+		ev := creds.Log(sess.Event(event.Login, fmt.Sprintf("IP: %s, LOGIN: %s", sess.RemoteIP(), creds)))
+		if aerr != nil {
+			sess.Emit(ev.Set("malformed", true))
+			sess.Reply("auth_malformed", command)
+			goto command
+		}
+		if sess.LoginDisabled() && sasl.Cleartext(creds.Mechanism) {
+			sess.Emit(ev.Set("refused", "LOGINDISABLED"))
+			sess.Reply("privacy_required", command)
+			goto command
+		}
+		ok := sess.login(creds.Username(), creds.Secret)
+		if ok {
+			ev.Set("accepted", true)
+		}
+		sess.Emit(ev)
+		if sess.loginResult(command, ok) {
+			goto command
+		}
+		goto close
+	case "LOGIN":
+		if sess.state != stateNotAuth {
+			sess.Reply("already_authenticated", command)
+			goto command
+		}
+		ev := sess.Event(event.Login, fmt.Sprintf("IP: %s, LOGIN: %s", sess.RemoteIP(), command.Arguments))
+		ev.Username, ev.Password = command.Username, command.Password
+		if sess.LoginDisabled() {
+			sess.Emit(ev.Set("mechanism", "LOGIN").Set("refused", "LOGINDISABLED"))
+			sess.Reply("privacy_required", command)
+			goto command
+		}
+		ok := sess.login(command.Username, command.Password)
+		if ok {
+			ev.Set("accepted", true)
+		}
+		sess.Emit(ev.Set("mechanism", "LOGIN"))
+		if sess.loginResult(command, ok) {
+			goto command
+		}
+		goto close
+	case "LOGOUT":
+		sess.Reply("logout", command)
+		reason = event.ClientQuit
+		goto close
+	default:
+		if sess.state != stateNotAuth {
+			var handled bool
+			if handled, e = sess.mailboxCommand(command); e != nil {
+				goto err
+			} else if handled {
+				goto command
+			}
+		}
+		sess.Reply("bad_command", command)
+		goto command
+	}
+
+close:
+	sess.conn.Close()
+	sess.Summarize(reason)
+	return nil
+
+err:
+	sess.conn.Close()
+	if reason == event.ServerClose {
+		reason = event.Reason(e)
+	}
+	sess.Summarize(reason)
+	return fmt.Errorf("handle_session: %v", e)
+}
+
+// Server
+
+func Listen(server *Server) error {
+	var ln net.Listener
+	var e error
+
+	if server.withTLS {
+		ln, e = tls.Listen("tcp", server.addr, server.tlsConfig)
+	} else {
+		ln, e = net.Listen("tcp", server.addr)
+	}
+	if e != nil {
+		return e
+	} else {
+		server.listener = ln
+	}
+	return nil
+}
+
+func Serve(server *Server) error {
+	for {
+		conn, e := server.listener.Accept()
+		if e != nil {
+			if server.Closed() {
+				break
+			}
+			return e
+		}
+		go func(conn_pointer *net.Conn) {
+			conn := *conn_pointer
+			summary := event.NewSummary()
+			sess := NewSession(
+				server, conn,
+				bufio.NewReader(summary.Reader(conn)), bufio.NewWriter(summary.Writer(conn)),
+			)
+			sess.summary = summary
+
+			handle_session(sess) // the close event carries the reason
+
+		}(&conn) //goroutine
+	}
+
+	return nil
+}
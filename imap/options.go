@@ -0,0 +1,192 @@
+package imap
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+
+	"imap-honey/core"
+	"imap-honey/decoy"
+	"imap-honey/event"
+	"imap-honey/mailbox"
+	"imap-honey/persona"
+	"imap-honey/spool"
+)
+
+// Option configures a server built by New
+type Option func(*Server) error
+
+// New builds a plaintext server on addr refusing every login, with the
+// default persona and a quiet logger, see the options for the rest. It
+// is started with its Listen and Serve methods.
+//
+//	server, err := imap.New(":143",
+//		imap.WithStartTLS(tlsConfig),
+//		imap.WithSubscriber(core.Credentials(record)))
+func New(addr string, opts ...Option) (*Server, error) {
+	server := NewServer("localhost", addr, "", "", false)
+	server.SetPersona(persona.Default("imap"))
+	server.logger = &event.Logger{Quiet: true}
+	for _, opt := range opts {
+		if err := opt(server); err != nil {
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+// Listen opens the listener on the address given to New
+func (server *Server) Listen() error {
+	return Listen(server)
+}
+
+// Addr returns the address listened on, nil before Listen. It holds the
+// port chosen for an address like "127.0.0.1:0".
+func (server *Server) Addr() net.Addr {
+	if server.listener == nil {
+		return nil
+	}
+	return server.listener.Addr()
+}
+
+// Serve accepts connections until the server is closed
+func (server *Server) Serve() error {
+	return Serve(server)
+}
+
+// WithHostname sets the name in greetings and NTLM challenges
+func WithHostname(hostname string) Option {
+	return func(server *Server) error {
+		server.hostname = hostname
+		return nil
+	}
+}
+
+// WithTLS serves implicit TLS, as on port 993
+func WithTLS(c *tls.Config) Option {
+	return func(server *Server) error {
+		if c == nil {
+			return errors.New("WithTLS: no TLS configuration")
+		}
+		server.tlsConfig, server.withTLS = c, true
+		return nil
+	}
+}
+
+// WithStartTLS offers STARTTLS on a plaintext listener
+func WithStartTLS(c *tls.Config) Option {
+	return func(server *Server) error {
+		if c == nil {
+			return errors.New("WithStartTLS: no TLS configuration")
+		}
+		server.tlsConfig, server.startTLS = c, true
+		return nil
+	}
+}
+
+// WithLoginDisabled refuses LOGIN and AUTHENTICATE before STARTTLS
+func WithLoginDisabled() Option {
+	return func(server *Server) error {
+		server.SetLoginDisabled(true)
+		return nil
+	}
+}
+
+// WithPersona answers like a server product, to be given before
+// WithCapability
+func WithPersona(p *persona.Persona) Option {
+	return func(server *Server) error {
+		if p == nil || p.Protocol != "imap" {
+			return errors.New("WithPersona: not an imap persona")
+		}
+		server.SetPersona(p)
+		return nil
+	}
+}
+
+// WithCapability replaces the capability of the persona
+func WithCapability(capability string) Option {
+	return func(server *Server) error {
+		if err := persona.Check(capability); err != nil {
+			return err
+		}
+		server.SetCapability(capability)
+		return nil
+	}
+}
+
+// WithLogger sends the events to the outputs of a logger
+func WithLogger(l *event.Logger) Option {
+	return func(server *Server) error {
+		server.SetLogger(l)
+		return nil
+	}
+}
+
+// WithSubscriber passes every event to s, see Subscribe
+func WithSubscriber(s core.Subscriber) Option {
+	return func(server *Server) error {
+		server.Subscribe(s)
+		return nil
+	}
+}
+
+// WithDebug emits the session open and command events
+func WithDebug() Option {
+	return func(server *Server) error {
+		server.SetDebug(true)
+		return nil
+	}
+}
+
+// WithNTLMDomain sets the domain names sent in NTLM challenges
+func WithNTLMDomain(domain string, dnsDomain string) Option {
+	return func(server *Server) error {
+		server.SetNTLMDomain(domain, dnsDomain)
+		return nil
+	}
+}
+
+// WithAccept accepts logins into the decoy mailbox, only those of users
+// given as user or user:password if any
+func WithAccept(users ...string) Option {
+	return func(server *Server) error {
+		server.SetAuthOK(true)
+		server.SetUsers(strings.Join(users, ","))
+		return nil
+	}
+}
+
+// WithStore serves s to username, or to any login if username is empty
+func WithStore(username string, s *mailbox.Store) Option {
+	return func(server *Server) error {
+		server.SetStore(username, s)
+		return nil
+	}
+}
+
+// WithDecoy generates mailboxes spread over months for logins without a
+// store
+func WithDecoy(months int) Option {
+	return func(server *Server) error {
+		server.SetDecoy(months)
+		return nil
+	}
+}
+
+// WithCanary plants tokens unique to each session in served messages
+func WithCanary(c *decoy.Canary) Option {
+	return func(server *Server) error {
+		server.SetCanary(c)
+		return nil
+	}
+}
+
+// WithSpool keeps the messages appended by clients
+func WithSpool(sp *spool.Spool) Option {
+	return func(server *Server) error {
+		server.SetSpool(sp)
+		return nil
+	}
+}
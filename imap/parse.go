@@ -0,0 +1,317 @@
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// RFC 3501/9051 command syntax
+
+const (
+	maxLine    = 8192
+	maxLiteral = 64 * 1024        // a whole command before login, literals included
+	maxAppend  = 20 * 1024 * 1024 // a whole command after login
+	maxDepth   = 10               // nested lists
+)
+
+// Argument kinds
+const (
+	Atom = iota
+	Quoted
+	Literal
+	List
+)
+
+type Arg struct {
+	Kind  int
+	Value string
+	List  []Arg
+}
+
+// String renders the argument in IMAP syntax, literals as quoted strings
+func (a Arg) String() string {
+	switch a.Kind {
+	case Quoted, Literal:
+		return quote(a.Value)
+	case List:
+		s := make([]string, len(a.List))
+		for i, v := range a.List {
+			s[i] = v.String()
+		}
+		return "(" + strings.Join(s, " ") + ")"
+	}
+	return a.Value
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// IsString reports whether a is an astring (atom, quoted or literal)
+func (a Arg) IsString() bool {
+	return a.Kind != List
+}
+
+// SyntaxError is answered with BAD, the session goes on
+type SyntaxError struct {
+	Tag string
+	Msg string
+}
+
+func (e *SyntaxError) Error() string { return e.Msg }
+
+var (
+	ErrLineTooLong    = errors.New("command line too long")
+	ErrCommandTooLong = errors.New("command too long")
+)
+
+type Command struct {
+	Tag       string
+	Command   string
+	Args      []Arg
+	Arguments string // Args in IMAP syntax, for logs
+	Raw       string // as received, literals included
+	Username  string
+	Password  string
+}
+
+// parser reads one command, literals included
+type parser struct {
+	r    *bufio.Reader
+	cont func()       // called before reading a synchronizing literal
+	raw  bytes.Buffer // the command so far, lines and literals
+	line []byte       // rest of the current line
+	max  int          // size limit of raw
+}
+
+// ReadCommand reads a command of at most max bytes from r. cont sends the
+// "+" continuation request for synchronizing literals. Syntax errors are
+// returned as *SyntaxError once the whole command is consumed.
+func ReadCommand(r *bufio.Reader, cont func(), max int) (*Command, error) {
+	p := &parser{r: r, cont: cont, max: max}
+	if err := p.nextLine(); err != nil {
+		return nil, err
+	}
+	c := &Command{}
+	defer func() { c.Raw = strings.TrimRight(p.raw.String(), "\r\n") }()
+
+	var err error
+	if c.Tag, err = p.atom(); err != nil || c.Tag == "" {
+		p.skip()
+		return c, &SyntaxError{"*", "missing tag"}
+	}
+	if !p.space() {
+		return c, &SyntaxError{c.Tag, "missing command"}
+	}
+	name, err := p.atom()
+	if err != nil || name == "" {
+		p.skip()
+		return c, &SyntaxError{c.Tag, "missing command"}
+	}
+	c.Command = strings.ToUpper(name)
+
+	for p.space() {
+		a, err := p.arg(0)
+		if err != nil {
+			if _, ok := err.(*SyntaxError); ok {
+				p.skip()
+				err = &SyntaxError{c.Tag, err.Error()}
+			}
+			return c, err
+		}
+		c.Args = append(c.Args, a)
+	}
+	if len(p.line) > 0 {
+		p.skip()
+		return c, &SyntaxError{c.Tag, "unexpected characters"}
+	}
+
+	s := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		s[i] = a.String()
+	}
+	c.Arguments = strings.Join(s, " ")
+	if c.Command == "LOGIN" && len(c.Args) == 2 && c.Args[0].IsString() && c.Args[1].IsString() {
+		c.Username, c.Password = c.Args[0].Value, c.Args[1].Value
+	}
+	return c, nil
+}
+
+// ParseCommand parses a complete command, literals inlined after CRLF
+func ParseCommand(s string) (*Command, error) {
+	if !strings.HasSuffix(s, "\n") {
+		s += "\r\n"
+	}
+	return ReadCommand(bufio.NewReader(strings.NewReader(s)), func() {}, maxLiteral)
+}
+
+// nextLine reads a line without its CRLF
+func (p *parser) nextLine() error {
+	var line []byte
+	for {
+		l, err := p.r.ReadSlice('\n')
+		if len(line)+len(l) > maxLine {
+			return ErrLineTooLong
+		}
+		if p.raw.Len()+len(line)+len(l) > p.max {
+			return ErrCommandTooLong
+		}
+		line = append(line, l...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				break
+			}
+			return err
+		}
+		break
+	}
+	p.raw.Write(line)
+	p.line = bytes.TrimRight(line, "\r\n")
+	return nil
+}
+
+// skip drops the rest of the line
+func (p *parser) skip() {
+	p.line = nil
+}
+
+func (p *parser) space() bool {
+	if len(p.line) > 0 && p.line[0] == ' ' {
+		p.line = p.line[1:]
+		return true
+	}
+	return false
+}
+
+func isAtomChar(c byte) bool {
+	return c > ' ' && c < 0x7f && !strings.ContainsRune(`(){ "\`, rune(c))
+}
+
+// atom reads an atom; brackets may hold spaces and lists, as in
+// BODY[HEADER.FIELDS (FROM TO)]
+func (p *parser) atom() (string, error) {
+	i, depth := 0, 0
+	for i < len(p.line) {
+		c := p.line[i]
+		if c == '[' {
+			depth++
+		} else if c == ']' && depth > 0 {
+			depth--
+		} else if depth == 0 && !isAtomChar(c) && !(i == 0 && c == '\\') { // \Flag
+			break
+		} else if depth > 0 && (c == '\r' || c == '\n') {
+			break
+		}
+		i++
+	}
+	if depth > 0 {
+		return "", &SyntaxError{Msg: "unbalanced brackets"}
+	}
+	a := string(p.line[:i])
+	p.line = p.line[i:]
+	return a, nil
+}
+
+func (p *parser) arg(depth int) (Arg, error) {
+	if len(p.line) == 0 {
+		return Arg{}, &SyntaxError{Msg: "missing argument"}
+	}
+	switch p.line[0] {
+	case '"':
+		return p.quoted()
+	case '{':
+		return p.literal()
+	case '(':
+		if depth >= maxDepth {
+			return Arg{}, &SyntaxError{Msg: "lists nested too deep"}
+		}
+		p.line = p.line[1:]
+		l := Arg{Kind: List}
+		for len(p.line) > 0 && p.line[0] != ')' {
+			if len(l.List) > 0 && !p.space() {
+				return Arg{}, &SyntaxError{Msg: "missing space in list"}
+			}
+			a, err := p.arg(depth + 1)
+			if err != nil {
+				return Arg{}, err
+			}
+			l.List = append(l.List, a)
+		}
+		if len(p.line) == 0 {
+			return Arg{}, &SyntaxError{Msg: "unterminated list"}
+		}
+		p.line = p.line[1:]
+		return l, nil
+	}
+	a, err := p.atom()
+	if err != nil {
+		return Arg{}, err
+	}
+	if a == "" {
+		return Arg{}, &SyntaxError{Msg: fmt.Sprintf("unexpected %q", p.line[0])}
+	}
+	return Arg{Kind: Atom, Value: a}, nil
+}
+
+func (p *parser) quoted() (Arg, error) {
+	var b strings.Builder
+	for i := 1; i < len(p.line); i++ {
+		switch c := p.line[i]; c {
+		case '\\':
+			if i+1 < len(p.line) && (p.line[i+1] == '\\' || p.line[i+1] == '"') {
+				i++
+				b.WriteByte(p.line[i])
+				continue
+			}
+			return Arg{}, &SyntaxError{Msg: "bad escape in quoted string"}
+		case '"':
+			p.line = p.line[i+1:]
+			return Arg{Kind: Quoted, Value: b.String()}, nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return Arg{}, &SyntaxError{Msg: "unterminated quoted string"}
+}
+
+// literal reads {n} (synchronizing) or {n+} (non-synchronizing, LITERAL+)
+// ending the line, then n bytes and the rest of the command
+func (p *parser) literal() (Arg, error) {
+	end := bytes.IndexByte(p.line, '}')
+	if end < 0 || end != len(p.line)-1 {
+		return Arg{}, &SyntaxError{Msg: "bad literal"}
+	}
+	spec := string(p.line[1:end])
+	sync := !strings.HasSuffix(spec, "+")
+	n, err := strconv.Atoi(strings.TrimSuffix(spec, "+"))
+	if err != nil || n < 0 {
+		return Arg{}, &SyntaxError{Msg: "bad literal size"}
+	}
+	if n > p.max-p.raw.Len() {
+		if sync { // the client waits, nothing to drain
+			return Arg{}, &SyntaxError{Msg: "literal too big"}
+		}
+		return Arg{}, fmt.Errorf("non-synchronizing literal of %d bytes", n)
+	}
+	if sync {
+		p.cont()
+	}
+	// read into raw as it comes, not allocated up front on the client's word
+	start := p.raw.Len()
+	if _, err := io.CopyN(&p.raw, p.r, int64(n)); err != nil {
+		return Arg{}, err
+	}
+	value := string(p.raw.Bytes()[start:])
+	if err := p.nextLine(); err != nil {
+		return Arg{}, err
+	}
+	return Arg{Kind: Literal, Value: value}, nil
+}
@@ -0,0 +1,97 @@
+package imap
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	var listTests = []struct {
+		command   string // input
+		name      string // expected command
+		arguments string // expected arguments
+		username  string
+		password  string
+	}{
+		{"a1 NOOP", "NOOP", "", "", ""},
+		{"a2 login joe password", "LOGIN", "joe password", "joe", "password"},
+		{`a3 LOGIN "john doe" "p@ss word"`, "LOGIN", `"john doe" "p@ss word"`, "john doe", "p@ss word"},
+		{`a4 LOGIN "jo\"e" "a\\b"`, "LOGIN", `"jo\"e" "a\\b"`, `jo"e`, `a\b`},
+		{"a5 LOGIN {4}\r\njohn {8}\r\np@ss wrd", "LOGIN", `"john" "p@ss wrd"`, "john", "p@ss wrd"},
+		{"a6 LOGIN {4+}\r\njohn secret", "LOGIN", `"john" secret`, "john", "secret"},
+		{"a7 FETCH 1:* (FLAGS BODY.PEEK[HEADER.FIELDS (FROM TO)])", "FETCH", "1:* (FLAGS BODY.PEEK[HEADER.FIELDS (FROM TO)])", "", ""},
+		{"a8 STATUS INBOX (MESSAGES UNSEEN) x y z", "STATUS", "INBOX (MESSAGES UNSEEN) x y z", "", ""},
+		{`a9 STORE 1 +FLAGS.SILENT (\Seen \Deleted)`, "STORE", `1 +FLAGS.SILENT (\Seen \Deleted)`, "", ""},
+		{"a10 LOGIN joe (not a string)", "LOGIN", "joe (not a string)", "", ""},
+	}
+
+	for _, tt := range listTests {
+		c, err := ParseCommand(tt.command)
+		if err != nil {
+			t.Errorf("parse %q: %v", tt.command, err)
+			continue
+		}
+		if c.Command != tt.name || c.Arguments != tt.arguments || c.Username != tt.username || c.Password != tt.password {
+			t.Errorf("parse %q\n wait: %s %s [%s] [%s]\n receive: %s %s [%s] [%s]", tt.command,
+				tt.name, tt.arguments, tt.username, tt.password,
+				c.Command, c.Arguments, c.Username, c.Password)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var listTests = []struct {
+		command string // input
+		tag     string // tag of the BAD response
+	}{
+		{"", "*"},
+		{"a1", "a1"},
+		{`a2 LOGIN "unterminated`, "a2"},
+		{`a3 LOGIN "bad\escape" x`, "a3"},
+		{"a4 LOGIN {4} joe", "a4"},
+		{"a5 FETCH 1 (FLAGS", "a5"},
+		{"a6 FETCH 1 BODY[TEXT", "a6"},
+		{"a7 LOGIN {999999}", "a7"},
+	}
+
+	for _, tt := range listTests {
+		_, err := ParseCommand(tt.command)
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("parse %q: wait syntax error, receive %v", tt.command, err)
+			continue
+		}
+		if serr.Tag != tt.tag {
+			t.Errorf("parse %q: wait tag %q, receive %q", tt.command, tt.tag, serr.Tag)
+		}
+	}
+}
+
+func TestCommandBudget(t *testing.T) {
+	literals := func(n int, size int, plus string) string {
+		s := "a1 APPEND INBOX"
+		for i := 0; i < n; i++ {
+			s += fmt.Sprintf(" {%d%s}\r\n%s", size, plus, strings.Repeat("x", size))
+		}
+		return s + "\r\n"
+	}
+	var listTests = []struct {
+		command string // input
+		syntax  bool   // BAD answered, the session goes on
+		ok      bool
+	}{
+		{literals(1, 1000, "+"), false, true},
+		{literals(2, 40000, "+"), false, false},
+		{literals(2, 40000, ""), true, false},
+		{literals(100, 1000, "+"), false, false},
+	}
+	for i, tt := range listTests {
+		_, err := ReadCommand(bufio.NewReader(strings.NewReader(tt.command)), func() {}, maxLiteral)
+		_, syntax := err.(*SyntaxError)
+		if (err == nil) != tt.ok || syntax != tt.syntax {
+			t.Errorf("command %d: receive %v", i, err)
+		}
+	}
+}
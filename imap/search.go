@@ -0,0 +1,321 @@
+package imap
+
+import (
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"imap-honey/mailbox"
+)
+
+// seqRange bounds are inclusive, 0 stands for "*"
+type seqRange struct {
+	lo, hi uint32
+}
+
+// seqSet is a sequence set such as 1:3,5,7:*
+type seqSet []seqRange
+
+func parseSeqSet(s string) (seqSet, error) {
+	var set seqSet
+	num := func(s string) (uint32, error) {
+		if s == "*" {
+			return 0, nil
+		}
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("invalid sequence set")
+		}
+		return uint32(n), nil
+	}
+	for _, r := range strings.Split(s, ",") {
+		b := strings.SplitN(r, ":", 2)
+		lo, err := num(b[0])
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if len(b) == 2 {
+			if hi, err = num(b[1]); err != nil {
+				return nil, err
+			}
+		}
+		set = append(set, seqRange{lo, hi})
+	}
+	return set, nil
+}
+
+// Contains reports whether n is in the set, max is the value of "*"
+func (set seqSet) Contains(n uint32, max uint32) bool {
+	for _, r := range set {
+		lo, hi := r.lo, r.hi
+		if lo == 0 {
+			lo = max
+		}
+		if hi == 0 {
+			hi = max
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if n >= lo && n <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// messages returns the sequence numbers of the selected messages in a
+// sequence set, or a UID set with uid
+func (sess *Session) messages(s string, uid bool) ([]int, error) {
+	set, err := parseSeqSet(s)
+	if err != nil {
+		return nil, err
+	}
+	msgs := sess.selected.Messages
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	var seqs []int
+	for i, m := range msgs {
+		if uid && set.Contains(m.UID, msgs[len(msgs)-1].UID) ||
+			!uid && set.Contains(uint32(i+1), uint32(len(msgs))) {
+			seqs = append(seqs, i+1)
+		}
+	}
+	return seqs, nil
+}
+
+// matcher is a compiled SEARCH key
+type matcher func(seq int, m *mailbox.Message) bool
+
+type searchParser struct {
+	sess *Session
+	args []Arg
+}
+
+// parseSearch compiles the search keys of args, ANDed together
+func (sess *Session) parseSearch(args []Arg) (matcher, error) {
+	if len(args) >= 2 && strings.EqualFold(args[0].Value, "CHARSET") {
+		args = args[2:]
+	}
+	p := &searchParser{sess, args}
+	var keys []matcher
+	for len(p.args) > 0 {
+		k, err := p.key(0)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("missing search key")
+	}
+	return and(keys), nil
+}
+
+func and(keys []matcher) matcher {
+	return func(seq int, m *mailbox.Message) bool {
+		for _, k := range keys {
+			if !k(seq, m) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func (p *searchParser) next() (Arg, error) {
+	if len(p.args) == 0 {
+		return Arg{}, fmt.Errorf("missing search argument")
+	}
+	a := p.args[0]
+	p.args = p.args[1:]
+	return a, nil
+}
+
+func (p *searchParser) str() (string, error) {
+	a, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if !a.IsString() {
+		return "", fmt.Errorf("search argument must be a string")
+	}
+	return a.Value, nil
+}
+
+func (p *searchParser) date() (time.Time, error) {
+	s, err := p.str()
+	if err != nil {
+		return time.Time{}, err
+	}
+	d, err := time.Parse("2-Jan-2006", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid search date")
+	}
+	return d, nil
+}
+
+func contains(s string, sub string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+}
+
+func day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func sentDate(m *mailbox.Message) time.Time {
+	d, err := m.Header().Date()
+	if err != nil {
+		return m.InternalDate
+	}
+	return d
+}
+
+func (p *searchParser) key(depth int) (matcher, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("search keys nested too deep")
+	}
+	a, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if a.Kind == List {
+		sub := &searchParser{p.sess, a.List}
+		var keys []matcher
+		for len(sub.args) > 0 {
+			k, err := sub.key(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		}
+		return and(keys), nil
+	}
+
+	flag := func(f string, set bool) matcher {
+		return func(seq int, m *mailbox.Message) bool { return m.HasFlag(f) == set }
+	}
+	key := strings.ToUpper(a.Value)
+	switch key {
+	case "ALL", "OLD":
+		return func(int, *mailbox.Message) bool { return true }, nil
+	case "NEW", "RECENT":
+		return func(int, *mailbox.Message) bool { return false }, nil
+	case "ANSWERED", "DELETED", "DRAFT", "FLAGGED", "SEEN":
+		return flag(`\`+key[:1]+strings.ToLower(key[1:]), true), nil
+	case "UNANSWERED", "UNDELETED", "UNDRAFT", "UNFLAGGED", "UNSEEN":
+		return flag(`\`+key[2:3]+strings.ToLower(key[3:]), false), nil
+	case "KEYWORD", "UNKEYWORD":
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return flag(s, key == "KEYWORD"), nil
+	case "BCC", "CC", "FROM", "TO", "SUBJECT":
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return func(seq int, m *mailbox.Message) bool {
+			return contains(strings.Join(m.Structure().Fields[textproto.CanonicalMIMEHeaderKey(key)], "\n"), s)
+		}, nil
+	case "HEADER":
+		field, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return func(seq int, m *mailbox.Message) bool {
+			v, ok := m.Structure().Fields[textproto.CanonicalMIMEHeaderKey(field)]
+			return ok && contains(strings.Join(v, "\n"), s)
+		}, nil
+	case "BODY", "TEXT":
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		return func(seq int, m *mailbox.Message) bool {
+			if key == "TEXT" {
+				return contains(string(m.Data), s)
+			}
+			return contains(string(m.Structure().Body), s)
+		}, nil
+	case "BEFORE", "ON", "SINCE", "SENTBEFORE", "SENTON", "SENTSINCE":
+		d, err := p.date()
+		if err != nil {
+			return nil, err
+		}
+		return func(seq int, m *mailbox.Message) bool {
+			t := m.InternalDate
+			if strings.HasPrefix(key, "SENT") {
+				t = sentDate(m)
+			}
+			t = day(t)
+			switch strings.TrimPrefix(key, "SENT") {
+			case "BEFORE":
+				return t.Before(d)
+			case "ON":
+				return t.Equal(d)
+			}
+			return !t.Before(d)
+		}, nil
+	case "LARGER", "SMALLER":
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size")
+		}
+		return func(seq int, m *mailbox.Message) bool {
+			if key == "LARGER" {
+				return m.Size() > n
+			}
+			return m.Size() < n
+		}, nil
+	case "NOT":
+		k, err := p.key(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return func(seq int, m *mailbox.Message) bool { return !k(seq, m) }, nil
+	case "OR":
+		k1, err := p.key(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		k2, err := p.key(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return func(seq int, m *mailbox.Message) bool { return k1(seq, m) || k2(seq, m) }, nil
+	case "UID":
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		set, err := parseSeqSet(s)
+		if err != nil {
+			return nil, err
+		}
+		msgs := p.sess.selected.Messages
+		return func(seq int, m *mailbox.Message) bool {
+			return len(msgs) > 0 && set.Contains(m.UID, msgs[len(msgs)-1].UID)
+		}, nil
+	}
+	set, err := parseSeqSet(a.Value)
+	if err != nil || a.Kind != Atom {
+		return nil, fmt.Errorf("unknown search key %s", a.Value)
+	}
+	max := uint32(len(p.sess.selected.Messages))
+	return func(seq int, m *mailbox.Message) bool { return set.Contains(uint32(seq), max) }, nil
+}
@@ -0,0 +1,337 @@
+package imap
+
+import (
+	"fmt"
+	"strings"
+
+	"imap-honey/decoy"
+	"imap-honey/mailbox"
+)
+
+// Session states
+const (
+	stateNotAuth = iota
+	stateAuth
+	stateSelected
+)
+
+// login opens the decoy mailbox if the server accepts the credentials
+func (sess *Session) login(username string, password string) bool {
+	if username == "" || !sess.server.Accept(username, password) {
+		return false
+	}
+	sess.state = stateAuth
+	sess.SetUsername(username)
+	if store, ok := sess.server.stores[username]; ok {
+		sess.store = store.Clone()
+	} else if store, ok := sess.server.stores[""]; ok {
+		sess.store = store.Clone()
+	} else if sess.server.decoyMonths > 0 {
+		p := decoy.PersonaFor(username, mailbox.Domain(sess.server.hostname))
+		sess.store = decoy.Generate(p, sess.server.decoyMonths, decoy.Seed(username, sess.server.hostname))
+	} else {
+		sess.store = mailbox.Default(sess.server.hostname, username)
+	}
+	if sess.server.canary != nil {
+		sess.server.canary.Personalize(sess.store, sess.summary.ID)
+	}
+	return true
+}
+
+// mailboxCommand handles the authenticated and selected states, it
+// returns false for unknown commands
+func (sess *Session) mailboxCommand(c *Command) (bool, error) {
+	name, args, uid := c.Command, c.Args, false
+	if name == "UID" {
+		if len(args) == 0 || args[0].Kind != Atom {
+			sess.Sendf("%s BAD missing UID command\r\n", c.Tag)
+			return true, nil
+		}
+		name, args, uid = strings.ToUpper(args[0].Value), args[1:], true
+		switch name {
+		case "FETCH", "SEARCH", "STORE", "COPY", "EXPUNGE":
+		default:
+			sess.Sendf("%s BAD invalid UID command\r\n", c.Tag)
+			return true, nil
+		}
+	}
+
+	switch name {
+	case "LIST", "LSUB":
+		sess.list(c, name, args)
+	case "STATUS":
+		sess.status(c, args)
+	case "SELECT", "EXAMINE":
+		sess.open(c, name, args)
+	case "CREATE", "DELETE", "RENAME":
+		sess.Sendf("%s NO [NOPERM] Permission denied\r\n", c.Tag)
+	case "APPEND":
+		sess.appendMessage(c)
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		sess.Sendf("%s OK %s completed\r\n", c.Tag, name)
+	case "IDLE":
+		sess.Sendf("+ idling\r\n")
+		sess.SetTimeout(idleTimeout)
+		for {
+			line, err := sess.Readline()
+			if err != nil {
+				return true, err
+			}
+			sess.summary.AddCommand(strings.TrimRight(line, "\r\n"))
+			if strings.EqualFold(strings.TrimSpace(line), "DONE") {
+				break
+			}
+		}
+		sess.Sendf("%s OK IDLE terminated\r\n", c.Tag)
+	case "CHECK", "CLOSE", "UNSELECT", "EXPUNGE", "FETCH", "STORE", "SEARCH", "COPY":
+		if sess.state != stateSelected {
+			sess.Sendf("%s BAD No mailbox selected\r\n", c.Tag)
+			return true, nil
+		}
+		switch name {
+		case "CHECK":
+			sess.Sendf("%s OK CHECK completed\r\n", c.Tag)
+		case "CLOSE", "UNSELECT":
+			if name == "CLOSE" && !sess.readOnly {
+				sess.selected.Expunge(nil)
+			}
+			sess.state, sess.selected = stateAuth, nil
+			sess.Sendf("%s OK %s completed\r\n", c.Tag, name)
+		case "EXPUNGE":
+			sess.expunge(c, args, uid)
+		case "FETCH":
+			sess.fetch(c, args, uid)
+		case "STORE":
+			sess.storeFlags(c, args, uid)
+		case "SEARCH":
+			sess.search(c, args, uid)
+		case "COPY":
+			sess.copy(c, args, uid)
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func (sess *Session) list(c *Command, name string, args []Arg) {
+	if len(args) > 0 && args[0].Kind == List { // LIST-EXTENDED selection options
+		args = args[1:]
+	}
+	if len(args) < 2 || !args[0].IsString() || !args[1].IsString() {
+		sess.Sendf("%s BAD invalid arguments\r\n", c.Tag)
+		return
+	}
+	delim := sess.store.Delimiter
+	if args[1].Value == "" {
+		sess.Sendf("* %s (\\Noselect) %s \"\"\r\n", name, quote(delim))
+	} else {
+		for _, mb := range sess.store.List(args[0].Value, args[1].Value) {
+			attrs := append([]string{`\HasNoChildren`}, mb.Attributes...)
+			sess.Sendf("* %s (%s) %s %s\r\n", name, strings.Join(attrs, " "), quote(delim), str(mb.Name))
+		}
+	}
+	sess.Sendf("%s OK %s completed\r\n", c.Tag, name)
+}
+
+func (sess *Session) lookup(c *Command, a Arg) *mailbox.Mailbox {
+	if !a.IsString() {
+		sess.Sendf("%s BAD invalid mailbox name\r\n", c.Tag)
+		return nil
+	}
+	mb := sess.store.Get(a.Value)
+	if mb == nil {
+		sess.Sendf("%s NO [NONEXISTENT] Mailbox doesn't exist: %s\r\n", c.Tag, a.Value)
+	}
+	return mb
+}
+
+func (sess *Session) status(c *Command, args []Arg) {
+	if len(args) != 2 || args[1].Kind != List {
+		sess.Sendf("%s BAD invalid arguments\r\n", c.Tag)
+		return
+	}
+	mb := sess.lookup(c, args[0])
+	if mb == nil {
+		return
+	}
+	var items []string
+	for _, a := range args[1].List {
+		var n interface{}
+		item := strings.ToUpper(a.Value)
+		switch item {
+		case "MESSAGES":
+			n = len(mb.Messages)
+		case "RECENT":
+			n = 0
+		case "UIDNEXT":
+			n = mb.UIDNext()
+		case "UIDVALIDITY":
+			n = mb.UIDValidity
+		case "UNSEEN":
+			n, _ = mb.Unseen()
+		default:
+			sess.Sendf("%s BAD invalid status item %s\r\n", c.Tag, a.Value)
+			return
+		}
+		items = append(items, fmt.Sprintf("%s %d", item, n))
+	}
+	sess.Sendf("* STATUS %s (%s)\r\n", str(mb.Name), strings.Join(items, " "))
+	sess.Sendf("%s OK STATUS completed\r\n", c.Tag)
+}
+
+// open answers SELECT and EXAMINE
+func (sess *Session) open(c *Command, name string, args []Arg) {
+	if len(args) < 1 {
+		sess.Sendf("%s BAD invalid arguments\r\n", c.Tag)
+		return
+	}
+	sess.state, sess.selected = stateAuth, nil
+	mb := sess.lookup(c, args[0])
+	if mb == nil {
+		return
+	}
+	sess.state, sess.selected, sess.readOnly = stateSelected, mb, name == "EXAMINE"
+
+	sess.Sendf("* FLAGS (%s)\r\n", strings.Join(mailbox.SystemFlags, " "))
+	if sess.readOnly {
+		sess.Sendf("* OK [PERMANENTFLAGS ()] Read-only mailbox\r\n")
+	} else {
+		sess.Sendf("* OK [PERMANENTFLAGS (%s \\*)] Flags permitted\r\n", strings.Join(mailbox.SystemFlags, " "))
+	}
+	sess.Sendf("* %d EXISTS\r\n", len(mb.Messages))
+	sess.Sendf("* 0 RECENT\r\n")
+	if _, first := mb.Unseen(); first > 0 {
+		sess.Sendf("* OK [UNSEEN %d] First unseen\r\n", first)
+	}
+	sess.Sendf("* OK [UIDVALIDITY %d] UIDs valid\r\n", mb.UIDValidity)
+	sess.Sendf("* OK [UIDNEXT %d] Predicted next UID\r\n", mb.UIDNext())
+	if sess.readOnly {
+		sess.Sendf("%s OK [READ-ONLY] EXAMINE completed\r\n", c.Tag)
+	} else {
+		sess.Sendf("%s OK [READ-WRITE] SELECT completed\r\n", c.Tag)
+	}
+}
+
+func (sess *Session) expunge(c *Command, args []Arg, uid bool) {
+	if sess.readOnly {
+		sess.Sendf("%s NO [READ-ONLY] Mailbox is read-only\r\n", c.Tag)
+		return
+	}
+	var match func(m *mailbox.Message) bool
+	if uid {
+		if len(args) != 1 || args[0].Kind != Atom {
+			sess.Sendf("%s BAD invalid arguments\r\n", c.Tag)
+			return
+		}
+		seqs, err := sess.messages(args[0].Value, true)
+		if err != nil {
+			sess.Sendf("%s BAD %v\r\n", c.Tag, err)
+			return
+		}
+		in := map[*mailbox.Message]bool{}
+		for _, s := range seqs {
+			in[sess.selected.Messages[s-1]] = true
+		}
+		match = func(m *mailbox.Message) bool { return in[m] }
+	}
+	for _, seq := range sess.selected.Expunge(match) {
+		sess.Sendf("* %d EXPUNGE\r\n", seq)
+	}
+	sess.Sendf("%s OK %sEXPUNGE completed\r\n", c.Tag, uidPrefix(uid))
+}
+
+func (sess *Session) storeFlags(c *Command, args []Arg, uid bool) {
+	if len(args) < 3 || args[0].Kind != Atom || args[1].Kind != Atom {
+		sess.Sendf("%s BAD invalid arguments\r\n", c.Tag)
+		return
+	}
+	if sess.readOnly {
+		sess.Sendf("%s NO [READ-ONLY] Mailbox is read-only\r\n", c.Tag)
+		return
+	}
+	seqs, err := sess.messages(args[0].Value, uid)
+	if err != nil {
+		sess.Sendf("%s BAD %v\r\n", c.Tag, err)
+		return
+	}
+	item := strings.ToUpper(args[1].Value)
+	silent := strings.HasSuffix(item, ".SILENT")
+	item = strings.TrimSuffix(item, ".SILENT")
+	var list []string
+	for _, a := range args[2:] {
+		if a.Kind == List {
+			for _, f := range a.List {
+				list = append(list, f.Value)
+			}
+		} else {
+			list = append(list, a.Value)
+		}
+	}
+	if item != "FLAGS" && item != "+FLAGS" && item != "-FLAGS" {
+		sess.Sendf("%s BAD invalid store item %s\r\n", c.Tag, args[1].Value)
+		return
+	}
+	for _, seq := range seqs {
+		m := sess.selected.Messages[seq-1]
+		switch item {
+		case "FLAGS":
+			m.SetFlags(list...)
+		case "+FLAGS":
+			m.AddFlags(list...)
+		case "-FLAGS":
+			m.RemoveFlags(list...)
+		}
+		if !silent {
+			if uid {
+				sess.Sendf("* %d FETCH (UID %d FLAGS %s)\r\n", seq, m.UID, flags(m))
+			} else {
+				sess.Sendf("* %d FETCH (FLAGS %s)\r\n", seq, flags(m))
+			}
+		}
+	}
+	sess.Sendf("%s OK %sSTORE completed\r\n", c.Tag, uidPrefix(uid))
+}
+
+func (sess *Session) search(c *Command, args []Arg, uid bool) {
+	match, err := sess.parseSearch(args)
+	if err != nil {
+		sess.Sendf("%s BAD %v\r\n", c.Tag, err)
+		return
+	}
+	var found []string
+	for i, m := range sess.selected.Messages {
+		if match(i+1, m) {
+			if uid {
+				found = append(found, fmt.Sprint(m.UID))
+			} else {
+				found = append(found, fmt.Sprint(i+1))
+			}
+		}
+	}
+	sess.Sendf("* SEARCH%s\r\n", strings.TrimRight(" "+strings.Join(found, " "), " "))
+	sess.Sendf("%s OK %sSEARCH completed\r\n", c.Tag, uidPrefix(uid))
+}
+
+func (sess *Session) copy(c *Command, args []Arg, uid bool) {
+	if len(args) != 2 || args[0].Kind != Atom {
+		sess.Sendf("%s BAD invalid arguments\r\n", c.Tag)
+		return
+	}
+	seqs, err := sess.messages(args[0].Value, uid)
+	if err != nil {
+		sess.Sendf("%s BAD %v\r\n", c.Tag, err)
+		return
+	}
+	dst := sess.store.Get(args[1].Value)
+	if dst == nil {
+		sess.Sendf("%s NO [TRYCREATE] Mailbox doesn't exist: %s\r\n", c.Tag, args[1].Value)
+		return
+	}
+	for _, seq := range seqs {
+		m := *sess.selected.Messages[seq-1]
+		m.Flags = append([]string(nil), m.Flags...)
+		dst.Append(&m)
+	}
+	sess.Sendf("%s OK %sCOPY completed\r\n", c.Tag, uidPrefix(uid))
+}
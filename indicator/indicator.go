@@ -0,0 +1,144 @@
+package indicator
+
+import (
+	"crypto/sha256"
+	"html"
+	"math/big"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	URL    = "url"
+	Domain = "domain"
+	IP     = "ip"
+	Email  = "email"
+	BTC    = "btc"
+	ETH    = "eth"
+	XMR    = "xmr"
+)
+
+type Indicator struct {
+	Type  string
+	Value string
+}
+
+var (
+	reURL   = regexp.MustCompile(`(?i)\b(?:https?|ftp)://[^\s<>"'` + "`" + `(){}\[\]\\^|]+`)
+	reEmail = regexp.MustCompile(`\b[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}\b`)
+	reIPv4  = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`)
+	reAttr  = regexp.MustCompile(`(?i)\b(?:href|src|action)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	reBTC   = regexp.MustCompile(`\b(?:[13][1-9A-HJ-NP-Za-km-z]{25,34}|bc1[02-9ac-hj-np-z]{11,71})\b`)
+	reETH   = regexp.MustCompile(`\b0x[0-9a-fA-F]{40}\b`)
+	reXMR   = regexp.MustCompile(`\b[48][1-9A-HJ-NP-Za-km-z]{94}\b`)
+)
+
+// Extract returns unique indicators found in text. When isHTML is set,
+// entities are decoded and href/src/action attributes are scanned too.
+func Extract(text string, isHTML bool) []Indicator {
+	var list []Indicator
+	seen := map[Indicator]bool{}
+	add := func(typ, value string) {
+		i := Indicator{typ, value}
+		if value != "" && !seen[i] {
+			seen[i] = true
+			list = append(list, i)
+		}
+	}
+
+	if isHTML {
+		var attrs []string
+		for _, m := range reAttr.FindAllStringSubmatch(text, -1) {
+			attrs = append(attrs, html.UnescapeString(m[1]+m[2]+m[3]))
+		}
+		text = html.UnescapeString(text) + "\n" + strings.Join(attrs, "\n")
+	}
+
+	for _, u := range reURL.FindAllString(text, -1) {
+		u = strings.TrimRight(u, ".,;:!?'\"")
+		add(URL, u)
+		if p, err := url.Parse(u); err == nil {
+			if net.ParseIP(p.Hostname()) != nil {
+				add(IP, p.Hostname())
+			} else {
+				add(Domain, strings.ToLower(p.Hostname()))
+			}
+		}
+	}
+	for _, e := range reEmail.FindAllString(text, -1) {
+		add(Email, e)
+		add(Domain, strings.ToLower(e[strings.LastIndex(e, "@")+1:]))
+	}
+	for _, ip := range reIPv4.FindAllString(text, -1) {
+		add(IP, ip)
+	}
+	for _, w := range reBTC.FindAllString(text, -1) {
+		if strings.HasPrefix(w, "bc1") || base58Check(w) {
+			add(BTC, w)
+		}
+	}
+	for _, w := range reETH.FindAllString(text, -1) {
+		add(ETH, w)
+	}
+	for _, w := range reXMR.FindAllString(text, -1) {
+		add(XMR, w)
+	}
+	return list
+}
+
+// Defanged returns the value in a form that cannot be clicked or resolved
+func (i Indicator) Defanged() string {
+	switch i.Type {
+	case URL:
+		v := i.Value
+		if p, err := url.Parse(v); err == nil && p.Host != "" {
+			v = strings.Replace(v, p.Host, defang(p.Host), 1)
+		}
+		switch strings.ToLower(v[:3]) {
+		case "htt":
+			v = "hxxp" + v[4:]
+		case "ftp":
+			v = "fxp" + v[3:]
+		}
+		return v
+	case Email:
+		return defang(strings.Replace(i.Value, "@", "[@]", 1))
+	case Domain, IP:
+		return defang(i.Value)
+	}
+	return i.Value
+}
+
+func defang(s string) string {
+	return strings.ReplaceAll(s, ".", "[.]")
+}
+
+const b58 = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58Check validates legacy bitcoin addresses to avoid random matches
+func base58Check(s string) bool {
+	n := new(big.Int)
+	for _, c := range s {
+		i := strings.IndexRune(b58, c)
+		if i < 0 {
+			return false
+		}
+		n.Mul(n, big.NewInt(58))
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	b := n.Bytes()
+	for _, c := range s { // leading '1' are zero bytes
+		if c != '1' {
+			break
+		}
+		b = append([]byte{0}, b...)
+	}
+	if len(b) != 25 {
+		return false
+	}
+	h := sha256.Sum256(b[:21])
+	h = sha256.Sum256(h[:])
+	return string(h[:4]) == string(b[21:])
+}
@@ -0,0 +1,53 @@
+package indicator
+
+import (
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	text := `<p>Dear user, verify at <a href="https://login.example.com/verify?id=1&amp;x=2">here</a>
+<img src='http://198.51.100.7/pixel.gif'> or write to help@Example.org.
+Pay 1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2 or 0x52908400098527886E0F7030069857D2E4169EE7
+Fake 1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3</p>`
+
+	var listTests = []Indicator{
+		{URL, "https://login.example.com/verify?id=1&x=2"},
+		{Domain, "login.example.com"},
+		{URL, "http://198.51.100.7/pixel.gif"},
+		{IP, "198.51.100.7"},
+		{Email, "help@Example.org"},
+		{Domain, "example.org"},
+		{BTC, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"},
+		{ETH, "0x52908400098527886E0F7030069857D2E4169EE7"},
+	}
+
+	found := map[Indicator]bool{}
+	for _, i := range Extract(text, true) {
+		found[i] = true
+	}
+	for _, tt := range listTests {
+		if !found[tt] {
+			t.Errorf("missing %s %q", tt.Type, tt.Value)
+		}
+	}
+	if found[Indicator{BTC, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3"}] {
+		t.Errorf("invalid bitcoin checksum accepted")
+	}
+}
+
+func TestDefanged(t *testing.T) {
+	var listTests = []struct {
+		in  Indicator
+		out string
+	}{
+		{Indicator{URL, "https://evil.example.com/a.b"}, "hxxps://evil[.]example[.]com/a.b"},
+		{Indicator{Email, "a.b@example.com"}, "a[.]b[@]example[.]com"},
+		{Indicator{IP, "192.0.2.1"}, "192[.]0[.]2[.]1"},
+		{Indicator{ETH, "0x52908400098527886E0F7030069857D2E4169EE7"}, "0x52908400098527886E0F7030069857D2E4169EE7"},
+	}
+	for _, tt := range listTests {
+		if d := tt.in.Defanged(); d != tt.out {
+			t.Errorf("defang %q: wait %q, receive %q", tt.in.Value, tt.out, d)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package mailbox
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// a few kilobytes of business mail, enough to look like a real account
+var defaultMessages = []struct {
+	mailbox string
+	age     time.Duration
+	flags   []string
+	data    string
+}{
+	{"INBOX", 26 * 24 * time.Hour, []string{Seen}, `From: IT Service Desk <servicedesk@%[1]s>
+To: %[2]s
+Subject: Your VPN access has been renewed
+Date: %[3]s
+Message-ID: <vpn.%[4]s@%[1]s>
+MIME-Version: 1.0
+Content-Type: text/plain; charset=us-ascii
+
+Hello,
+
+Your remote access has been renewed for another 90 days.
+
+Portal: https://vpn.%[1]s/
+Username: same as your mailbox
+One-time enrollment code: 482 913
+
+Please do not share this code.
+
+IT Service Desk
+`},
+	{"INBOX", 9 * 24 * time.Hour, []string{Seen, Flagged}, `From: Accounts Payable <ap@%[1]s>
+To: %[2]s
+Subject: Invoice INV-20417 overdue
+Date: %[3]s
+Message-ID: <inv20417.%[4]s@%[1]s>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="=_inv20417"
+
+--=_inv20417
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 7bit
+
+Hi,
+
+Please find attached the overdue invoice INV-20417. Wire details are
+unchanged, payment is due by the end of the week.
+
+Regards,
+Accounts Payable
+--=_inv20417
+Content-Type: application/pdf; name="INV-20417.pdf"
+Content-Disposition: attachment; filename="INV-20417.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQKMSAwIG9iago8PCAvVHlwZSAvQ2F0YWxvZyAvUGFnZXMgMiAwIFIgPj4KZW5kb2Jq
+CjIgMCBvYmoKPDwgL1R5cGUgL1BhZ2VzIC9LaWRzIFtdIC9Db3VudCAwID4+CmVuZG9iagp0cmFp
+bGVyCjw8IC9Sb290IDEgMCBSID4+CiUlRU9GCg==
+--=_inv20417--
+`},
+	{"INBOX", 3 * 24 * time.Hour, []string{Seen, Answered}, `From: Jane Miller <jane.miller@%[1]s>
+To: %[2]s
+Subject: Re: Q3 payroll export
+Date: %[3]s
+Message-ID: <payroll.%[4]s@%[1]s>
+In-Reply-To: <payroll-q3@%[1]s>
+MIME-Version: 1.0
+Content-Type: multipart/alternative; boundary="=_alt"
+
+--=_alt
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+The export is on the finance share, same password as last quarter.
+I will send the updated bank details separately.
+
+Jane
+--=_alt
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+<html><body><p>The export is on the finance share, same password as last =
+quarter.<br>I will send the updated bank details separately.</p><p>Jane</p>=
+</body></html>
+--=_alt--
+`},
+	{"INBOX", 5 * time.Hour, nil, `From: Microsoft 365 <no-reply@%[1]s>
+To: %[2]s
+Subject: Password expiry notice
+Date: %[3]s
+Message-ID: <expiry.%[4]s@%[1]s>
+MIME-Version: 1.0
+Content-Type: text/plain; charset=us-ascii
+
+The password for %[2]s expires in 3 days.
+
+To keep using your account, change it at https://account.%[1]s/password
+`},
+	{"Sent", 4 * 24 * time.Hour, []string{Seen}, `From: %[2]s
+To: Jane Miller <jane.miller@%[1]s>
+Subject: Q3 payroll export
+Date: %[3]s
+Message-ID: <payroll-q3@%[1]s>
+MIME-Version: 1.0
+Content-Type: text/plain; charset=us-ascii
+
+Jane, could you send me the Q3 payroll export before Friday?
+`},
+}
+
+// Domain strips the host name of a FQDN, mail.example.com gives example.com
+func Domain(hostname string) string {
+	if l := strings.Split(hostname, "."); len(l) > 2 {
+		return strings.Join(l[1:], ".")
+	}
+	return hostname
+}
+
+// Default returns a small built-in mailbox for user at the domain of hostname
+func Default(hostname string, user string) *Store {
+	domain := Domain(hostname)
+	if !strings.Contains(user, "@") {
+		user += "@" + domain
+	}
+
+	s := NewStore()
+	s.Add(New("INBOX"))
+	s.Add(New("Drafts", `\Drafts`))
+	s.Add(New("Sent", `\Sent`))
+	s.Add(New("Junk", `\Junk`))
+	s.Add(New("Trash", `\Trash`))
+
+	now := time.Now().Truncate(time.Minute)
+	for _, d := range defaultMessages {
+		date := now.Add(-d.age)
+		data := fmt.Sprintf(d.data, domain, user, date.Format(time.RFC1123Z), date.Format("20060102150405"))
+		s.Get(d.mailbox).Append(NewMessage([]byte(data), date, d.flags...))
+	}
+	return s
+}
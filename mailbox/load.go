@@ -0,0 +1,239 @@
+package mailbox
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// special-use attributes of well known folder names
+var specialUse = map[string]string{
+	"archive": `\Archive`,
+	"drafts":  `\Drafts`,
+	"junk":    `\Junk`,
+	"spam":    `\Junk`,
+	"sent":    `\Sent`,
+	"trash":   `\Trash`,
+}
+
+// Load reads a Maildir directory (Maildir++ folders included) or an mbox
+// file. UIDs follow the file names or the mbox order and UIDVALIDITY
+// hashes the path and message list, so both are stable across restarts
+// as long as the files do not change.
+func Load(path string) (*Store, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	s := NewStore()
+	if !fi.IsDir() {
+		mb, err := loadMbox(path, "INBOX")
+		if err != nil {
+			return nil, err
+		}
+		s.Add(mb)
+		return s, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(path, "cur")); err != nil {
+		return nil, fmt.Errorf("%s: not a Maildir", path)
+	}
+	mb, err := loadMaildir(path, "INBOX")
+	if err != nil {
+		return nil, err
+	}
+	s.Add(mb)
+	dirs, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || !strings.HasPrefix(d.Name(), ".") || d.Name() == "." || d.Name() == ".." {
+			continue
+		}
+		name := strings.ReplaceAll(strings.TrimPrefix(d.Name(), "."), ".", s.Delimiter)
+		mb, err := loadMaildir(filepath.Join(path, d.Name()), name)
+		if err != nil {
+			return nil, err
+		}
+		s.Add(mb)
+	}
+	return s, nil
+}
+
+func newMailbox(name string, path string, keys []string) *Mailbox {
+	var attrs []string
+	if a, ok := specialUse[strings.ToLower(name)]; ok {
+		attrs = append(attrs, a)
+	}
+	mb := New(name, attrs...)
+	h := crc32.NewIEEE()
+	h.Write([]byte(path))
+	for _, k := range keys {
+		h.Write([]byte("\x00" + k))
+	}
+	mb.UIDValidity = h.Sum32() | 1
+	return mb
+}
+
+// Maildir info flags, "2,FRS"
+var maildirFlags = map[byte]string{'D': Draft, 'F': Flagged, 'R': Answered, 'S': Seen, 'T': Deleted}
+
+func loadMaildir(dir string, name string) (*Mailbox, error) {
+	type entry struct {
+		key, file, info string
+	}
+	var entries []entry
+	for _, sub := range []string{"new", "cur"} {
+		files, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, f := range files {
+			if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+				continue
+			}
+			key, info := f.Name(), ""
+			if i := strings.IndexByte(key, ':'); i >= 0 {
+				key, info = key[:i], key[i+1:]
+			}
+			entries = append(entries, entry{key, filepath.Join(dir, sub, f.Name()), info})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.key
+	}
+	mb := newMailbox(name, dir, keys)
+	for _, e := range entries {
+		data, err := os.ReadFile(e.file)
+		if err != nil {
+			return nil, err
+		}
+		var flags []string
+		if strings.HasPrefix(e.info, "2,") {
+			for i := 2; i < len(e.info); i++ {
+				if f, ok := maildirFlags[e.info[i]]; ok {
+					flags = append(flags, f)
+				}
+			}
+		}
+		m := NewMessage(data, time.Time{}, flags...)
+		if d, err := m.Header().Date(); err == nil {
+			m.InternalDate = d
+		} else if fi, err := os.Stat(e.file); err == nil {
+			m.InternalDate = fi.ModTime()
+		}
+		mb.Append(m)
+	}
+	return mb, nil
+}
+
+// mbox status headers, dropped from served messages
+var mboxHeaders = []string{"Status", "X-Status", "X-Keywords", "X-Uid", "X-Imap", "X-Imapbase", "Content-Length"}
+
+func loadMbox(file string, name string) (*Mailbox, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+
+	var msgs [][]byte
+	for len(data) > 0 {
+		if !bytes.HasPrefix(data, []byte("From ")) {
+			return nil, fmt.Errorf("%s: not a mbox file", file)
+		}
+		end := bytes.Index(data, []byte("\n\nFrom "))
+		if end < 0 {
+			msgs, data = append(msgs, data), nil
+		} else {
+			msgs, data = append(msgs, data[:end+1]), data[end+2:]
+		}
+	}
+
+	keys := make([]string, len(msgs))
+	for i, m := range msgs {
+		keys[i] = fmt.Sprintf("%08x", crc32.ChecksumIEEE(m))
+	}
+	mb := newMailbox(name, file, keys)
+	for _, raw := range msgs {
+		from, rest := raw, []byte(nil)
+		if i := bytes.IndexByte(raw, '\n'); i >= 0 {
+			from, rest = raw[:i], raw[i+1:]
+		}
+		header, body := rest, []byte(nil)
+		if i := bytes.Index(rest, []byte("\n\n")); i >= 0 {
+			header, body = rest[:i+1], rest[i+1:]
+		}
+
+		var flags []string
+		var kept [][]byte
+		drop := false
+		for _, line := range bytes.SplitAfter(header, []byte("\n")) {
+			if len(line) > 0 && line[0] != ' ' && line[0] != '\t' {
+				field, value := string(line), ""
+				if i := strings.IndexByte(field, ':'); i >= 0 {
+					field, value = field[:i], strings.TrimSpace(field[i+1:])
+				}
+				drop = false
+				for _, h := range mboxHeaders {
+					if strings.EqualFold(field, h) {
+						drop = true
+					}
+				}
+				if strings.EqualFold(field, "Status") && strings.Contains(value, "R") {
+					flags = append(flags, Seen)
+				}
+				if strings.EqualFold(field, "X-Status") {
+					for c, f := range map[string]string{"A": Answered, "F": Flagged, "D": Deleted, "T": Draft} {
+						if strings.Contains(value, c) {
+							flags = append(flags, f)
+						}
+					}
+				}
+			}
+			if !drop {
+				kept = append(kept, line)
+			}
+		}
+		sort.Strings(flags)
+
+		// mboxrd: >From, >>From... lose one >
+		var b bytes.Buffer
+		b.Write(bytes.Join(kept, nil))
+		for _, line := range bytes.SplitAfter(body, []byte("\n")) {
+			if t := bytes.TrimLeft(line, ">"); len(t) < len(line) && bytes.HasPrefix(t, []byte("From ")) {
+				line = line[1:]
+			}
+			b.Write(line)
+		}
+
+		m := NewMessage(b.Bytes(), time.Time{}, flags...)
+		if f := strings.Fields(string(from)); len(f) >= 3 {
+			m.InternalDate, _ = time.Parse(time.ANSIC, strings.Join(f[2:], " "))
+		}
+		if m.InternalDate.IsZero() {
+			if d, err := mail.ParseDate(m.Header().Get("Date")); err == nil {
+				m.InternalDate = d
+			}
+		}
+		mb.Append(m)
+	}
+	return mb, nil
+}
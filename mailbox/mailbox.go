@@ -0,0 +1,237 @@
+// Package mailbox is the decoy message store served after an accepted login
+package mailbox
+
+import (
+	"bytes"
+	"hash/crc32"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// System flags
+const (
+	Seen     = `\Seen`
+	Answered = `\Answered`
+	Flagged  = `\Flagged`
+	Deleted  = `\Deleted`
+	Draft    = `\Draft`
+)
+
+var SystemFlags = []string{Answered, Flagged, Deleted, Seen, Draft}
+
+// Token is a canary value embedded in a message
+type Token struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type Message struct {
+	UID          uint32
+	Flags        []string
+	InternalDate time.Time
+	Data         []byte // RFC 5322, CRLF line endings
+	Tokens       []Token
+}
+
+// NewMessage normalizes line endings to CRLF
+func NewMessage(data []byte, date time.Time, flags ...string) *Message {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+	return &Message{Flags: flags, InternalDate: date, Data: data}
+}
+
+func (m *Message) Size() int { return len(m.Data) }
+
+// Header returns the parsed header, empty if malformed
+func (m *Message) Header() mail.Header {
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		return mail.Header{}
+	}
+	return msg.Header
+}
+
+func (m *Message) HasFlag(flag string) bool {
+	for _, f := range m.Flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Message) AddFlags(flags ...string) {
+	for _, f := range flags {
+		if !m.HasFlag(f) {
+			m.Flags = append(m.Flags, f)
+		}
+	}
+}
+
+func (m *Message) RemoveFlags(flags ...string) {
+	kept := m.Flags[:0]
+	for _, f := range m.Flags {
+		remove := false
+		for _, r := range flags {
+			if strings.EqualFold(f, r) {
+				remove = true
+			}
+		}
+		if !remove {
+			kept = append(kept, f)
+		}
+	}
+	m.Flags = kept
+}
+
+func (m *Message) SetFlags(flags ...string) {
+	m.Flags = m.Flags[:0]
+	m.AddFlags(flags...)
+}
+
+type Mailbox struct {
+	Name        string
+	Attributes  []string // special-use, RFC 6154
+	UIDValidity uint32
+	Messages    []*Message
+	uidNext     uint32
+}
+
+// New returns an empty mailbox, UIDVALIDITY is derived from the name
+func New(name string, attributes ...string) *Mailbox {
+	return &Mailbox{Name: name, Attributes: attributes, UIDValidity: crc32.ChecksumIEEE([]byte(name)) | 1, uidNext: 1}
+}
+
+func (mb *Mailbox) UIDNext() uint32 {
+	if mb.uidNext == 0 {
+		mb.uidNext = 1
+		if n := len(mb.Messages); n > 0 {
+			mb.uidNext = mb.Messages[n-1].UID + 1
+		}
+	}
+	return mb.uidNext
+}
+
+// Append assigns the next UID to m
+func (mb *Mailbox) Append(m *Message) {
+	m.UID = mb.UIDNext()
+	mb.uidNext++
+	mb.Messages = append(mb.Messages, m)
+}
+
+// Unseen returns the number of messages without \Seen and the sequence
+// number of the first one
+func (mb *Mailbox) Unseen() (count int, first int) {
+	for i, m := range mb.Messages {
+		if !m.HasFlag(Seen) {
+			if count == 0 {
+				first = i + 1
+			}
+			count++
+		}
+	}
+	return count, first
+}
+
+// Expunge removes \Deleted messages accepted by match (all if nil) and
+// returns their sequence numbers, highest first so that each one is still
+// valid when reported
+func (mb *Mailbox) Expunge(match func(m *Message) bool) []int {
+	var seqs []int
+	kept := mb.Messages[:0]
+	for i, m := range mb.Messages {
+		if m.HasFlag(Deleted) && (match == nil || match(m)) {
+			seqs = append([]int{i + 1}, seqs...)
+		} else {
+			kept = append(kept, m)
+		}
+	}
+	mb.Messages = kept
+	return seqs
+}
+
+type Store struct {
+	Delimiter string
+	Mailboxes []*Mailbox
+}
+
+func NewStore() *Store {
+	return &Store{Delimiter: "/"}
+}
+
+func (s *Store) Add(mb *Mailbox) {
+	s.Mailboxes = append(s.Mailboxes, mb)
+}
+
+// Get finds a mailbox by name, INBOX is case-insensitive
+func (s *Store) Get(name string) *Mailbox {
+	for _, mb := range s.Mailboxes {
+		if mb.Name == name || (strings.EqualFold(name, "INBOX") && strings.EqualFold(mb.Name, "INBOX")) {
+			return mb
+		}
+	}
+	return nil
+}
+
+// List returns the mailboxes matching reference+pattern, "*" matches
+// anything and "%" anything but the delimiter
+func (s *Store) List(ref string, pattern string) []*Mailbox {
+	var l []*Mailbox
+	for _, mb := range s.Mailboxes {
+		name := mb.Name
+		if strings.EqualFold(name, "INBOX") && strings.EqualFold(ref+pattern, "INBOX") {
+			l = append(l, mb)
+			continue
+		}
+		if match(ref+pattern, name, s.Delimiter) {
+			l = append(l, mb)
+		}
+	}
+	return l
+}
+
+func match(pattern string, name string, delim string) bool {
+	if pattern == "" {
+		return name == ""
+	}
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(name); i++ {
+			if match(pattern[1:], name[i:], delim) {
+				return true
+			}
+		}
+		return false
+	case '%':
+		for i := 0; i <= len(name); i++ {
+			if match(pattern[1:], name[i:], delim) {
+				return true
+			}
+			if i < len(name) && strings.HasPrefix(name[i:], delim) {
+				return false
+			}
+		}
+		return false
+	}
+	return name != "" && name[0] == pattern[0] && match(pattern[1:], name[1:], delim)
+}
+
+// Clone copies mailboxes and flags so that a session can change them,
+// message data is shared
+func (s *Store) Clone() *Store {
+	c := &Store{Delimiter: s.Delimiter}
+	for _, mb := range s.Mailboxes {
+		n := *mb
+		n.UIDNext()
+		n.Messages = make([]*Message, len(mb.Messages))
+		for i, m := range mb.Messages {
+			cm := *m
+			cm.Flags = append([]string(nil), m.Flags...)
+			cm.Tokens = append([]Token(nil), m.Tokens...)
+			n.Messages[i] = &cm
+		}
+		c.Mailboxes = append(c.Mailboxes, &n)
+	}
+	return c
+}
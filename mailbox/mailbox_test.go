@@ -0,0 +1,237 @@
+package mailbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	s := NewStore()
+	for _, n := range []string{"INBOX", "Sent", "Archive/2021", "Archive/2022"} {
+		s.Add(New(n))
+	}
+	var listTests = []struct {
+		ref, pattern string
+		names        string
+	}{
+		{"", "*", "INBOX Sent Archive/2021 Archive/2022"},
+		{"", "%", "INBOX Sent"},
+		{"Archive/", "%", "Archive/2021 Archive/2022"},
+		{"", "inbox", "INBOX"},
+		{"", "S*", "Sent"},
+		{"", "*2", "Archive/2022"},
+		{"", "Trash", ""},
+	}
+	for _, tt := range listTests {
+		var names []string
+		for _, mb := range s.List(tt.ref, tt.pattern) {
+			names = append(names, mb.Name)
+		}
+		if strings.Join(names, " ") != tt.names {
+			t.Errorf("List(%q, %q) = %q, want %q", tt.ref, tt.pattern, names, tt.names)
+		}
+	}
+}
+
+func TestMailbox(t *testing.T) {
+	mb := New("INBOX")
+	for i := 0; i < 4; i++ {
+		mb.Append(NewMessage([]byte("Subject: x\n\nbody\n"), time.Now()))
+	}
+	if mb.Messages[3].UID != 4 || mb.UIDNext() != 5 {
+		t.Errorf("UIDs %d, next %d", mb.Messages[3].UID, mb.UIDNext())
+	}
+	if string(mb.Messages[0].Data) != "Subject: x\r\n\r\nbody\r\n" {
+		t.Errorf("CRLF not normalized: %q", mb.Messages[0].Data)
+	}
+
+	s := NewStore()
+	s.Add(mb)
+	c := s.Clone()
+	cb := c.Get("inbox")
+	cb.Messages[1].AddFlags(Deleted, Seen)
+	cb.Messages[3].AddFlags(Deleted)
+	if mb.Messages[1].HasFlag(Deleted) {
+		t.Errorf("Clone shares flags")
+	}
+	if n, first := cb.Unseen(); n != 3 || first != 1 {
+		t.Errorf("Unseen() = %d, %d", n, first)
+	}
+	if seqs := cb.Expunge(nil); len(seqs) != 2 || seqs[0] != 4 || seqs[1] != 2 {
+		t.Errorf("Expunge() = %v", seqs)
+	}
+	if len(cb.Messages) != 2 || len(mb.Messages) != 4 || cb.UIDNext() != 5 {
+		t.Errorf("after Expunge: %d messages, next UID %d", len(cb.Messages), cb.UIDNext())
+	}
+	cb.Messages[0].SetFlags(`\Seen`, `\seen`, "$Junk")
+	cb.Messages[0].RemoveFlags(`\SEEN`)
+	if len(cb.Messages[0].Flags) != 1 || cb.Messages[0].Flags[0] != "$Junk" {
+		t.Errorf("flags %q", cb.Messages[0].Flags)
+	}
+}
+
+func TestStructure(t *testing.T) {
+	data := `From: a@example.com
+Subject: test
+Content-Type: multipart/mixed; boundary="b1"
+
+preamble
+--b1
+Content-Type: text/plain
+
+hello
+--b1
+Content-Type: message/rfc822
+
+Subject: inner
+Content-Type: multipart/alternative; boundary=b2
+
+--b2
+
+plain
+--b2
+Content-Type: text/html
+
+<p>html</p>
+--b2--
+--b1--
+epilogue
+`
+	p := NewMessage([]byte(data), time.Now()).Structure()
+	if p.Type != "multipart" || p.Subtype != "mixed" || len(p.Parts) != 2 {
+		t.Fatalf("root %s/%s, %d parts", p.Type, p.Subtype, len(p.Parts))
+	}
+	if string(p.Parts[0].Body) != "hello" || p.Parts[0].Lines() != 1 {
+		t.Errorf("part 1 body %q", p.Parts[0].Body)
+	}
+	inner := p.Parts[1].Message
+	if inner == nil || inner.Field("Subject") != "inner" || len(inner.Parts) != 2 {
+		t.Fatalf("part 2 not parsed as message/rfc822")
+	}
+	if inner.Parts[0].Params["charset"] != "us-ascii" || string(inner.Parts[0].Body) != "plain" {
+		t.Errorf("default content type %v, body %q", inner.Parts[0].Params, inner.Parts[0].Body)
+	}
+	if inner.Parts[1].Subtype != "html" || string(inner.Parts[1].Header) != "Content-Type: text/html\r\n\r\n" {
+		t.Errorf("part 2.2 %s, header %q", inner.Parts[1].Subtype, inner.Parts[1].Header)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	s := Default("mail.example.com", "joe")
+	inbox := s.Get("INBOX")
+	if inbox == nil || len(inbox.Messages) == 0 {
+		t.Fatalf("no INBOX")
+	}
+	m := inbox.Messages[0]
+	if to := m.Header().Get("To"); to != "joe@example.com" {
+		t.Errorf("To: %q", to)
+	}
+	if s.Get("Sent") == nil || s.Get("Sent").Attributes[0] != `\Sent` {
+		t.Errorf("no Sent mailbox")
+	}
+}
+
+func TestLoadMaildir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"cur/1650000002.M2P1.host:2,RS":      "Subject: second\n\nb\n",
+		"cur/1650000001.M1P1.host:2,":        "Subject: first\nDate: Fri, 22 Apr 2022 10:00:00 +0000\n\na\n",
+		"new/1650000003.M3P1.host":           "Subject: third\n\nc\n",
+		".Sent/cur/1650000004.M4P1.host:2,S": "Subject: sent\n\nd\n",
+		".Archive.2021/cur/1.M5P1.host:2,F":  "Subject: old\n\ne\n",
+		"tmp/1650000009.M9P1.host":           "Subject: partial\n\n",
+	}
+	for name, data := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644)
+	}
+
+	s, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	inbox := s.Get("INBOX")
+	if len(inbox.Messages) != 3 {
+		t.Fatalf("INBOX has %d messages", len(inbox.Messages))
+	}
+	for i, subject := range []string{"first", "second", "third"} {
+		m := inbox.Messages[i]
+		if m.UID != uint32(i+1) || m.Header().Get("Subject") != subject {
+			t.Errorf("message %d: UID %d, subject %q", i+1, m.UID, m.Header().Get("Subject"))
+		}
+	}
+	if f := inbox.Messages[1].Flags; len(f) != 2 || f[0] != Answered || f[1] != Seen {
+		t.Errorf("flags %q", f)
+	}
+	if d := inbox.Messages[0].InternalDate; d.Year() != 2022 {
+		t.Errorf("internal date %v", d)
+	}
+	if sent := s.Get("Sent"); sent == nil || sent.Attributes[0] != `\Sent` || len(sent.Messages) != 1 {
+		t.Errorf("Sent folder not loaded")
+	}
+	if a := s.Get("Archive/2021"); a == nil || !a.Messages[0].HasFlag(Flagged) {
+		t.Errorf("Archive/2021 folder not loaded")
+	}
+
+	again, _ := Load(dir)
+	if again.Get("INBOX").UIDValidity != inbox.UIDValidity || again.Get("Sent").UIDValidity == inbox.UIDValidity {
+		t.Errorf("UIDVALIDITY not stable")
+	}
+	os.WriteFile(filepath.Join(dir, "cur/1650000000.M0P1.host:2,"), []byte("Subject: zero\n\n"), 0o644)
+	changed, _ := Load(dir)
+	if changed.Get("INBOX").UIDValidity == inbox.UIDValidity {
+		t.Errorf("UIDVALIDITY kept after renumbering")
+	}
+
+	if _, err := Load(filepath.Join(dir, "cur")); err == nil {
+		t.Errorf("Load accepted a directory without cur/")
+	}
+}
+
+func TestLoadMbox(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "inbox.mbox")
+	os.WriteFile(file, []byte(`From alice@example.com Fri Apr 22 10:00:00 2022
+Subject: one
+Status: RO
+X-Status: F
+
+>From the start
+>>From here
+
+From bob@example.com Sat Apr 23 10:00:00 2022
+Subject: two
+
+body
+`), 0o644)
+
+	s, err := Load(file)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	inbox := s.Get("INBOX")
+	if inbox == nil || len(inbox.Messages) != 2 {
+		t.Fatalf("INBOX not loaded")
+	}
+	m := inbox.Messages[0]
+	if string(m.Data) != "Subject: one\r\n\r\nFrom the start\r\n>From here\r\n" {
+		t.Errorf("message 1 %q", m.Data)
+	}
+	if !m.HasFlag(Seen) || !m.HasFlag(Flagged) || inbox.Messages[1].HasFlag(Seen) {
+		t.Errorf("flags %q %q", m.Flags, inbox.Messages[1].Flags)
+	}
+	if m.InternalDate.Day() != 22 || inbox.Messages[1].UID != 2 {
+		t.Errorf("date %v, UID %d", m.InternalDate, inbox.Messages[1].UID)
+	}
+	again, _ := Load(file)
+	if again.Get("INBOX").UIDValidity != inbox.UIDValidity {
+		t.Errorf("UIDVALIDITY not stable")
+	}
+
+	os.WriteFile(file, []byte("Subject: not mbox\n"), 0o644)
+	if _, err := Load(file); err == nil {
+		t.Errorf("Load accepted a file that is not mbox")
+	}
+}
@@ -0,0 +1,127 @@
+package mailbox
+
+import (
+	"bufio"
+	"bytes"
+	"mime"
+	"net/textproto"
+	"strings"
+)
+
+// maximum nesting of multipart and message/rfc822 parts
+const maxDepth = 20
+
+// Part is a node of the MIME tree, Header and Body are the raw bytes
+// of the message so that sections can be served as they were stored
+type Part struct {
+	Header  []byte // with the blank line
+	Body    []byte
+	Fields  textproto.MIMEHeader
+	Type    string // lower case
+	Subtype string
+	Params  map[string]string
+	Parts   []*Part // multipart children
+	Message *Part   // message/rfc822 content
+}
+
+// Structure parses the MIME tree of m
+func (m *Message) Structure() *Part {
+	return parsePart(m.Data, "text/plain", 0)
+}
+
+func parsePart(data []byte, def string, depth int) *Part {
+	p := &Part{}
+	p.Header, p.Body = splitHeader(data)
+	p.Fields, _ = textproto.NewReader(bufio.NewReader(bytes.NewReader(p.Header))).ReadMIMEHeader()
+	ctype, params, err := mime.ParseMediaType(p.Fields.Get("Content-Type"))
+	if err != nil || !strings.Contains(ctype, "/") {
+		ctype, params = def, map[string]string{}
+		if def == "text/plain" {
+			params["charset"] = "us-ascii"
+		}
+	}
+	p.Params = params
+	t := strings.SplitN(ctype, "/", 2)
+	p.Type, p.Subtype = t[0], t[1]
+
+	if depth >= maxDepth {
+		return p
+	}
+	switch {
+	case p.Type == "multipart" && params["boundary"] != "":
+		def := "text/plain"
+		if p.Subtype == "digest" {
+			def = "message/rfc822"
+		}
+		for _, b := range splitMultipart(p.Body, params["boundary"]) {
+			p.Parts = append(p.Parts, parsePart(b, def, depth+1))
+		}
+	case ctype == "message/rfc822":
+		p.Message = parsePart(p.Body, "text/plain", depth+1)
+	}
+	return p
+}
+
+// Field returns the first value of a header field
+func (p *Part) Field(name string) string {
+	return p.Fields.Get(name)
+}
+
+// Lines counts the lines of the body
+func (p *Part) Lines() int {
+	n := bytes.Count(p.Body, []byte("\r\n"))
+	if len(p.Body) > 0 && !bytes.HasSuffix(p.Body, []byte("\r\n")) {
+		n++
+	}
+	return n
+}
+
+func splitHeader(data []byte) ([]byte, []byte) {
+	if bytes.HasPrefix(data, []byte("\r\n")) {
+		return data[:2], data[2:]
+	}
+	i := bytes.Index(data, []byte("\r\n\r\n"))
+	if i < 0 {
+		return data, nil
+	}
+	return data[:i+4], data[i+4:]
+}
+
+// splitMultipart returns the raw body parts between boundary delimiters
+func splitMultipart(body []byte, boundary string) [][]byte {
+	delim := []byte("--" + boundary)
+	var parts [][]byte
+	start := -1
+	for i := 0; i < len(body); {
+		j := bytes.Index(body[i:], delim)
+		if j < 0 {
+			break
+		}
+		j += i
+		if j != 0 && (j < 2 || body[j-2] != '\r' || body[j-1] != '\n') {
+			i = j + len(delim)
+			continue
+		}
+		if start >= 0 {
+			end := j - 2
+			if end < start {
+				end = start
+			}
+			parts = append(parts, body[start:end])
+		}
+		rest := body[j+len(delim):]
+		if bytes.HasPrefix(rest, []byte("--")) {
+			return parts
+		}
+		eol := bytes.Index(rest, []byte("\r\n"))
+		if eol < 0 {
+			return parts
+		}
+		start = j + len(delim) + eol + 2
+		i = start
+	}
+	if start >= 0 {
+		parts = append(parts, body[start:])
+	}
+	return parts
+}
@@ -0,0 +1,164 @@
+package mailparse
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+)
+
+// limit on listed members, archives are attacker controlled
+const maxMembers = 1000
+
+var (
+	rar4Magic = []byte("Rar!\x1a\x07\x00")
+	rar5Magic = []byte("Rar!\x1a\x07\x01\x00")
+)
+
+// Members lists file names of zip and rar archives, nil otherwise
+func Members(data []byte) []string {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return zipMembers(data)
+	case bytes.HasPrefix(data, rar5Magic):
+		return rar5Members(data[len(rar5Magic):])
+	case bytes.HasPrefix(data, rar4Magic):
+		return rar4Members(data[len(rar4Magic):])
+	}
+	return nil
+}
+
+func zipMembers(data []byte) []string {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for i, f := range r.File {
+		if i == maxMembers {
+			break
+		}
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// rar4Members walks RAR 1.5-4.x block headers looking for file headers
+func rar4Members(b []byte) []string {
+	var names []string
+	for len(b) >= 7 && len(names) < maxMembers {
+		typ := b[2]
+		flags := binary.LittleEndian.Uint16(b[3:])
+		size := int(binary.LittleEndian.Uint16(b[5:]))
+		if size < 7 || size > len(b) {
+			break
+		}
+		next := size
+		if flags&0x8000 != 0 || typ == 0x74 {
+			if size < 11 {
+				break
+			}
+			next += int(binary.LittleEndian.Uint32(b[7:]))
+		}
+		if typ == 0x74 && size >= 32 {
+			nameSize := int(binary.LittleEndian.Uint16(b[26:]))
+			off := 32
+			if flags&0x100 != 0 { // large file, 64-bit sizes
+				off += 8
+			}
+			if off+nameSize <= size {
+				name := b[off : off+nameSize]
+				if i := bytes.IndexByte(name, 0); i >= 0 { // unicode names follow a NUL
+					name = name[:i]
+				}
+				names = append(names, string(name))
+			}
+		}
+		if typ == 0x7b || next <= 0 || next > len(b) { // end of archive
+			break
+		}
+		b = b[next:]
+	}
+	return names
+}
+
+// rar5Members walks RAR 5.0 headers, see https://www.rarlab.com/technote.htm
+func rar5Members(b []byte) []string {
+	var names []string
+	for len(b) > 4 && len(names) < maxMembers {
+		p := b[4:] // skip CRC32
+		hsize, n := uvarint(p)
+		if n <= 0 || hsize == 0 || uint64(len(p)-n) < hsize {
+			break
+		}
+		h := p[n : n+int(hsize)]
+		next := 4 + n + int(hsize)
+
+		typ, k := uvarint(h)
+		h = h[k:]
+		flags, k := uvarint(h)
+		h = h[k:]
+		if flags&1 != 0 { // extra area size
+			_, k = uvarint(h)
+			h = h[k:]
+		}
+		var dataSize uint64
+		if flags&2 != 0 {
+			dataSize, k = uvarint(h)
+			h = h[k:]
+		}
+		if typ == 2 { // file header
+			if name, ok := rar5Name(h); ok {
+				names = append(names, name)
+			}
+		}
+		if typ == 5 || dataSize > uint64(len(b)) {
+			break
+		}
+		next += int(dataSize)
+		if next > len(b) {
+			break
+		}
+		b = b[next:]
+	}
+	return names
+}
+
+func rar5Name(h []byte) (string, bool) {
+	fflags, k := uvarint(h)
+	h = h[k:]
+	for i := 0; i < 2; i++ { // unpacked size, attributes
+		_, k = uvarint(h)
+		h = h[k:]
+	}
+	if fflags&2 != 0 { // mtime
+		if len(h) < 4 {
+			return "", false
+		}
+		h = h[4:]
+	}
+	if fflags&4 != 0 { // data CRC32
+		if len(h) < 4 {
+			return "", false
+		}
+		h = h[4:]
+	}
+	for i := 0; i < 2; i++ { // compression info, host OS
+		_, k = uvarint(h)
+		h = h[k:]
+	}
+	l, k := uvarint(h)
+	h = h[k:]
+	if k <= 0 || uint64(len(h)) < l {
+		return "", false
+	}
+	return string(h[:l]), true
+}
+
+// uvarint never panics and returns n <= 0 on truncated input
+func uvarint(b []byte) (uint64, int) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0
+	}
+	return v, n
+}
@@ -0,0 +1,148 @@
+package mailparse
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+// maximum nesting of multipart and message/rfc822 parts
+const maxDepth = 20
+
+// Part is a decoded leaf of a MIME message
+type Part struct {
+	Filename    string
+	ContentType string // declared
+	Sniffed     string
+	Disposition string
+	Size        int
+	MD5         string
+	SHA1        string
+	SHA256      string
+	Members     []string // archive member names
+	Data        []byte
+}
+
+func (p *Part) String() string {
+	s := fmt.Sprintf("FILENAME: %q, TYPE: %s, SNIFFED: %s, SIZE: %d, MD5: %s, SHA1: %s, SHA256: %s",
+		p.Filename, p.ContentType, p.Sniffed, p.Size, p.MD5, p.SHA1, p.SHA256)
+	if len(p.Members) > 0 {
+		s += fmt.Sprintf(", MEMBERS: %q", p.Members)
+	}
+	return s
+}
+
+// IsAttachment reports whether the part is not a plain message body
+func (p *Part) IsAttachment() bool {
+	if p.Filename != "" || p.Disposition == "attachment" {
+		return true
+	}
+	return !strings.HasPrefix(p.ContentType, "text/")
+}
+
+type Message struct {
+	Header mail.Header
+	Parts  []*Part
+}
+
+// Parse walks the MIME tree of a RFC 5322 message. Malformed parts are
+// kept undecoded rather than aborting the walk.
+func Parse(data []byte) (*Message, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{Header: m.Header}
+	body, _ := io.ReadAll(m.Body)
+	msg.walk(m.Header, body, 0)
+	return msg, nil
+}
+
+// mail.Header or textproto.MIMEHeader
+type header interface {
+	Get(key string) string
+}
+
+func (msg *Message) walk(h header, body []byte, depth int) {
+	ctype, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		ctype, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(ctype, "multipart/") && params["boundary"] != "" && depth < maxDepth {
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err != nil {
+				break
+			}
+			b, _ := io.ReadAll(p)
+			msg.walk(p.Header, b, depth+1)
+		}
+		return
+	}
+
+	data := decode(h.Get("Content-Transfer-Encoding"), body)
+	if ctype == "message/rfc822" && depth < maxDepth {
+		if m, err := mail.ReadMessage(bytes.NewReader(data)); err == nil {
+			b, _ := io.ReadAll(m.Body)
+			msg.walk(m.Header, b, depth+1)
+		}
+	}
+	msg.Parts = append(msg.Parts, newPart(h, ctype, params, data))
+}
+
+func newPart(h header, ctype string, params map[string]string, data []byte) *Part {
+	p := &Part{ContentType: ctype, Size: len(data), Data: data}
+	disp, dparams, err := mime.ParseMediaType(h.Get("Content-Disposition"))
+	if err == nil {
+		p.Disposition = disp
+		p.Filename = dparams["filename"]
+	}
+	if p.Filename == "" {
+		p.Filename = params["name"]
+	}
+	dec := new(mime.WordDecoder)
+	if f, err := dec.DecodeHeader(p.Filename); err == nil {
+		p.Filename = f
+	}
+	p.Sniffed = strings.SplitN(http.DetectContentType(data), ";", 2)[0]
+	m, s1, s256 := md5.Sum(data), sha1.Sum(data), sha256.Sum256(data)
+	p.MD5, p.SHA1, p.SHA256 = hex.EncodeToString(m[:]), hex.EncodeToString(s1[:]), hex.EncodeToString(s256[:])
+	p.Members = Members(data)
+	return p
+}
+
+func decode(cte string, body []byte) []byte {
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		clean := bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, body)
+		clean = bytes.TrimRight(clean, "=")
+		d, err := base64.RawStdEncoding.DecodeString(string(clean))
+		if err == nil {
+			return d
+		}
+	case "quoted-printable":
+		d, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+		if err == nil {
+			return d
+		}
+	}
+	return body
+}
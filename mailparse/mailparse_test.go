@@ -0,0 +1,83 @@
+package mailparse
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func zipFile(t *testing.T, names ...string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, n := range names {
+		f, err := w.Create(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("MZ payload"))
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestParse(t *testing.T) {
+	z := zipFile(t, "invoice.pdf.exe", "readme.txt")
+	raw := "From: a@example.org\r\n" +
+		"Subject: invoice\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Please open =3D the invoice\r\n" +
+		"--b1\r\n" +
+		"Content-Type: application/octet-stream; name=\"=?utf-8?q?facture.zip?=\"\r\n" +
+		"Content-Disposition: attachment\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString(z) + "\r\n" +
+		"--b1--\r\n"
+
+	msg, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Parts) != 2 {
+		t.Fatalf("wait 2 parts, receive %d", len(msg.Parts))
+	}
+	if string(msg.Parts[0].Data) != "Please open = the invoice" {
+		t.Errorf("bad quoted-printable decoding %q", msg.Parts[0].Data)
+	}
+	if msg.Parts[0].IsAttachment() {
+		t.Errorf("text body seen as attachment")
+	}
+	a := msg.Parts[1]
+	if a.Filename != "facture.zip" || a.Sniffed != "application/zip" || a.Size != len(z) {
+		t.Errorf("bad attachment: %s", a)
+	}
+	if strings.Join(a.Members, ",") != "invoice.pdf.exe,readme.txt" {
+		t.Errorf("bad members %q", a.Members)
+	}
+	if len(a.SHA256) != 64 || len(a.SHA1) != 40 || len(a.MD5) != 32 {
+		t.Errorf("bad hashes: %s", a)
+	}
+}
+
+func TestRar5Members(t *testing.T) {
+	name := "a.exe"
+	h := []byte{2, 0, 0, 5, 0, 0, 0, byte(len(name))} // type, flags, fflags, size, attr, comp, os, len
+	h = append(h, name...)
+	rar := append([]byte{}, rar5Magic...)
+	rar = append(rar, 0, 0, 0, 0, byte(len(h)))
+	rar = append(rar, h...)
+	rar = append(rar, 0, 0, 0, 0, 3, 5, 0, 0) // end of archive
+
+	m := Members(rar)
+	if len(m) != 1 || m[0] != name {
+		t.Errorf("bad rar members %q", m)
+	}
+}
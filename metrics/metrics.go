@@ -0,0 +1,131 @@
+// Package metrics counts the events of every listener of a process and
+// serves them in the Prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"imap-honey/event"
+)
+
+type key struct {
+	protocol string
+	listener string
+	name     string
+}
+
+// Metrics are shared by all the listeners of a process
+type Metrics struct {
+	mu       sync.Mutex
+	events   map[key]int64 // by event type
+	sessions map[key]int64 // closed sessions by reason
+	logins   map[key]int64 // by mechanism
+	dropped  map[string]func() map[string]int64
+}
+
+func New() *Metrics {
+	return &Metrics{
+		events:   map[key]int64{},
+		sessions: map[key]int64{},
+		logins:   map[key]int64{},
+		dropped:  map[string]func() map[string]int64{},
+	}
+}
+
+// Output counts the events of a listener
+func (m *Metrics) Output(listener string) event.Output {
+	return &output{m, listener}
+}
+
+type output struct {
+	m        *Metrics
+	listener string
+}
+
+func (o *output) Log(ev *event.Event) {
+	m := o.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := key{ev.Protocol, o.listener, ev.Type}
+	m.events[k]++
+	switch ev.Type {
+	case event.Close:
+		k.name, _ = ev.Data["reason"].(string)
+		m.sessions[k]++
+	case event.Login:
+		k.name, _ = ev.Data["mechanism"].(string)
+		if k.name == "" {
+			k.name = "LOGIN"
+		}
+		m.logins[k]++
+	}
+}
+
+// AddDropped reports the events lost by the sinks of an output
+func (m *Metrics) AddDropped(output string, dropped func() map[string]int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped[output] = dropped
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	m.mu.Lock()
+	family(&b, "honey_events_total", "counter", "Events logged.", m.events, "event")
+	family(&b, "honey_sessions_total", "counter", "Sessions closed.", m.sessions, "reason")
+	family(&b, "honey_logins_total", "counter", "Login attempts.", m.logins, "mechanism")
+	dropped := map[key]int64{}
+	for output, f := range m.dropped {
+		for sink, n := range f() {
+			dropped[key{output, sink, ""}] = n
+		}
+	}
+	m.mu.Unlock()
+
+	fmt.Fprintf(&b, "# HELP honey_output_dropped_total Events dropped by an output.\n# TYPE honey_output_dropped_total counter\n")
+	for _, k := range sorted(dropped) {
+		fmt.Fprintf(&b, "honey_output_dropped_total{output=%q,sink=%q} %d\n", k.protocol, k.listener, dropped[k])
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+func family(b *strings.Builder, name string, typ string, help string, values map[key]int64, label string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, k := range sorted(values) {
+		fmt.Fprintf(b, "%s{protocol=%q,listener=%q", name, k.protocol, k.listener)
+		if label != "" {
+			fmt.Fprintf(b, ",%s=%q", label, k.name)
+		}
+		fmt.Fprintf(b, "} %d\n", values[k])
+	}
+}
+
+func sorted(values map[key]int64) []key {
+	keys := make([]key, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.protocol != b.protocol {
+			return a.protocol < b.protocol
+		}
+		if a.listener != b.listener {
+			return a.listener < b.listener
+		}
+		return a.name < b.name
+	})
+	return keys
+}
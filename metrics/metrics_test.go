@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"imap-honey/event"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	imap, smtp := m.Output("imaps"), m.Output("submission")
+	imap.Log(event.New(event.Login, "imap", nil))
+	imap.Log(event.New(event.Login, "imap", nil).Set("mechanism", "CRAM-MD5"))
+	imap.Log(event.New(event.Close, "imap", nil).Set("reason", event.ClientQuit))
+	smtp.Log(event.New(event.Login, "smtp", nil).Set("mechanism", "PLAIN"))
+	smtp.Log(event.New(event.Close, "smtp", nil).Set("reason", event.Timeout))
+	m.AddDropped("siem", func() map[string]int64 { return map[string]int64{"syslog-tls": 3} })
+
+	var b strings.Builder
+	m.WriteTo(&b)
+	for _, want := range []string{
+		"# TYPE honey_events_total counter\n",
+		`honey_events_total{protocol="imap",listener="imaps",event="login"} 2` + "\n",
+		`honey_events_total{protocol="smtp",listener="submission",event="session.close"} 1` + "\n",
+		`honey_sessions_total{protocol="imap",listener="imaps",reason="client quit"} 1` + "\n",
+		`honey_logins_total{protocol="imap",listener="imaps",mechanism="CRAM-MD5"} 1` + "\n",
+		`honey_logins_total{protocol="imap",listener="imaps",mechanism="LOGIN"} 1` + "\n",
+		`honey_logins_total{protocol="smtp",listener="submission",mechanism="PLAIN"} 1` + "\n",
+		`honey_output_dropped_total{output="siem",sink="syslog-tls"} 3` + "\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %q in\n%s", want, b.String())
+		}
+	}
+}
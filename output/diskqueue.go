@@ -0,0 +1,183 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"imap-honey/event"
+)
+
+// segments are closed for reading once they reach this size
+const segmentSize = 1 << 20
+
+// DiskQueue is a FIFO of events stored as JSON lines in numbered segment
+// files. Delivery is at least once: after a crash, a segment partly
+// replayed is replayed again.
+type DiskQueue struct {
+	dir string
+	max int64
+
+	mu       sync.Mutex
+	size     int64
+	segments []string // oldest first, the last one may be open for writing
+	cur      *os.File
+	curSize  int64
+	seq      int
+	head     []*event.Event
+}
+
+func OpenDiskQueue(dir string, max int64) (*DiskQueue, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	q := &DiskQueue{dir: dir, max: max}
+	names, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	sort.Strings(names)
+	for _, n := range names {
+		fi, err := os.Stat(n)
+		if err != nil {
+			continue
+		}
+		q.size += fi.Size()
+		q.segments = append(q.segments, n)
+		fmt.Sscanf(filepath.Base(n), "%d", &q.seq)
+	}
+	return q, nil
+}
+
+func (q *DiskQueue) Push(ev *event.Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.max > 0 && q.size+int64(len(b)) > q.max {
+		return fmt.Errorf("disk queue %s is full", q.dir)
+	}
+	if q.cur == nil || q.curSize >= segmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := q.cur.Write(b)
+	q.curSize += int64(n)
+	q.size += int64(n)
+	return err
+}
+
+func (q *DiskQueue) rotate() error {
+	if q.cur != nil {
+		q.cur.Close()
+		q.cur = nil
+	}
+	q.seq++
+	name := filepath.Join(q.dir, fmt.Sprintf("%020d.jsonl", q.seq))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	q.cur, q.curSize = f, 0
+	q.segments = append(q.segments, name)
+	return nil
+}
+
+func (q *DiskQueue) Empty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.head) == 0 && q.size == 0
+}
+
+// Peek returns the oldest event without removing it
+func (q *DiskQueue) Peek() (*event.Event, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.head) == 0 {
+		if len(q.segments) == 0 {
+			return nil, false
+		}
+		if q.cur != nil && q.cur.Name() == q.segments[0] {
+			if q.curSize == 0 {
+				return nil, false
+			}
+			q.cur.Close() // next Push opens a new segment
+			q.cur = nil
+		}
+		q.load(q.segments[0])
+	}
+	return q.head[0], true
+}
+
+// load reads a whole segment in memory and removes its file
+func (q *DiskQueue) load(name string) {
+	q.segments = q.segments[1:]
+	if fi, err := os.Stat(name); err == nil {
+		q.size -= fi.Size()
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), segmentSize*4)
+	for sc.Scan() {
+		ev := &event.Event{}
+		if json.Unmarshal(sc.Bytes(), ev) == nil { // skip truncated lines
+			q.head = append(q.head, ev)
+		}
+	}
+	f.Close()
+	os.Remove(name)
+	if len(q.head) > 0 {
+		q.spill() // keep events on disk until sent
+	}
+}
+
+// spill keeps the in-memory head on disk as segment 0 until it is sent
+func (q *DiskQueue) spill() {
+	name := filepath.Join(q.dir, fmt.Sprintf("%020d.jsonl", 0))
+	f, err := os.Create(name)
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(f)
+	for _, ev := range q.head {
+		b, _ := json.Marshal(ev)
+		w.Write(append(b, '\n'))
+	}
+	w.Flush()
+	f.Close()
+}
+
+// Pop removes the oldest event returned by Peek
+func (q *DiskQueue) Pop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.head) == 0 {
+		return
+	}
+	q.head = q.head[1:]
+	if len(q.head) == 0 {
+		os.Remove(filepath.Join(q.dir, fmt.Sprintf("%020d.jsonl", 0)))
+	}
+}
+
+// Close keeps unsent events of the head segment on disk
+func (q *DiskQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.head) > 0 {
+		q.spill()
+	}
+	if q.cur != nil {
+		return q.cur.Close()
+	}
+	return nil
+}
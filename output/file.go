@@ -0,0 +1,167 @@
+package output
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"imap-honey/event"
+)
+
+// File is a log file sink rotated by size and/or age. Rotated segments
+// are named path.YYYYMMDD-HHMMSS.mmm, gzipped when Compress is set, and only
+// the Keep most recent ones are kept. Reopen supports external logrotate.
+type File struct {
+	MaxSize  int64         // bytes, 0 for no size rotation
+	Interval time.Duration // 0 for no time rotation
+	Keep     int           // rotated segments kept, 0 keeps all
+	Compress bool
+
+	path   string
+	json   bool
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func NewFile(path string, json bool) (*File, error) {
+	f := &File{path: path, json: json, Compress: true}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) Name() string { return "file" }
+
+func (f *File) open() error {
+	w, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	fi, err := w.Stat()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	f.f, f.size, f.opened = w, fi.Size(), time.Now()
+	return nil
+}
+
+func (f *File) Send(ev *event.Event) error {
+	line := ev.Line(f.json)
+	if !f.json {
+		line = fmt.Sprintf("%s - %s", ev.Time.Local().Format(time.RFC3339), line)
+	}
+	line += "\n"
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if f.size > 0 && ((f.MaxSize > 0 && f.size+int64(len(line)) > f.MaxSize) ||
+		(f.Interval > 0 && time.Since(f.opened) >= f.Interval)) {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := io.WriteString(f.f, line)
+	f.size += int64(n)
+	return err
+}
+
+// Reopen closes and reopens the file, for SIGHUP after logrotate
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f != nil {
+		f.f.Close()
+		f.f = nil
+	}
+	return f.open()
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
+
+func (f *File) rotate() error {
+	f.f.Close()
+	f.f = nil
+	ts := time.Now().Format("20060102-150405.000")
+	name := f.path + "." + ts
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s.%s-%d", f.path, ts, i)
+	}
+	if err := os.Rename(f.path, name); err != nil {
+		return err
+	}
+	if f.Compress {
+		if err := compress(name); err != nil {
+			return err
+		}
+	}
+	f.prune()
+	return f.open()
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func compress(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// prune removes the oldest rotated segments beyond Keep
+func (f *File) prune() {
+	if f.Keep <= 0 {
+		return
+	}
+	old, _ := filepath.Glob(f.path + ".[0-9]*")
+	sort.Strings(old) // timestamp order
+	for len(old) > f.Keep {
+		os.Remove(old[0])
+		old = old[1:]
+	}
+}
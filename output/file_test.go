@@ -0,0 +1,70 @@
+package output
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "honey.log")
+	f, err := NewFile(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.MaxSize = 300
+	f.Keep = 2
+
+	for i := 0; i < 8; i++ {
+		if err := f.Send(userEvent("joe")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	old, _ := filepath.Glob(path + ".*")
+	if len(old) != 2 {
+		t.Fatalf("wait 2 rotated segments, receive %v", old)
+	}
+	for _, o := range old {
+		if !strings.HasSuffix(o, ".gz") {
+			t.Errorf("segment not compressed: %s", o)
+			continue
+		}
+		r, _ := os.Open(o)
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("bad gzip %s: %v", o, err)
+		}
+		b, _ := io.ReadAll(gz)
+		r.Close()
+		if !strings.Contains(string(b), `"username":"joe"`) {
+			t.Errorf("bad segment content %q", b)
+		}
+	}
+}
+
+func TestFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "honey.log")
+	f, _ := NewFile(path, false)
+	defer f.Close()
+	f.Send(userEvent("a"))
+
+	os.Rename(path, path+".1") // logrotate
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	ev := userEvent("b")
+	ev.Text = "LOGIN: b"
+	f.Send(ev)
+
+	b, _ := os.ReadFile(path)
+	if !strings.HasSuffix(string(b), " - LOGIN: b\n") || strings.Count(string(b), "\n") != 1 {
+		t.Errorf("reopened file: %q", b)
+	}
+}
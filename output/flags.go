@@ -0,0 +1,241 @@
+package output
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/syslog"
+	"os"
+	"strings"
+	"time"
+
+	"imap-honey/event"
+)
+
+// Flags are the output command line options shared by both honeypots,
+// also the outputs of a configuration file, keyed by flag name
+type Flags struct {
+	Format         string        `yaml:"log_format"`
+	File           string        `yaml:"log_file"`
+	FileMaxSize    int           `yaml:"log_max_size"`
+	FileRotate     time.Duration `yaml:"log_rotate"`
+	FileKeep       int           `yaml:"log_keep"`
+	FileCompress   bool          `yaml:"log_compress"`
+	Server         string        `yaml:"server"`
+	SyslogProto    string        `yaml:"syslog_proto"`
+	SyslogFacility string        `yaml:"syslog_facility"`
+	SyslogSeverity string        `yaml:"syslog_severity"`
+	SyslogCA       string        `yaml:"syslog_ca"`
+	QueueDir       string        `yaml:"queue_dir"`
+	Buffer         int           `yaml:"buffer"`
+	Retries        int           `yaml:"retries"`
+
+	Webhook          string        `yaml:"webhook"`
+	WebhookEvents    string        `yaml:"webhook_events"`
+	WebhookUsers     string        `yaml:"webhook_users"`
+	WebhookTemplate  string        `yaml:"webhook_template"`
+	WebhookHeaders   headers       `yaml:"webhook_headers"`
+	WebhookSecret    string        `yaml:"webhook_secret"`
+	WebhookBatch     int           `yaml:"webhook_batch"`
+	WebhookBatchWait time.Duration `yaml:"webhook_batch_wait"`
+	WebhookRate      float64       `yaml:"webhook_rate"`
+}
+
+// headers is a repeatable "Name: value" flag
+type headers []string
+
+func (h *headers) String() string { return strings.Join(*h, ", ") }
+func (h *headers) Set(s string) error {
+	if !strings.Contains(s, ":") {
+		return fmt.Errorf("bad header %q, want \"Name: value\"", s)
+	}
+	*h = append(*h, s)
+	return nil
+}
+
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	p := DefaultPolicy()
+	fs.StringVar(&f.Format, "log-format", "text", "log format: text or json")
+	fs.StringVar(&f.File, "log-file", "", "also append events to this file")
+	fs.IntVar(&f.FileMaxSize, "log-max-size", 0, "rotate the log file after this many MB")
+	fs.DurationVar(&f.FileRotate, "log-rotate", 0, "rotate the log file after this duration, as 24h")
+	fs.IntVar(&f.FileKeep, "log-keep", 7, "rotated log files kept, 0 keeps all")
+	fs.BoolVar(&f.FileCompress, "log-compress", true, "gzip rotated log files")
+	fs.StringVar(&f.Server, "server", "", "syslog remote server")
+	fs.StringVar(&f.SyslogProto, "syslog-proto", "", "RFC 5424 syslog over udp, tcp or tls (default legacy udp)")
+	fs.StringVar(&f.SyslogFacility, "syslog-facility", "daemon", "RFC 5424 syslog facility")
+	fs.StringVar(&f.SyslogSeverity, "syslog-severity", "", "RFC 5424 severity per event type, as login=alert,command=debug")
+	fs.StringVar(&f.SyslogCA, "syslog-ca", "", "CA file to verify the tls syslog server")
+	fs.StringVar(&f.QueueDir, "queue-dir", "", "disk queue directory for outputs that are down")
+	fs.IntVar(&f.Buffer, "buffer", p.BufferSize, "events buffered in memory per output")
+	fs.IntVar(&f.Retries, "retries", p.Retries, "retries before queuing or dropping an event")
+	fs.StringVar(&f.Webhook, "webhook", "", "POST events to this URL")
+	fs.StringVar(&f.WebhookEvents, "webhook-events", event.Login, "event types sent to the webhook, comma separated")
+	fs.StringVar(&f.WebhookUsers, "webhook-users", "", "only send events of these usernames (honeytokens), comma separated")
+	fs.StringVar(&f.WebhookTemplate, "webhook-template", "", "webhook body template file, default event JSON")
+	fs.Var(&f.WebhookHeaders, "webhook-header", "webhook request header \"Name: value\", repeatable")
+	fs.StringVar(&f.WebhookSecret, "webhook-secret", "", "HMAC-SHA256 key signing webhook requests")
+	fs.IntVar(&f.WebhookBatch, "webhook-batch", 1, "events per webhook request")
+	fs.DurationVar(&f.WebhookBatchWait, "webhook-batch-wait", 10*time.Second, "send a partial webhook batch after this delay")
+	fs.Float64Var(&f.WebhookRate, "webhook-rate", 1, "webhook requests per second, 0 for no limit")
+	return f
+}
+
+// DefaultFlags are the flag defaults
+func DefaultFlags() *Flags {
+	return RegisterFlags(flag.NewFlagSet("", flag.ContinueOnError))
+}
+
+func (f *Flags) JSON() bool { return f.Format == "json" }
+
+func (f *Flags) Policy() Policy {
+	p := DefaultPolicy()
+	p.BufferSize = f.Buffer
+	p.Retries = f.Retries
+	p.QueueDir = f.QueueDir
+	return p
+}
+
+// Build returns a Fanout with the file and syslog outputs selected by
+// the flags. Local syslog is used when no remote server is given.
+func (f *Flags) Build(appName string) (*Fanout, error) {
+	if err := f.Check(); err != nil {
+		return nil, err
+	}
+	fan := NewFanout()
+	p := f.Policy()
+
+	if f.File != "" {
+		file, err := NewFile(f.File, f.JSON())
+		if err != nil {
+			return nil, err
+		}
+		file.MaxSize = int64(f.FileMaxSize) << 20
+		file.Interval = f.FileRotate
+		file.Keep = f.FileKeep
+		file.Compress = f.FileCompress
+		if err := fan.Add(file, p); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Webhook != "" {
+		w, err := f.buildWebhook()
+		if err != nil {
+			return nil, err
+		}
+		if err := fan.Add(w, p); err != nil {
+			return nil, err
+		}
+	}
+
+	var sink Sink
+	switch {
+	case f.SyslogProto != "" && f.Server != "":
+		facility, err := ParseFacility(f.SyslogFacility)
+		if err != nil {
+			return nil, err
+		}
+		severities, err := ParseSeverities(f.SyslogSeverity)
+		if err != nil {
+			return nil, err
+		}
+		var tlsConfig *tls.Config
+		if f.SyslogProto == "tls" {
+			if tlsConfig, err = TLSConfig(f.SyslogCA); err != nil {
+				return nil, err
+			}
+		}
+		s := NewSyslog(f.SyslogProto, f.Server, tlsConfig, facility, appName)
+		s.Severities = severities
+		sink = s
+	case f.Server == "":
+		w, err := syslog.New(syslog.LOG_NOTICE, appName)
+		if err == nil {
+			sink = NewWriter("syslog", w, f.JSON(), false)
+		}
+	default:
+		w, err := syslog.Dial("udp", f.Server, syslog.LOG_NOTICE, appName)
+		if err != nil {
+			fmt.Printf("syslog.Dial() ERROR: %v\n", err)
+		} else {
+			sink = NewWriter("syslog", w, f.JSON(), false)
+		}
+	}
+	if sink != nil {
+		if err := fan.Add(sink, p); err != nil {
+			return nil, err
+		}
+	}
+	return fan, nil
+}
+
+// Check validates the options without opening any output
+func (f *Flags) Check() error {
+	if f.Format != "text" && f.Format != "json" {
+		return fmt.Errorf("unknown -log-format %q", f.Format)
+	}
+	switch f.SyslogProto {
+	case "", "udp", "tcp", "tls":
+	default:
+		return fmt.Errorf("unknown -syslog-proto %q", f.SyslogProto)
+	}
+	if _, err := ParseFacility(f.SyslogFacility); err != nil {
+		return err
+	}
+	if _, err := ParseSeverities(f.SyslogSeverity); err != nil {
+		return err
+	}
+	for _, h := range f.WebhookHeaders {
+		if err := new(headers).Set(h); err != nil {
+			return err
+		}
+	}
+	if f.WebhookTemplate != "" {
+		b, err := os.ReadFile(f.WebhookTemplate)
+		if err != nil {
+			return err
+		}
+		if _, err := ParseTemplate(f.WebhookTemplate, string(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Flags) buildWebhook() (*Webhook, error) {
+	w := NewWebhook(f.Webhook)
+	w.Types = set(f.WebhookEvents)
+	w.Users = set(f.WebhookUsers)
+	for _, h := range f.WebhookHeaders {
+		sp := strings.SplitN(h, ":", 2)
+		w.Headers.Add(strings.TrimSpace(sp[0]), strings.TrimSpace(sp[1]))
+	}
+	w.Secret = []byte(f.WebhookSecret)
+	w.BatchSize = f.WebhookBatch
+	w.BatchWait = f.WebhookBatchWait
+	w.Rate = f.WebhookRate
+	if f.WebhookTemplate != "" {
+		b, err := os.ReadFile(f.WebhookTemplate)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		if w.Template, err = ParseTemplate(f.WebhookTemplate, string(b)); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func set(list string) map[string]bool {
+	m := map[string]bool{}
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			m[s] = true
+		}
+	}
+	return m
+}
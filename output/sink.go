@@ -0,0 +1,282 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"imap-honey/event"
+)
+
+// Sink delivers events to one destination. Send is only called from the
+// sink worker; an error triggers retries, then the disk queue if any,
+// except a *PermanentError dropping the event.
+type Sink interface {
+	Name() string
+	Send(ev *event.Event) error
+	Close() error
+}
+
+// PermanentError is a Send error that retrying cannot fix, such as a
+// rejected request
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+func permanent(err error) bool {
+	var pe *PermanentError
+	return errors.As(err, &pe)
+}
+
+// Reopener is a sink that reopens its files on SIGHUP
+type Reopener interface {
+	Reopen() error
+}
+
+// Policy sets buffering and retries of one sink
+type Policy struct {
+	BufferSize int           // events kept in memory
+	Retries    int           // retries before spooling or dropping an event
+	MinBackoff time.Duration // first retry delay, doubled up to MaxBackoff
+	MaxBackoff time.Duration
+	QueueDir   string // optional disk queue used while the sink is down
+	QueueSize  int64  // disk queue limit in bytes
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		BufferSize: 1000,
+		Retries:    3,
+		MinBackoff: 500 * time.Millisecond,
+		MaxBackoff: 30 * time.Second,
+		QueueSize:  64 << 20,
+	}
+}
+
+// Fanout sends every event to all its sinks, each one with its own
+// buffer, retry policy and disk queue.
+type Fanout struct {
+	mu      sync.Mutex
+	workers []*worker
+}
+
+func NewFanout() *Fanout {
+	return &Fanout{}
+}
+
+func (f *Fanout) Add(sink Sink, p Policy) error {
+	w := &worker{
+		sink:   sink,
+		policy: p,
+		ch:     make(chan *event.Event, p.BufferSize),
+		done:   make(chan struct{}),
+		quit:   make(chan struct{}),
+	}
+	if p.QueueDir != "" {
+		q, err := OpenDiskQueue(filepath.Join(p.QueueDir, sink.Name()), p.QueueSize)
+		if err != nil {
+			return err
+		}
+		w.disk = q
+	}
+	f.mu.Lock()
+	f.workers = append(f.workers, w)
+	f.mu.Unlock()
+	go w.run()
+	return nil
+}
+
+// Log queues ev for every sink without blocking
+func (f *Fanout) Log(ev *event.Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, w := range f.workers {
+		w.push(ev)
+	}
+}
+
+// Dropped returns the number of events lost per sink
+func (f *Fanout) Dropped() map[string]int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := map[string]int64{}
+	for _, w := range f.workers {
+		m[w.sink.Name()] = atomic.LoadInt64(&w.dropped)
+	}
+	return m
+}
+
+// Reopen reopens the files of every sink supporting it
+func (f *Fanout) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var first error
+	for _, w := range f.workers {
+		if r, ok := w.sink.(Reopener); ok {
+			if err := r.Reopen(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Close flushes the buffers, giving up after timeout, and closes the sinks
+func (f *Fanout) Close(timeout time.Duration) {
+	f.mu.Lock()
+	workers := f.workers
+	f.workers = nil
+	f.mu.Unlock()
+
+	deadline := time.After(timeout)
+	for _, w := range workers {
+		close(w.ch)
+	}
+	for _, w := range workers {
+		select {
+		case <-w.done:
+		case <-deadline:
+			close(w.quit)
+			<-w.done
+		}
+		w.sink.Close()
+		if w.disk != nil {
+			w.disk.Close()
+		}
+	}
+}
+
+type worker struct {
+	dropped int64 // first for 64-bit atomic alignment on 386
+	sink    Sink
+	policy  Policy
+	ch      chan *event.Event
+	disk    *DiskQueue
+	done    chan struct{}
+	quit    chan struct{}
+}
+
+func (w *worker) push(ev *event.Event) {
+	select {
+	case w.ch <- ev:
+	default:
+		w.spill(ev) // buffer full
+	}
+}
+
+func (w *worker) spill(ev *event.Event) {
+	if w.disk == nil || w.disk.Push(ev) != nil {
+		atomic.AddInt64(&w.dropped, 1)
+	}
+}
+
+func (w *worker) run() {
+	defer close(w.done)
+	tick := time.NewTicker(w.policy.MaxBackoff)
+	defer tick.Stop()
+	for {
+		select {
+		case ev, ok := <-w.ch:
+			if !ok {
+				w.replay()
+				return
+			}
+			if w.disk != nil && !w.disk.Empty() { // keep order behind spooled events
+				w.spill(ev)
+				w.replay()
+				continue
+			}
+			if !w.send(ev) {
+				w.spill(ev)
+			}
+		case <-tick.C:
+			w.replay()
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// send tries ev with retries and backoff, false when ev is to be spooled
+func (w *worker) send(ev *event.Event) bool {
+	backoff := w.policy.MinBackoff
+	for i := 0; ; i++ {
+		err := w.sink.Send(ev)
+		if err == nil {
+			return true
+		}
+		if permanent(err) {
+			atomic.AddInt64(&w.dropped, 1)
+			return true
+		}
+		if i >= w.policy.Retries {
+			return false
+		}
+		select {
+		case <-time.After(backoff):
+		case <-w.quit:
+			return false
+		}
+		if backoff *= 2; backoff > w.policy.MaxBackoff {
+			backoff = w.policy.MaxBackoff
+		}
+	}
+}
+
+// replay sends spooled events in order until the sink fails again
+func (w *worker) replay() {
+	if w.disk == nil {
+		return
+	}
+	for {
+		ev, ok := w.disk.Peek()
+		if !ok {
+			return
+		}
+		if err := w.sink.Send(ev); err != nil {
+			if !permanent(err) {
+				return
+			}
+			atomic.AddInt64(&w.dropped, 1)
+		}
+		w.disk.Pop()
+	}
+}
+
+// Writer is a sink writing one line per event, such as the console, a
+// plain file or a log/syslog writer.
+type Writer struct {
+	name      string
+	w         io.Writer
+	json      bool
+	timestamp bool
+}
+
+func NewWriter(name string, w io.Writer, json bool, timestamp bool) *Writer {
+	return &Writer{name, w, json, timestamp}
+}
+
+func (w *Writer) Name() string { return w.name }
+
+func (w *Writer) Send(ev *event.Event) error {
+	line := ev.Line(w.json)
+	if w.timestamp && !w.json {
+		line = fmt.Sprintf("%s - %s", ev.Time.Local().Format(time.RFC3339), line)
+	}
+	_, err := io.WriteString(w.w, line+"\n")
+	return err
+}
+
+func (w *Writer) Close() error {
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
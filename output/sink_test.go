@@ -0,0 +1,147 @@
+package output
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"imap-honey/event"
+)
+
+// flaky fails while down is set, and for good with reject
+type flaky struct {
+	mu     sync.Mutex
+	down   bool
+	reject bool
+	calls  int
+	got    []string
+}
+
+func (f *flaky) Name() string { return "flaky" }
+func (f *flaky) Close() error { return nil }
+func (f *flaky) Send(ev *event.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.reject {
+		return &PermanentError{errors.New("rejected")}
+	}
+	if f.down {
+		return errors.New("down")
+	}
+	f.got = append(f.got, ev.Username)
+	return nil
+}
+func (f *flaky) set(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+func (f *flaky) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.got...)
+}
+
+func userEvent(u string) *event.Event {
+	ev := event.New(event.Login, "smtp", nil)
+	ev.Username = u
+	return ev
+}
+
+func fastPolicy() Policy {
+	p := DefaultPolicy()
+	p.Retries = 1
+	p.MinBackoff = time.Millisecond
+	p.MaxBackoff = 20 * time.Millisecond
+	return p
+}
+
+func TestFanoutDiskQueue(t *testing.T) {
+	sink := &flaky{down: true}
+	p := fastPolicy()
+	p.QueueDir = t.TempDir()
+	f := NewFanout()
+	if err := f.Add(sink, p); err != nil {
+		t.Fatal(err)
+	}
+	other := &flaky{}
+	f.Add(other, fastPolicy())
+
+	for _, u := range []string{"a", "b", "c"} {
+		f.Log(userEvent(u))
+	}
+	time.Sleep(100 * time.Millisecond)
+	sink.set(false)
+	f.Log(userEvent("d"))
+	time.Sleep(100 * time.Millisecond)
+	f.Close(time.Second)
+
+	if got := sink.received(); len(got) != 4 || got[0] != "a" || got[3] != "d" {
+		t.Errorf("events lost or out of order: %v", got)
+	}
+	if got := other.received(); len(got) != 4 {
+		t.Errorf("healthy sink missed events: %v", got)
+	}
+}
+
+func TestFanoutDropped(t *testing.T) {
+	sink := &flaky{down: true}
+	p := fastPolicy()
+	p.BufferSize = 1
+	f := NewFanout()
+	f.Add(sink, p)
+	for i := 0; i < 10; i++ {
+		f.Log(userEvent("x"))
+	}
+	time.Sleep(50 * time.Millisecond)
+	if d := f.Dropped()["flaky"]; d < 8 {
+		t.Errorf("wait dropped events without disk queue, receive %d", d)
+	}
+	f.Close(100 * time.Millisecond)
+}
+
+func TestFanoutPermanent(t *testing.T) {
+	sink := &flaky{reject: true}
+	p := fastPolicy()
+	p.QueueDir = t.TempDir()
+	f := NewFanout()
+	f.Add(sink, p)
+	for _, u := range []string{"a", "b", "c"} {
+		f.Log(userEvent(u))
+	}
+	time.Sleep(50 * time.Millisecond)
+	sink.mu.Lock()
+	calls := sink.calls
+	sink.mu.Unlock()
+	if d := f.Dropped()["flaky"]; d != 3 || calls != 3 {
+		t.Errorf("wait 3 events dropped without retries, receive %d dropped in %d calls", d, calls)
+	}
+	if !f.workers[0].disk.Empty() {
+		t.Errorf("rejected events spooled")
+	}
+	f.Close(100 * time.Millisecond)
+}
+
+func TestDiskQueueReopen(t *testing.T) {
+	dir := t.TempDir()
+	q, _ := OpenDiskQueue(dir, 0)
+	q.Push(userEvent("a"))
+	q.Push(userEvent("b"))
+	if ev, ok := q.Peek(); !ok || ev.Username != "a" {
+		t.Fatalf("bad peek %v", ev)
+	}
+	q.Pop()
+	q.Close()
+
+	q, _ = OpenDiskQueue(dir, 0)
+	defer q.Close()
+	if ev, ok := q.Peek(); !ok || ev.Username != "b" {
+		t.Fatalf("event not kept across reopen: %v", ev)
+	}
+	q.Pop()
+	if !q.Empty() {
+		t.Errorf("queue not empty")
+	}
+}
@@ -0,0 +1,239 @@
+package output
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"imap-honey/event"
+)
+
+// Facility and severity values of RFC 5424
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var severities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3,
+	"warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+func ParseFacility(s string) (int, error) {
+	f, ok := facilities[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", s)
+	}
+	return f, nil
+}
+
+// ParseSeverities reads "type=severity,..." such as "login=warning,command=debug"
+func ParseSeverities(s string) (map[string]int, error) {
+	m := map[string]int{}
+	for _, kv := range strings.Split(s, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		sp := strings.SplitN(kv, "=", 2)
+		if len(sp) != 2 {
+			return nil, fmt.Errorf("bad severity %q, want type=severity", kv)
+		}
+		sev, ok := severities[strings.ToLower(strings.TrimSpace(sp[1]))]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog severity %q", sp[1])
+		}
+		m[strings.TrimSpace(sp[0])] = sev
+	}
+	return m, nil
+}
+
+// default severities, others are notice
+var defaultSeverities = map[string]int{
+	event.Login:   4, // warning
+	event.Command: 7, // debug
+	event.Open:    6, // info
+	event.Close:   6,
+	event.Error:   3,
+}
+
+// Syslog sends RFC 5424 messages over udp, tcp or tls (RFC 5425 octet
+// counting framing). The connection is opened on first use and reopened
+// after a write error; buffering and retries are left to the Fanout.
+type Syslog struct {
+	Network    string // udp, tcp or tls
+	Addr       string
+	TLSConfig  *tls.Config
+	Facility   int
+	Severities map[string]int // per event type, on top of the defaults
+	AppName    string
+	Hostname   string
+
+	conn net.Conn
+}
+
+func NewSyslog(network string, addr string, tlsConfig *tls.Config, facility int, appName string) *Syslog {
+	hostname, _ := os.Hostname()
+	return &Syslog{
+		Network:    network,
+		Addr:       addr,
+		TLSConfig:  tlsConfig,
+		Facility:   facility,
+		Severities: map[string]int{},
+		AppName:    appName,
+		Hostname:   hostname,
+	}
+}
+
+func (s *Syslog) Name() string { return "syslog-" + s.Network }
+
+func (s *Syslog) severity(typ string) int {
+	if sev, ok := s.Severities[typ]; ok {
+		return sev
+	}
+	if sev, ok := defaultSeverities[typ]; ok {
+		return sev
+	}
+	return 5 // notice
+}
+
+// Format returns the RFC 5424 message for ev
+func (s *Syslog) Format(ev *event.Event) []byte {
+	pri := s.Facility*8 + s.severity(ev.Type)
+	host := nilValue(ev.Sensor)
+	if ev.Sensor == "" {
+		host = nilValue(s.Hostname)
+	}
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		pri, ev.Time.UTC().Format("2006-01-02T15:04:05.000000Z"), header(host, 255),
+		header(nilValue(s.AppName), 48), os.Getpid(), header(nilValue(ev.Type), 32),
+		structuredData(ev), ev.Text))
+}
+
+func nilValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// header keeps printable US-ASCII without spaces, as required for header fields
+func header(s string, max int) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	return string(b)
+}
+
+// structuredData carries event fields in the honey@32473 SD element
+// (32473 is the documentation enterprise number of RFC 5612)
+func structuredData(ev *event.Event) string {
+	params := [][2]string{
+		{"event", ev.Type}, {"session_id", ev.Session}, {"protocol", ev.Protocol},
+		{"src_ip", ev.SrcIP}, {"dst_ip", ev.DstIP},
+		{"username", ev.Username}, {"password", ev.Password}, {"command", ev.Command},
+	}
+	if ev.SrcPort != 0 {
+		params = append(params, [2]string{"src_port", strconv.Itoa(ev.SrcPort)})
+	}
+	if ev.DstPort != 0 {
+		params = append(params, [2]string{"dst_port", strconv.Itoa(ev.DstPort)})
+	}
+	if ev.TLS != nil {
+		params = append(params, [2]string{"tls_version", ev.TLS.Version}, [2]string{"tls_cipher", ev.TLS.CipherSuite})
+	}
+	keys := make([]string, 0, len(ev.Data))
+	for k := range ev.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		params = append(params, [2]string{k, fmt.Sprint(ev.Data[k])})
+	}
+
+	var b strings.Builder
+	b.WriteString("[honey@32473")
+	for _, p := range params {
+		if p[1] == "" {
+			continue
+		}
+		fmt.Fprintf(&b, " %s=\"%s\"", header(p[0], 32), sdEscape.Replace(p[1]))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+var sdEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// TLSConfig verifies the syslog server against caFile, or the system roots
+func TLSConfig(caFile string) (*tls.Config, error) {
+	if caFile == "" {
+		return &tls.Config{}, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+func (s *Syslog) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	switch s.Network {
+	case "tls":
+		return tls.DialWithDialer(d, "tcp", s.Addr, s.TLSConfig)
+	case "tcp", "udp":
+		return d.Dial(s.Network, s.Addr)
+	}
+	return nil, fmt.Errorf("unknown syslog network %q", s.Network)
+}
+
+func (s *Syslog) frame(msg []byte) []byte {
+	if s.Network == "udp" {
+		return msg
+	}
+	return append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+}
+
+func (s *Syslog) Send(ev *event.Event) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := s.conn.Write(s.frame(s.Format(ev))); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *Syslog) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
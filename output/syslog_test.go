@@ -0,0 +1,135 @@
+package output
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"imap-honey/event"
+)
+
+// readFrame reads one octet counted message
+func readFrame(t *testing.T, r *bufio.Reader) string {
+	n, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatalf("read frame length: %v", err)
+	}
+	l, _ := strconv.Atoi(strings.TrimSpace(n))
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	return string(b)
+}
+
+func loginEvent(user string) *event.Event {
+	ev := event.New(event.Login, "imap", nil)
+	ev.Session, ev.SrcIP, ev.Username, ev.Password = "01G0EZ1XTM37C5X11SQTDNCTM1", "192.0.2.1", user, `p"a]ss`
+	ev.Text = "IP: 192.0.2.1, LOGIN: " + user
+	return ev
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	s := NewSyslog("tcp", addr, nil, 16, "imaphoney")
+	defer s.Close()
+	if err := s.Send(loginEvent("joe")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg := readFrame(t, bufio.NewReader(conn))
+	if !strings.HasPrefix(msg, "<132>1 ") { // local0.warning
+		t.Errorf("bad PRI: %q", msg)
+	}
+	if !strings.Contains(msg, ` imaphoney `) || !strings.Contains(msg, ` login [honey@32473 event="login"`) {
+		t.Errorf("bad header: %q", msg)
+	}
+	if !strings.Contains(msg, `password="p\"a\]ss"`) || !strings.HasSuffix(msg, "] IP: 192.0.2.1, LOGIN: joe") {
+		t.Errorf("bad structured data: %q", msg)
+	}
+
+	// collector restart: a write error reopens the connection
+	conn.Close()
+	ln.Close()
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("cannot listen again on %s: %v", addr, err)
+	}
+	defer ln.Close()
+	go func() {
+		for i := 0; i < 20; i++ {
+			s.Send(loginEvent("jane"))
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	conn, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if msg := readFrame(t, bufio.NewReader(conn)); !strings.Contains(msg, "LOGIN: jane") {
+		t.Errorf("bad message after reconnection: %q", msg)
+	}
+}
+
+func TestSyslogTLS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour),
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	pool := x509.NewCertPool()
+	c, _ := x509.ParseCertificate(der)
+	pool.AddCert(c)
+	s := NewSyslog("tls", ln.Addr().String(), &tls.Config{RootCAs: pool}, 3, "smtphoney")
+	s.Severities[event.Login] = 1
+	defer s.Close()
+	go s.Send(loginEvent("joe"))
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if msg := readFrame(t, bufio.NewReader(conn)); !strings.HasPrefix(msg, "<25>1 ") { // daemon.alert
+		t.Errorf("bad TLS message: %q", msg)
+	}
+}
+
+func TestParseSeverities(t *testing.T) {
+	m, err := ParseSeverities("login=alert, command=debug")
+	if err != nil || m["login"] != 1 || m["command"] != 7 {
+		t.Errorf("bad severities %v %v", m, err)
+	}
+	if _, err := ParseSeverities("login=loud"); err == nil {
+		t.Errorf("unknown severity accepted")
+	}
+}
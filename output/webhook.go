@@ -0,0 +1,200 @@
+package output
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"imap-honey/event"
+)
+
+// Webhook POSTs selected events to an HTTP endpoint, one by one or in
+// batches. The body is the event JSON (an array for batches) or the
+// result of Template, executed with .Event (first event) and .Events.
+// With a Secret, X-Honey-Signature carries
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)) and
+// X-Honey-Timestamp the unix timestamp.
+type Webhook struct {
+	URL       string
+	Types     map[string]bool // event types sent, all when empty
+	Users     map[string]bool // only these usernames when not empty
+	Template  *template.Template
+	Headers   http.Header
+	Secret    []byte
+	BatchSize int           // events per request
+	BatchWait time.Duration // flush a partial batch after this delay
+	Rate      float64       // requests per second, 0 for no limit
+	Client    *http.Client
+
+	mu      sync.Mutex
+	batch   []*event.Event
+	first   time.Time
+	last    time.Time // last request, for the rate limit
+	done    chan struct{}
+	flushed chan struct{}
+}
+
+func NewWebhook(url string) *Webhook {
+	w := &Webhook{
+		URL:       url,
+		Types:     map[string]bool{event.Login: true},
+		Headers:   http.Header{},
+		BatchSize: 1,
+		BatchWait: 10 * time.Second,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		done:      make(chan struct{}),
+		flushed:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// ParseTemplate reads a body template, with a json function
+func ParseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+func (w *Webhook) Name() string { return "webhook" }
+
+func (w *Webhook) selected(ev *event.Event) bool {
+	if len(w.Types) > 0 && !w.Types[ev.Type] {
+		return false
+	}
+	return len(w.Users) == 0 || w.Users[ev.Username]
+}
+
+func (w *Webhook) Send(ev *event.Event) error {
+	if !w.selected(ev) {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.batch) == 0 {
+		w.first = time.Now()
+	}
+	w.batch = append(w.batch, ev)
+	if len(w.batch) < w.BatchSize {
+		return nil
+	}
+	if err := w.flush(); err != nil {
+		if len(w.batch) > 0 { // ev is retried by the caller, unless dropped
+			w.batch = w.batch[:len(w.batch)-1]
+		}
+		return err
+	}
+	return nil
+}
+
+// run flushes partial batches after BatchWait
+func (w *Webhook) run() {
+	defer close(w.flushed)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			w.mu.Lock()
+			if len(w.batch) > 0 && time.Since(w.first) >= w.BatchWait {
+				w.flush() // kept for the next tick on a transient error
+			}
+			w.mu.Unlock()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// flush POSTs the batch. It is kept on network errors and 5xx, 408 or
+// 429 statuses, and dropped with a *PermanentError when the body cannot
+// be built or the endpoint rejects it.
+func (w *Webhook) flush() error {
+	if w.Rate > 0 {
+		if wait := time.Duration(float64(time.Second)/w.Rate) - time.Since(w.last); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	w.last = time.Now()
+
+	body, err := w.body(w.batch)
+	if err != nil {
+		w.batch = nil
+		return &PermanentError{fmt.Errorf("webhook %s: %v", w.URL, err)}
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		w.batch = nil
+		return &PermanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header[k] = v
+	}
+	if len(w.Secret) > 0 {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Honey-Timestamp", ts)
+		req.Header.Set("X-Honey-Signature", "sha256="+Sign(w.Secret, ts, body))
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+	case resp.StatusCode/100 == 5, resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook %s: %s", w.URL, resp.Status)
+	default:
+		w.batch = nil
+		return &PermanentError{fmt.Errorf("webhook %s: %s", w.URL, resp.Status)}
+	}
+	w.batch = nil
+	return nil
+}
+
+func (w *Webhook) body(events []*event.Event) ([]byte, error) {
+	if w.Template != nil {
+		var buf bytes.Buffer
+		err := w.Template.Execute(&buf, map[string]interface{}{"Event": events[0], "Events": events})
+		return buf.Bytes(), err
+	}
+	if w.BatchSize > 1 {
+		return json.Marshal(events)
+	}
+	return json.Marshal(events[0])
+}
+
+// Sign returns the hex HMAC-SHA256 of timestamp + "." + body
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Close sends the pending batch
+func (w *Webhook) Close() error {
+	close(w.done)
+	<-w.flushed
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.batch) == 0 {
+		return nil
+	}
+	return w.flush()
+}
@@ -0,0 +1,152 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"imap-honey/event"
+)
+
+type hook struct {
+	mu     sync.Mutex
+	bodies []string
+	fail   bool
+}
+
+func (h *hook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, _ := io.ReadAll(r.Body)
+	if h.fail {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Header.Get("X-Team") != "oncall" {
+		http.Error(w, "missing header", http.StatusBadRequest)
+		return
+	}
+	ts := r.Header.Get("X-Honey-Timestamp")
+	if r.Header.Get("X-Honey-Signature") != "sha256="+Sign([]byte("s3cret"), ts, b) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	h.bodies = append(h.bodies, string(b))
+}
+
+func (h *hook) received() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string{}, h.bodies...)
+}
+
+func newTestWebhook(url string) *Webhook {
+	w := NewWebhook(url)
+	w.Headers.Set("X-Team", "oncall")
+	w.Secret = []byte("s3cret")
+	return w
+}
+
+func TestWebhook(t *testing.T) {
+	h := &hook{}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	w := newTestWebhook(srv.URL)
+	w.Users = map[string]bool{"honeytoken": true}
+	w.Template, _ = ParseTemplate("body", `{"text":"{{.Event.Username}} from {{.Event.SrcIP}}","event":{{json .Event.Type}}}`)
+	defer w.Close()
+
+	ev := userEvent("honeytoken")
+	ev.SrcIP = "192.0.2.1"
+	for _, e := range []*event.Event{userEvent("joe"), event.New(event.Command, "imap", nil), ev} {
+		if err := w.Send(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := h.received()
+	if len(got) != 1 || got[0] != `{"text":"honeytoken from 192.0.2.1","event":"login"}` {
+		t.Errorf("bad webhook bodies %q", got)
+	}
+
+	h.mu.Lock()
+	h.fail = true
+	h.mu.Unlock()
+	if err := w.Send(ev); err == nil {
+		t.Errorf("failed POST not reported")
+	}
+}
+
+func TestWebhookBatch(t *testing.T) {
+	h := &hook{}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	w := newTestWebhook(srv.URL)
+	w.BatchSize = 3
+	w.BatchWait = 10 * time.Millisecond
+	w.Rate = 100
+	for _, u := range []string{"a", "b", "c", "d"} {
+		w.Send(userEvent(u))
+	}
+	got := h.received()
+	if len(got) != 1 {
+		t.Fatalf("wait one full batch, receive %q", got)
+	}
+	var batch []event.Event
+	if err := json.Unmarshal([]byte(got[0]), &batch); err != nil || len(batch) != 3 {
+		t.Errorf("bad batch %q: %v", got[0], err)
+	}
+
+	time.Sleep(1500 * time.Millisecond) // partial batch flushed by the ticker
+	if got := h.received(); len(got) != 2 {
+		t.Errorf("partial batch not flushed: %q", got)
+	}
+	w.Close()
+}
+
+func TestWebhookErrors(t *testing.T) {
+	h := &hook{}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	w := NewWebhook(srv.URL) // no X-Team header, answered 400
+	w.BatchSize = 2
+	defer w.Close()
+	pending := func() int {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return len(w.batch)
+	}
+
+	w.Send(userEvent("a"))
+	if err := w.Send(userEvent("b")); !permanent(err) {
+		t.Errorf("400: wait a permanent error, receive %v", err)
+	}
+	if n := pending(); n != 0 {
+		t.Errorf("400: rejected batch kept with %d events", n)
+	}
+
+	h.mu.Lock()
+	h.fail = true
+	h.mu.Unlock()
+	w.Send(userEvent("a"))
+	if err := w.Send(userEvent("b")); err == nil || permanent(err) {
+		t.Errorf("503: wait a transient error, receive %v", err)
+	}
+	if n := pending(); n != 1 {
+		t.Errorf("503: wait the batch kept without the retried event, receive %d events", n)
+	}
+
+	w.Template, _ = ParseTemplate("body", `{{.Event.Nope}}`)
+	if err := w.Send(userEvent("c")); !permanent(err) {
+		t.Errorf("template: wait a permanent error, receive %v", err)
+	}
+	if n := pending(); n != 0 {
+		t.Errorf("template: batch kept with %d events", n)
+	}
+}
@@ -0,0 +1,239 @@
+package persona
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// file is the YAML form of a persona, JSON being a subset of it. Unset
+// fields come from the extended persona, the protocol default otherwise.
+//
+//	name: acme
+//	protocol: smtp
+//	extends: postfix
+//	greeting_delay: 500ms
+//	responses:
+//	  greeting: 220 {{.Hostname}} ESMTP Acme mail gateway
+type file struct {
+	Name          string            `yaml:"name"`
+	Protocol      string            `yaml:"protocol"`
+	Extends       string            `yaml:"extends"`
+	Product       string            `yaml:"product"`
+	Capability    *string           `yaml:"capability"`
+	GreetingDelay string            `yaml:"greeting_delay"`
+	LoginDelay    string            `yaml:"login_delay"`
+	FailDelay     string            `yaml:"fail_delay"`
+	MaxErrors     *int              `yaml:"max_errors"`
+	Responses     map[string]string `yaml:"responses"`
+}
+
+// IsFile tells a persona file name from a persona name
+func IsFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// Find returns the persona name, or the one defined in the file name
+func Find(protocol string, name string) (*Persona, error) {
+	if !IsFile(name) {
+		return Get(protocol, name)
+	}
+	p, err := Load(name)
+	if err != nil {
+		return nil, err
+	}
+	if p.Protocol != protocol {
+		return nil, fmt.Errorf("%s: %s persona, not %s", name, p.Protocol, protocol)
+	}
+	return p, nil
+}
+
+// Load reads a persona from a YAML or JSON file. Errors carry the file
+// name and line.
+func Load(path string) (*Persona, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", path, strings.ReplaceAll(err.Error(), "\n", "\n"+path+":"))
+	}
+	return p, nil
+}
+
+// Decode reads a persona in YAML or JSON, errors start with a line number
+func Decode(r io.Reader) (*Persona, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, yamlError(err)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%d: persona is not a mapping", root.Line)
+	}
+	doc := root.Content[0]
+	var f file
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return nil, yamlError(err)
+	}
+	errorf := func(key string, format string, a ...interface{}) error {
+		return fmt.Errorf("%d: %s", line(doc, key), fmt.Sprintf(format, a...))
+	}
+
+	if f.Name == "" {
+		return nil, errorf("name", "name is missing")
+	}
+	base := Default(f.Protocol)
+	if base == nil {
+		return nil, errorf("protocol", "protocol %q is not imap or smtp", f.Protocol)
+	}
+	if f.Extends != "" {
+		if base, err = Get(f.Protocol, f.Extends); err != nil {
+			return nil, errorf("extends", "%v", err)
+		}
+	}
+
+	p := *base
+	p.Name = f.Name
+	p.Product = f.Product
+	if p.Product == "" {
+		p.Product = f.Name
+	}
+	if f.Capability != nil {
+		p.Capability = *f.Capability
+		if err := Check(p.Capability); err != nil {
+			return nil, errorf("capability", "capability: %v", err)
+		}
+	}
+	for _, d := range []struct {
+		key   string
+		value string
+		to    *time.Duration
+	}{
+		{"greeting_delay", f.GreetingDelay, &p.GreetingDelay},
+		{"login_delay", f.LoginDelay, &p.LoginDelay},
+		{"fail_delay", f.FailDelay, &p.FailDelay},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v < 0 {
+			return nil, errorf(d.key, "%s: invalid duration %q", d.key, d.value)
+		}
+		*d.to = v
+	}
+	if f.MaxErrors != nil {
+		if *f.MaxErrors < 0 {
+			return nil, errorf("max_errors", "max_errors: %d is negative", *f.MaxErrors)
+		}
+		p.MaxErrors = *f.MaxErrors
+	}
+
+	p.Responses = map[string]string{}
+	for id, text := range base.Responses {
+		p.Responses[id] = text
+	}
+	responses := value(doc, "responses")
+	for _, id := range sortedKeys(f.Responses) {
+		if _, ok := Default(f.Protocol).Responses[id]; !ok {
+			return nil, fmt.Errorf("%d: unknown %s response %q, one of %s", line(responses, id), f.Protocol, id, strings.Join(IDs(f.Protocol), ", "))
+		}
+		if err := Check(f.Responses[id]); err != nil {
+			return nil, fmt.Errorf("%d: response %s: %v", line(responses, id), id, err)
+		}
+		p.Responses[id] = f.Responses[id]
+	}
+	return &p, nil
+}
+
+// IDs lists the response ids of a protocol
+func IDs(protocol string) []string {
+	var ids []string
+	if d := Default(protocol); d != nil {
+		ids = sortedKeys(d.Responses)
+	}
+	return ids
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Check parses a template and renders it once, so that unknown variables
+// are caught at load time rather than in front of a client
+func Check(text string) error {
+	t, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	return t.Execute(io.Discard, &Vars{Date: time.Now()})
+}
+
+// value is the node of key in a YAML mapping, nil if there is none
+func value(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// line is the line of key in a YAML mapping, or of the mapping itself
+func line(mapping *yaml.Node, key string) int {
+	if mapping == nil {
+		return 1
+	}
+	if mapping.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			if mapping.Content[i].Value == key {
+				return mapping.Content[i].Line
+			}
+		}
+	}
+	return mapping.Line
+}
+
+// yamlError turns "yaml: line 3: ..." messages into "3: ..." lines
+func yamlError(err error) error {
+	var msgs []string
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = te.Errors
+	} else {
+		msgs = []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+	for i, m := range msgs {
+		if strings.HasPrefix(m, "line ") {
+			msgs[i] = strings.TrimPrefix(m, "line ")
+		} else {
+			msgs[i] = "1: " + m
+		}
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "\n"))
+}
@@ -0,0 +1,137 @@
+package persona
+
+import "time"
+
+// IMAP response ids
+//
+//	greeting, capability, noop, id, logout, bad_command, syntax_error,
+//	starttls, starttls_active, starttls_unavailable, already_authenticated,
+//	auth_mechanism_missing, auth_unsupported, auth_cancelled,
+//	auth_malformed, privacy_required, login_ok, login_failed,
+//	too_many_errors
+var imapDefault = &Persona{
+	Name:       "imaphoney",
+	Protocol:   "imap",
+	Product:    "the original imaphoney replies",
+	Capability: "IMAP4rev1 AUTH=PLAIN",
+	Responses: map[string]string{
+		"greeting":               "* OK IMAP4rev1 Service Ready",
+		"capability":             "* CAPABILITY {{.Capability}}\n{{.Tag}} OK CAPABILITY",
+		"noop":                   "{{.Tag}} OK",
+		"id":                     "* ID NIL\n{{.Tag}} OK ID completed",
+		"logout":                 "* BYE {{.Hostname}}\n{{.Tag}} OK LOGOUT",
+		"bad_command":            "{{.Tag}} BAD invalid command",
+		"syntax_error":           "{{.Tag}} BAD {{.Error}}",
+		"starttls":               "{{.Tag}} OK Begin TLS negotiation now",
+		"starttls_active":        "{{.Tag}} BAD STARTTLS not available",
+		"starttls_unavailable":   "{{.Tag}} BAD STARTTLS not available",
+		"already_authenticated":  "{{.Tag}} BAD already authenticated",
+		"auth_mechanism_missing": "{{.Tag}} BAD AUTHENTICATE needs a mechanism",
+		"auth_unsupported":       "{{.Tag}} NO unsupported authentication mechanism",
+		"auth_cancelled":         "{{.Tag}} BAD AUTHENTICATE cancelled",
+		"auth_malformed":         "{{.Tag}} BAD invalid SASL response",
+		"privacy_required":       "{{.Tag}} NO [PRIVACYREQUIRED] Plaintext authentication disallowed on non-secure (SSL/TLS) connections.",
+		"login_ok":               "{{.Tag}} OK [CAPABILITY {{.Capability}}] Logged in",
+		"login_failed":           "{{.Tag}} NO LOGIN failed",
+		"too_many_errors":        "",
+	},
+	LoginDelay: 3 * time.Second,
+	MaxErrors:  1,
+}
+
+// Dovecot 2.3 as packaged by Ubuntu 22.04
+var dovecot = &Persona{
+	Name:       "dovecot",
+	Protocol:   "imap",
+	Product:    "Dovecot 2.3 (Ubuntu)",
+	Capability: "IMAP4rev1 SASL-IR LOGIN-REFERRALS ID ENABLE IDLE LITERAL+ AUTH=PLAIN",
+	Responses: map[string]string{
+		"greeting":               "* OK [CAPABILITY {{.Capability}}] Dovecot (Ubuntu) ready.",
+		"capability":             "* CAPABILITY {{.Capability}}\n{{.Tag}} OK {{if .Username}}Capability completed.{{else}}Pre-login capabilities listed, post-login capabilities have more.{{end}}",
+		"noop":                   "{{.Tag}} OK NOOP completed.",
+		"id":                     "* ID (\"name\" \"Dovecot\")\n{{.Tag}} OK ID completed.",
+		"logout":                 "* BYE Logging out\n{{.Tag}} OK Logout completed.",
+		"bad_command":            "{{.Tag}} BAD Error in IMAP command {{upper .Command}}: Unknown command.",
+		"syntax_error":           "{{.Tag}} BAD Error in IMAP command received by server.",
+		"starttls":               "{{.Tag}} OK Begin TLS negotiation now.",
+		"starttls_active":        "{{.Tag}} BAD TLS is already active.",
+		"starttls_unavailable":   "{{.Tag}} BAD TLS support isn't enabled.",
+		"already_authenticated":  "{{.Tag}} BAD Error in IMAP command {{upper .Command}}: Unknown command.",
+		"auth_mechanism_missing": "{{.Tag}} BAD Error in IMAP command AUTHENTICATE: Missing SASL mechanism parameter.",
+		"auth_unsupported":       "{{.Tag}} NO Unsupported authentication mechanism.",
+		"auth_cancelled":         "{{.Tag}} BAD Authentication aborted by client.",
+		"auth_malformed":         "{{.Tag}} BAD Invalid base64 data in continued response",
+		"login_ok":               "{{.Tag}} OK [CAPABILITY {{.Capability}} SORT SORT=DISPLAY THREAD=REFERENCES THREAD=REFS THREAD=ORDEREDSUBJECT MULTIAPPEND UNSELECT CHILDREN NAMESPACE UIDPLUS LIST-EXTENDED I18NLEVEL=1 ESEARCH SEARCHRES WITHIN LIST-STATUS SPECIAL-USE] Logged in",
+		"login_failed":           "{{.Tag}} NO [AUTHENTICATIONFAILED] Authentication failed.",
+		"too_many_errors":        "* BYE Too many invalid commands.",
+	},
+	FailDelay: 2 * time.Second, // auth_failure_delay
+	MaxErrors: 3,
+}
+
+// Cyrus IMAP 3.2 as packaged by Debian 11
+var cyrus = &Persona{
+	Name:       "cyrus",
+	Protocol:   "imap",
+	Product:    "Cyrus IMAP 3.2.6 (Debian)",
+	Capability: "IMAP4rev1 LITERAL+ ID ENABLE AUTH=PLAIN AUTH=LOGIN SASL-IR",
+	Responses: map[string]string{
+		"greeting":               "* OK [CAPABILITY {{.Capability}}] {{.Hostname}} Cyrus IMAP 3.2.6-Debian-3.2.6-2+deb11u2 server ready",
+		"capability":             "* CAPABILITY {{.Capability}}\n{{.Tag}} OK Completed",
+		"noop":                   "{{.Tag}} OK Completed",
+		"id":                     "* ID (\"name\" \"Cyrus IMAPD\" \"version\" \"3.2.6-Debian-3.2.6-2+deb11u2\" \"vendor\" \"Project Cyrus\" \"support-url\" \"https://www.cyrusimap.org\")\n{{.Tag}} OK Completed",
+		"logout":                 "* BYE LOGOUT received\n{{.Tag}} OK Completed",
+		"bad_command":            "{{.Tag}} BAD Unrecognized command",
+		"syntax_error":           "{{.Tag}} BAD Invalid command",
+		"starttls":               "{{.Tag}} OK Begin TLS negotiation now",
+		"starttls_active":        "{{.Tag}} BAD Already did a successful STARTTLS",
+		"starttls_unavailable":   "{{.Tag}} BAD Unrecognized command",
+		"already_authenticated":  "{{.Tag}} BAD Already authenticated",
+		"auth_mechanism_missing": "{{.Tag}} BAD Missing required argument to Authenticate",
+		"auth_unsupported":       "{{.Tag}} NO Error authenticating",
+		"auth_cancelled":         "{{.Tag}} BAD Client canceled authentication",
+		"auth_malformed":         "{{.Tag}} BAD Invalid base64 string",
+		"privacy_required":       "{{.Tag}} NO Login only available under a layer",
+		"login_ok":               "{{.Tag}} OK [CAPABILITY IMAP4rev1 LITERAL+ ID ENABLE ACL RIGHTS=kxten QUOTA MAILBOX-REFERRALS NAMESPACE UIDPLUS NO_ATOMIC_RENAME UNSELECT CHILDREN MULTIAPPEND BINARY CATENATE CONDSTORE ESEARCH SORT SORT=MODSEQ SORT=DISPLAY SORT=UID THREAD=ORDEREDSUBJECT THREAD=REFERENCES ANNOTATEMORE ANNOTATE-EXPERIMENT-1 METADATA LIST-EXTENDED LIST-STATUS LIST-MYRIGHTS LIST-METADATA WITHIN QRESYNC SCAN XLIST XMOVE MOVE SPECIAL-USE CREATE-SPECIAL-USE IDLE URLAUTHFULL] User logged in SESSIONID=<{{.Hostname}}-{{.Session}}>",
+		"login_failed":           "{{.Tag}} NO [AUTHENTICATIONFAILED] Invalid credentials",
+		"too_many_errors":        "* BYE Too many login failures",
+	},
+	FailDelay: time.Second,
+	MaxErrors: 3,
+}
+
+// Exchange 2016/2019 front end, NTLM offered and plaintext refused before
+// STARTTLS
+var exchange = &Persona{
+	Name:       "exchange",
+	Protocol:   "imap",
+	Product:    "Microsoft Exchange Server 2016/2019",
+	Capability: "IMAP4 IMAP4rev1 AUTH=NTLM AUTH=PLAIN SASL-IR UIDPLUS MOVE ID UNSELECT CHILDREN IDLE NAMESPACE LITERAL+",
+	Responses: map[string]string{
+		"greeting":               "* OK The Microsoft Exchange IMAP4 service is ready. [{{base64 .Hostname}}]",
+		"capability":             "* CAPABILITY {{.Capability}}\n{{.Tag}} OK CAPABILITY completed.",
+		"noop":                   "{{.Tag}} OK NOOP completed.",
+		"id":                     "* ID NIL\n{{.Tag}} OK ID completed.",
+		"logout":                 "* BYE Microsoft Exchange Server IMAP4 server signing off.\n{{.Tag}} OK LOGOUT completed.",
+		"bad_command":            "{{.Tag}} BAD Command Error. 12",
+		"syntax_error":           "{{.Tag}} BAD Command Argument Error. 11",
+		"starttls":               "{{.Tag}} OK Begin TLS negotiation now.",
+		"starttls_active":        "{{.Tag}} BAD Command received in Invalid state.",
+		"starttls_unavailable":   "{{.Tag}} BAD Command received in Invalid state.",
+		"already_authenticated":  "{{.Tag}} BAD Command received in Invalid state.",
+		"auth_mechanism_missing": "{{.Tag}} BAD Command Argument Error. 11",
+		"auth_unsupported":       "{{.Tag}} NO The specified authentication mechanism is not supported.",
+		"auth_cancelled":         "{{.Tag}} BAD AUTHENTICATE aborted.",
+		"auth_malformed":         "{{.Tag}} BAD Command Argument Error. 11",
+		"privacy_required":       "{{.Tag}} BAD Command received in Invalid state.",
+		"login_ok":               "{{.Tag}} OK {{upper .Command}} completed.",
+		"login_failed":           "{{.Tag}} NO {{upper .Command}} failed.",
+		"too_many_errors":        "",
+	},
+	FailDelay: time.Second,
+	MaxErrors: 3,
+}
+
+func init() {
+	register(imapDefault, dovecot, cyrus, exchange)
+}
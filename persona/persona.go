@@ -0,0 +1,175 @@
+// Package persona makes the honeypots answer like a given server product:
+// greeting, capabilities, reply wording and timing
+package persona
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Persona responses are text/templates rendered with Vars, one or more
+// lines without the final CRLF. An empty response sends nothing.
+type Persona struct {
+	Name       string
+	Protocol   string // imap or smtp
+	Product    string
+	Capability string            // IMAP capability or SMTP EHLO reply, a template too
+	Responses  map[string]string // by response id, missing ones come from the default persona
+
+	GreetingDelay time.Duration // reverse DNS and ident lookups before the greeting
+	LoginDelay    time.Duration // before any login reply
+	FailDelay     time.Duration // added before a failed login reply
+	MaxErrors     int           // failed logins, or SMTP errors, before the connection is closed, 0 for never
+}
+
+// Vars available to the templates
+type Vars struct {
+	Hostname   string
+	ClientIP   string
+	Session    string
+	Tag        string // IMAP command tag
+	Command    string // command name, or line for unknown commands, as sent
+	Mechanism  string // SASL mechanism as sent
+	Capability string // current capability, STARTTLS and LOGINDISABLED included
+	Username   string // empty until authenticated
+	Helo       string // SMTP HELO/EHLO argument
+	Address    string // SMTP MAIL FROM/RCPT TO address
+	Error      string // parser error message
+	Date       time.Time
+}
+
+var funcs = template.FuncMap{
+	"base64":  func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"first":   func(s string) string { return strings.SplitN(strings.TrimRight(s, "\r\n"), "\n", 2)[0] },
+	"queueid": QueueID,
+	"rfc822":  func(t time.Time) string { return t.Format("Mon, 2 Jan 2006 15:04:05 -0700") },
+}
+
+var (
+	cacheMu sync.RWMutex
+	cache   = map[string]*template.Template{}
+)
+
+// Parse checks a response template
+func Parse(text string) (*template.Template, error) {
+	cacheMu.RLock()
+	t, ok := cache[text]
+	cacheMu.RUnlock()
+	if ok {
+		return t, nil
+	}
+	t, err := template.New("").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	cacheMu.Lock()
+	cache[text] = t
+	cacheMu.Unlock()
+	return t, nil
+}
+
+// Render renders a template to CRLF terminated lines, or the template
+// itself along with the error if it fails
+func Render(text string, v *Vars) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return lines(text), nil
+	}
+	t, err := Parse(text)
+	if err != nil {
+		return lines(text), err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, v); err != nil {
+		return lines(text), err
+	}
+	return lines(b.String()), nil
+}
+
+func lines(s string) string {
+	s = strings.TrimRight(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	if s == "" {
+		return ""
+	}
+	return strings.ReplaceAll(s, "\n", "\r\n") + "\r\n"
+}
+
+// Reply renders the response id, see Render
+func (p *Persona) Reply(id string, v *Vars) (string, error) {
+	text, ok := p.Responses[id]
+	if !ok {
+		if d := Default(p.Protocol); d != p {
+			return d.Reply(id, v)
+		}
+		return "", fmt.Errorf("no %s response %q", p.Protocol, id)
+	}
+	return Render(text, v)
+}
+
+// QueueID returns a random message ID in the style of an MTA
+func QueueID(style string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	h := strings.ToUpper(hex.EncodeToString(b))
+	switch style {
+	case "exim": // base62 time-pid-sub, close enough
+		const b62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+		id := make([]byte, 16)
+		for i := range id {
+			id[i] = b62[int(b[i%8]+byte(i*7))%62]
+		}
+		return string(id[:6]) + "-" + string(id[6:12]) + "-" + string(id[12:14])
+	case "sendmail":
+		return fmt.Sprintf("%s%s", time.Now().UTC().Format("0601021504"), strings.ToLower(h[:4]))
+	case "exchange":
+		return strings.ToLower(h[:8] + "-" + h[8:12] + "-" + h[12:16])
+	}
+	return h[:10] // postfix
+}
+
+var all = map[string][]*Persona{}
+
+func register(personas ...*Persona) {
+	for _, p := range personas {
+		all[p.Protocol] = append(all[p.Protocol], p)
+	}
+}
+
+// Default is the legacy persona of a protocol, the first registered
+func Default(protocol string) *Persona {
+	if l := all[protocol]; len(l) > 0 {
+		return l[0]
+	}
+	return nil
+}
+
+// Get finds a persona by name
+func Get(protocol string, name string) (*Persona, error) {
+	for _, p := range all[protocol] {
+		if strings.EqualFold(p.Name, name) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown %s persona %q, one of %s", protocol, name, strings.Join(Names(protocol), ", "))
+}
+
+// Names lists the personas of a protocol
+func Names(protocol string) []string {
+	var names []string
+	for _, p := range all[protocol] {
+		names = append(names, p.Name)
+	}
+	if len(names) > 1 {
+		sort.Strings(names[1:])
+	}
+	return names
+}
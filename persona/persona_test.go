@@ -0,0 +1,147 @@
+package persona
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReply(t *testing.T) {
+	v := &Vars{Hostname: "mx.example.com", ClientIP: "192.0.2.7", Tag: "a1", Command: "xyzzy",
+		Capability: "IMAP4rev1 AUTH=PLAIN", Date: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)}
+	var tests = []struct {
+		protocol string
+		name     string
+		id       string
+		want     string
+	}{
+		{"imap", "imaphoney", "greeting", "* OK IMAP4rev1 Service Ready\r\n"},
+		{"imap", "imaphoney", "capability", "* CAPABILITY IMAP4rev1 AUTH=PLAIN\r\na1 OK CAPABILITY\r\n"},
+		{"imap", "imaphoney", "too_many_errors", ""},
+		{"imap", "dovecot", "bad_command", "a1 BAD Error in IMAP command XYZZY: Unknown command.\r\n"},
+		{"imap", "dovecot", "starttls_active", "a1 BAD TLS is already active.\r\n"},
+		{"imap", "dovecot", "starttls_unavailable", "a1 BAD TLS support isn't enabled.\r\n"},
+		{"imap", "dovecot", "privacy_required", "a1 NO [PRIVACYREQUIRED] Plaintext authentication disallowed on non-secure (SSL/TLS) connections.\r\n"},
+		{"imap", "exchange", "greeting", "* OK The Microsoft Exchange IMAP4 service is ready. [bXguZXhhbXBsZS5jb20=]\r\n"},
+		{"smtp", "smtphoney", "greeting", "220 mx.example.com ESMTP ready\r\n"},
+		{"smtp", "exim", "greeting", "220 mx.example.com ESMTP Exim 4.94.2 Thu, 4 Mar 2021 05:06:07 +0000\r\n"},
+		{"smtp", "sendmail", "unknown", "500 5.5.1 Command unrecognized: \"xyzzy\"\r\n"},
+	}
+
+	for _, tt := range tests {
+		p, err := Get(tt.protocol, tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := p.Reply(tt.id, v); got != tt.want || err != nil {
+			t.Errorf("%s %s %s: %q %v, want %q", tt.protocol, tt.name, tt.id, got, err, tt.want)
+		}
+	}
+
+	p := Default("smtp")
+	if _, err := p.Reply("nope", v); err == nil {
+		t.Errorf("no error for an unknown response")
+	}
+	if got, err := Render("250 {{.Nope}}", v); got != "250 {{.Nope}}\r\n" || err == nil {
+		t.Errorf("bad template: %q %v", got, err)
+	}
+}
+
+func TestPersonas(t *testing.T) {
+	for _, protocol := range []string{"imap", "smtp"} {
+		names := Names(protocol)
+		if names[0] != Default(protocol).Name {
+			t.Errorf("%s: default persona not first in %q", protocol, names)
+		}
+		for _, name := range names {
+			p, _ := Get(protocol, strings.ToUpper(name))
+			// every response id of the default persona renders
+			for id := range Default(protocol).Responses {
+				text, ok := p.Responses[id]
+				if !ok {
+					continue
+				}
+				if _, err := Parse(text); err != nil {
+					t.Errorf("%s %s %s: %v", protocol, name, id, err)
+				}
+			}
+			for id := range p.Responses {
+				if _, ok := Default(protocol).Responses[id]; !ok {
+					t.Errorf("%s %s: unknown response id %q", protocol, name, id)
+				}
+			}
+		}
+	}
+	if _, err := Get("smtp", "qmail"); err == nil {
+		t.Errorf("Get() found qmail")
+	}
+}
+
+func TestQueueID(t *testing.T) {
+	for style, n := range map[string]int{"postfix": 10, "exim": 16, "sendmail": 14, "exchange": 18} {
+		if id := QueueID(style); len(id) != n {
+			t.Errorf("%s queue id %q", style, id)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	p, err := Find("smtp", "testdata/acme.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	postfix, _ := Get("smtp", "postfix")
+	if p.Name != "acme" || p.GreetingDelay != 250*time.Millisecond || p.FailDelay != postfix.FailDelay || p.MaxErrors != 5 {
+		t.Errorf("acme persona %+v", p)
+	}
+	v := &Vars{Hostname: "mx.acme.test", ClientIP: "192.0.2.7", Session: "01ABC"}
+	if got, _ := p.Reply("auth_failed", v); got != "535 5.7.8 192.0.2.7 authentication failed, session 01ABC\r\n" {
+		t.Errorf("auth_failed %q", got)
+	}
+	if got, _ := p.Reply("rset", v); got != "250 2.0.0 Ok\r\n" {
+		t.Errorf("rset from postfix %q", got)
+	}
+	if got, _ := Render(p.Capability, v); got != "250-mx.acme.test\r\n250-SIZE 20971520\r\n250-AUTH PLAIN LOGIN\r\n250 8BITMIME\r\n" {
+		t.Errorf("capability %q", got)
+	}
+
+	p, err = Load("testdata/imap.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Tag = "a1"
+	noop, _ := p.Reply("noop", v)
+	failed, _ := p.Reply("login_failed", v)
+	if p.Protocol != "imap" || p.FailDelay != 5*time.Second || noop != "a1 OK\r\n" || failed != "a1 NO Login failed.\r\n" {
+		t.Errorf("courier persona %+v", p)
+	}
+	if _, err := Find("smtp", "testdata/imap.json"); err == nil {
+		t.Errorf("IMAP persona used for SMTP")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var tests = []struct {
+		file string
+		err  string
+	}{
+		{"name: x\nprotocol: pop3\n", "2: protocol \"pop3\" is not imap or smtp"},
+		{"protocol: smtp\n", "1: name is missing"},
+		{"name: x\nprotocol: smtp\nextends: qmail\n", "3: unknown smtp persona \"qmail\", one of smtphoney, exchange, exim, postfix, sendmail"},
+		{"name: x\nprotocol: smtp\nbanner: hello\n", "3: field banner not found in type persona.file"},
+		{"name: x\nprotocol: smtp\nfail_delay: 3\n", "3: fail_delay: invalid duration \"3\""},
+		{"name: x\nprotocol: smtp\nmax_errors: lots\n", "3: cannot unmarshal !!str `lots` into int"},
+		{"name: x\nprotocol: smtp\nresponses:\n  greeting: 220 ok\n  hello: 250 hi\n", "5: unknown smtp response \"hello\", one of "},
+		{"name: x\nprotocol: imap\nresponses:\n\n  greeting: \"* OK {{.Host}}\"\n", "5: response greeting: "},
+		{"name: x\nprotocol: imap\ncapability: \"{{.Tag\"\n", "3: capability: "},
+		{"{\"name\": \"x\",\n \"protocol\": \"imap\",\n \"responses\": {\"noop\": 1}\n", "3: did not find expected ',' or '}'"},
+		{"- name: x\n", "1: persona is not a mapping"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(strings.NewReader(tt.file))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%q: %v, want %s", tt.file, err, tt.err)
+		}
+	}
+}
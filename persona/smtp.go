@@ -0,0 +1,191 @@
+package persona
+
+import "time"
+
+// SMTP response ids
+//
+//	greeting, helo, ehlo, mail_ok, mail_too_big, rcpt_ok, rcpt_denied,
+//	data_start, data_need_rcpt, data_too_big, queued, end, rset, quit,
+//	auth_disabled, auth_syntax, auth_unsupported, auth_aborted,
+//	auth_malformed, auth_ok, auth_failed, starttls, starttls_active,
+//	starttls_unavailable, starttls_syntax, unknown, too_many_errors
+var smtpDefault = &Persona{
+	Name:       "smtphoney",
+	Protocol:   "smtp",
+	Product:    "the original smtphoney replies",
+	Capability: "250-localhost",
+	Responses: map[string]string{
+		"greeting":             "220 {{.Hostname}} ESMTP ready",
+		"helo":                 "{{first .Capability}}",
+		"ehlo":                 "{{.Capability}}",
+		"mail_ok":              "250 Recipient ok",
+		"mail_too_big":         "552 5.3.4 Message size exceeds fixed limit",
+		"rcpt_ok":              "250 Sender ok",
+		"rcpt_denied":          "550 <{{.Address}}>... Denied due to spam list",
+		"data_start":           "354 Enter mail, end with \".\" on a line by itself",
+		"data_need_rcpt":       "503 5.5.1 Error: need RCPT command",
+		"data_too_big":         "552 5.3.4 Error: message file too big",
+		"queued":               "250 2.0.0 Ok: queued",
+		"end":                  "250 Ok",
+		"rset":                 "250 Ok",
+		"quit":                 "221 2.0.0 Bye",
+		"auth_disabled":        "503 5.5.1 Error: authentication not enabled",
+		"auth_syntax":          "501 5.5.4 Syntax: AUTH mechanism",
+		"auth_unsupported":     "504 5.5.4 Unrecognized authentication type",
+		"auth_aborted":         "501 5.7.0 Authentication aborted",
+		"auth_malformed":       "501 5.5.2 Cannot decode response",
+		"auth_ok":              "235 2.7.0 Authentication successful",
+		"auth_failed":          "535 5.7.0 Error: authentication failed",
+		"starttls":             "220 2.0.0 Ready to start TLS",
+		"starttls_active":      "554 5.5.1 Error: TLS already active",
+		"starttls_unavailable": "454 TLS not available due to temporary reason",
+		"starttls_syntax":      "501 5.5.4 Syntax: STARTTLS",
+		"unknown":              "502 5.5.2 Error: command not recognized",
+		"too_many_errors":      "",
+	},
+	LoginDelay: 3 * time.Second,
+	MaxErrors:  1,
+}
+
+// Postfix 3.6 as packaged by Ubuntu 22.04
+var postfix = &Persona{
+	Name:       "postfix",
+	Protocol:   "smtp",
+	Product:    "Postfix 3.6 (Ubuntu)",
+	Capability: "250-{{.Hostname}}\n250-PIPELINING\n250-SIZE 10240000\n250-ETRN\n250-AUTH PLAIN LOGIN\n250-ENHANCEDSTATUSCODES\n250-8BITMIME\n250-DSN\n250 SMTPUTF8",
+	Responses: map[string]string{
+		"greeting":             "220 {{.Hostname}} ESMTP Postfix (Ubuntu)",
+		"helo":                 "250 {{.Hostname}}",
+		"mail_ok":              "250 2.1.0 Ok",
+		"rcpt_ok":              "250 2.1.5 Ok",
+		"rcpt_denied":          "454 4.7.1 <{{.Address}}>: Relay access denied",
+		"data_start":           "354 End data with <CR><LF>.<CR><LF>",
+		"data_need_rcpt":       "554 5.5.1 Error: no valid recipients",
+		"queued":               "250 2.0.0 Ok: queued as {{queueid \"postfix\"}}",
+		"end":                  "502 5.5.2 Error: command not recognized",
+		"rset":                 "250 2.0.0 Ok",
+		"auth_unsupported":     "535 5.7.8 Error: authentication failed: Invalid authentication mechanism",
+		"auth_failed":          "535 5.7.8 Error: authentication failed: authentication failure",
+		"starttls_unavailable": "502 5.5.1 Error: command not implemented",
+		"too_many_errors":      "421 4.7.0 {{.Hostname}} Error: too many errors",
+	},
+	FailDelay: time.Second, // smtpd_error_sleep_time
+	MaxErrors: 20,          // smtpd_hard_error_limit
+}
+
+// Exim 4.94 as packaged by Debian 11
+var exim = &Persona{
+	Name:       "exim",
+	Protocol:   "smtp",
+	Product:    "Exim 4.94.2 (Debian)",
+	Capability: "250-{{.Hostname}} Hello {{.Helo}} [{{.ClientIP}}]\n250-SIZE 52428800\n250-8BITMIME\n250-PIPELINING\n250-AUTH PLAIN LOGIN\n250-PRDR\n250 HELP",
+	Responses: map[string]string{
+		"greeting":             "220 {{.Hostname}} ESMTP Exim 4.94.2 {{rfc822 .Date}}",
+		"helo":                 "250 {{.Hostname}} Hello {{.Helo}} [{{.ClientIP}}]",
+		"mail_ok":              "250 OK",
+		"mail_too_big":         "552 Message size exceeds maximum permitted",
+		"rcpt_ok":              "250 Accepted",
+		"rcpt_denied":          "550 relay not permitted",
+		"data_start":           "354 Enter message, ending with \".\" on a line by itself",
+		"data_need_rcpt":       "503 valid RCPT command must precede DATA",
+		"data_too_big":         "552 Message size exceeds maximum permitted",
+		"queued":               "250 OK id={{queueid \"exim\"}}",
+		"end":                  "500 unrecognized command",
+		"rset":                 "250 Reset OK",
+		"quit":                 "221 {{.Hostname}} closing connection",
+		"auth_disabled":        "503 AUTH command used when not advertised",
+		"auth_syntax":          "501 AUTH mechanism must be given",
+		"auth_unsupported":     "504 {{upper .Mechanism}} mechanism not supported",
+		"auth_aborted":         "501 Authentication cancelled",
+		"auth_malformed":       "501 Invalid base64 data",
+		"auth_ok":              "235 Authentication succeeded",
+		"auth_failed":          "535 Incorrect authentication data",
+		"starttls":             "220 TLS go ahead",
+		"starttls_active":      "503 STARTTLS command used when not advertised",
+		"starttls_unavailable": "503 STARTTLS command used when not advertised",
+		"starttls_syntax":      "501 garbage after STARTTLS command",
+		"unknown":              "500 unrecognized command",
+		"too_many_errors":      "421 {{.Hostname}} too many unrecognized commands",
+	},
+	GreetingDelay: 500 * time.Millisecond, // rfc1413 lookup
+	FailDelay:     time.Second,
+	MaxErrors:     3, // smtp_max_unknown_commands
+}
+
+// Sendmail 8.15 as packaged by Debian
+var sendmail = &Persona{
+	Name:       "sendmail",
+	Protocol:   "smtp",
+	Product:    "Sendmail 8.15.2 (Debian)",
+	Capability: "250-{{.Hostname}} Hello [{{.ClientIP}}], pleased to meet you\n250-ENHANCEDSTATUSCODES\n250-PIPELINING\n250-EXPN\n250-VERB\n250-8BITMIME\n250-SIZE\n250-DSN\n250-ETRN\n250-AUTH DIGEST-MD5 CRAM-MD5 LOGIN PLAIN\n250-DELIVERBY\n250 HELP",
+	Responses: map[string]string{
+		"greeting":             "220 {{.Hostname}} ESMTP Sendmail 8.15.2/8.15.2/Debian-22; {{rfc822 .Date}}; (No UCE/UBE) logging access from: [{{.ClientIP}}]",
+		"helo":                 "250 {{.Hostname}} Hello [{{.ClientIP}}], pleased to meet you",
+		"mail_ok":              "250 2.1.0 <{{.Address}}>... Sender ok",
+		"mail_too_big":         "552 5.2.3 Message size exceeds maximum value",
+		"rcpt_ok":              "250 2.1.5 <{{.Address}}>... Recipient ok",
+		"rcpt_denied":          "550 5.7.1 <{{.Address}}>... Relaying denied",
+		"data_need_rcpt":       "503 5.0.0 Need RCPT (recipient)",
+		"data_too_big":         "552 5.2.3 Message size exceeds maximum value",
+		"queued":               "250 2.0.0 {{queueid \"sendmail\"}} Message accepted for delivery",
+		"end":                  "500 5.5.1 Command unrecognized: \".\"",
+		"rset":                 "250 2.0.0 Reset state",
+		"quit":                 "221 2.0.0 {{.Hostname}} closing connection",
+		"auth_disabled":        "503 5.3.3 AUTH not available",
+		"auth_syntax":          "501 5.5.2 AUTH mechanism must be specified",
+		"auth_unsupported":     "504 5.3.3 AUTH mechanism {{upper .Mechanism}} not available",
+		"auth_aborted":         "501 5.0.0 AUTH aborted",
+		"auth_malformed":       "501 5.5.4 cannot decode AUTH parameter",
+		"auth_ok":              "235 2.0.0 OK Authenticated",
+		"auth_failed":          "535 5.7.0 authentication failed",
+		"starttls_active":      "503 5.5.0 TLS already active",
+		"starttls_unavailable": "454 4.3.3 TLS not available after start",
+		"starttls_syntax":      "501 5.5.4 Syntax: STARTTLS",
+		"unknown":              "500 5.5.1 Command unrecognized: \"{{.Command}}\"",
+		"too_many_errors":      "421 4.7.0 {{.Hostname}} Too many bad commands; closing connection",
+	},
+	GreetingDelay: time.Second, // reverse lookup and ident
+	MaxErrors:     25,          // MaxBadCommands
+}
+
+// Exchange 2016/2019 front end transport, NTLM offered
+var exchangeSMTP = &Persona{
+	Name:       "exchange",
+	Protocol:   "smtp",
+	Product:    "Microsoft Exchange Server 2016/2019",
+	Capability: "250-{{.Hostname}} Hello [{{.ClientIP}}]\n250-SIZE 37748736\n250-PIPELINING\n250-DSN\n250-ENHANCEDSTATUSCODES\n250-AUTH NTLM LOGIN\n250-8BITMIME\n250 SMTPUTF8",
+	Responses: map[string]string{
+		"greeting":             "220 {{.Hostname}} Microsoft ESMTP MAIL Service ready at {{rfc822 .Date}}",
+		"helo":                 "250 {{.Hostname}} Hello [{{.ClientIP}}]",
+		"mail_ok":              "250 2.1.0 Sender OK",
+		"mail_too_big":         "552 5.3.4 Message size exceeds fixed maximum message size",
+		"rcpt_ok":              "250 2.1.5 Recipient OK",
+		"rcpt_denied":          "550 5.7.54 SMTP; Unable to relay recipient in non-accepted domain",
+		"data_start":           "354 Start mail input; end with <CRLF>.<CRLF>",
+		"data_need_rcpt":       "503 5.5.2 Need rcpt command",
+		"data_too_big":         "552 5.3.4 Message size exceeds fixed maximum message size",
+		"queued":               "250 2.6.0 <{{queueid \"exchange\"}}@{{.Hostname}}> [InternalId={{queueid \"postfix\"}}, Hostname={{upper .Hostname}}] Queued mail for delivery",
+		"end":                  "500 5.3.3 Unrecognized command '.'",
+		"rset":                 "250 2.0.0 Resetting",
+		"quit":                 "221 2.0.0 Service closing transmission channel",
+		"auth_disabled":        "503 5.5.1 Not available",
+		"auth_syntax":          "501 5.5.4 Invalid arguments",
+		"auth_unsupported":     "504 5.7.4 Unrecognized authentication type",
+		"auth_aborted":         "501 5.7.0 Authentication aborted",
+		"auth_malformed":       "501 5.5.4 Invalid arguments",
+		"auth_ok":              "235 2.7.0 Authentication successful",
+		"auth_failed":          "535 5.7.3 Authentication unsuccessful",
+		"starttls":             "220 2.0.0 SMTP server ready",
+		"starttls_active":      "503 5.5.1 Already in TLS session",
+		"starttls_unavailable": "500 5.3.3 Unrecognized command 'STARTTLS'",
+		"starttls_syntax":      "501 5.5.4 Invalid arguments",
+		"unknown":              "500 5.3.3 Unrecognized command '{{.Command}}'",
+		"too_many_errors":      "421 4.7.0 Too many errors on this connection, closing transmission channel",
+	},
+	FailDelay: 5 * time.Second, // AuthTarpitInterval
+	MaxErrors: 10,
+}
+
+func init() {
+	register(smtpDefault, postfix, exim, sendmail, exchangeSMTP)
+}
@@ -0,0 +1,111 @@
+package sasl
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"imap-honey/event"
+)
+
+// FromEvent rebuilds the credentials logged in a login event
+func FromEvent(ev *event.Event) (*Credentials, bool) {
+	if ev.Type != event.Login {
+		return nil, false
+	}
+	data := func(key string) string {
+		s, _ := ev.Data[key].(string)
+		return s
+	}
+	c := &Credentials{
+		Mechanism:   data("mechanism"),
+		Authzid:     data("authzid"),
+		Authcid:     data("authcid"),
+		Secret:      ev.Password,
+		Realm:       data("realm"),
+		Workstation: data("workstation"),
+		Challenge:   data("challenge"),
+		Response:    data("response"),
+	}
+	if c.Authcid == "" && c.Authzid == "" {
+		c.Authcid = ev.Username
+	}
+	return c, c.Mechanism != ""
+}
+
+// Captured reports whether c holds a challenge-response exchange, to be
+// cracked offline. Hashcat and John return no line for those they have no
+// format for, SCRAM ones and DIGEST-MD5 for hashcat.
+func (c *Credentials) Captured() bool {
+	return c.Challenge != ""
+}
+
+// Hashcat formats a challenge-response capture for the hashcat mode
+// returned, ok is false when hashcat has no mode for the mechanism
+func (c *Credentials) Hashcat() (mode int, line string, ok bool) {
+	switch c.Mechanism {
+	case "CRAM-MD5":
+		if c.Challenge == "" || c.Response == "" {
+			return 0, "", false
+		}
+		b64 := base64.StdEncoding.EncodeToString
+		return 16400, fmt.Sprintf("$cram_md5$%s$%s", b64([]byte(c.Challenge)), b64([]byte(c.Authcid+" "+c.Response))), true
+	case "NTLM":
+		v2, line, ok := c.netNTLM()
+		if !ok {
+			return 0, "", false
+		}
+		if v2 {
+			return 5600, line, true
+		}
+		return 5500, line, true
+	}
+	return 0, "", false
+}
+
+// netNTLM formats NTLM captures the way Responder does, which both
+// hashcat and John read. Anonymous logins have no NT response.
+func (c *Credentials) netNTLM() (v2 bool, line string, ok bool) {
+	sp := strings.SplitN(c.Response, ":", 2)
+	if len(sp) != 2 || len(sp[1]) < 48 || c.Authcid == "" || c.Challenge == "" {
+		return false, "", false
+	}
+	lm, nt := sp[0], sp[1]
+	if len(nt) == 48 { // NTLMv1, 24 bytes
+		return false, fmt.Sprintf("%s::%s:%s:%s:%s", c.Authcid, c.Realm, lm, nt, c.Challenge), true
+	}
+	return true, fmt.Sprintf("%s::%s:%s:%s:%s", c.Authcid, c.Realm, c.Challenge, nt[:32], nt[32:]), true
+}
+
+// John formats a challenge-response capture for the John the Ripper
+// format returned, ok is false when there is none for the mechanism
+func (c *Credentials) John() (format string, line string, ok bool) {
+	switch c.Mechanism {
+	case "CRAM-MD5":
+		if c.Challenge == "" || c.Response == "" {
+			return "", "", false
+		}
+		return "hmac-md5", fmt.Sprintf("%s:%s#%s", c.Authcid, c.Challenge, c.Response), true
+	case "DIGEST-MD5":
+		d, err := Directives(c.Response)
+		if err != nil || d["username"] == "" || d["nonce"] == "" || d["response"] == "" {
+			return "", "", false
+		}
+		line = strings.Join([]string{"$DIGEST-MD5", d["username"], d["realm"], d["nonce"], d["digest-uri"],
+			d["cnonce"], d["nc"], d["qop"], d["response"]}, "$")
+		if d["authzid"] != "" {
+			line += "$" + d["authzid"]
+		}
+		return "dmd5", fmt.Sprintf("%s:%s", d["username"], line), true
+	case "NTLM":
+		v2, line, ok := c.netNTLM()
+		if !ok {
+			return "", "", false
+		}
+		if v2 {
+			return "netntlmv2", line, true
+		}
+		return "netntlm", line, true
+	}
+	return "", "", false
+}
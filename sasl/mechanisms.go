@@ -0,0 +1,260 @@
+package sasl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func nonce(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+// PLAIN, RFC 4616: authzid NUL authcid NUL passwd
+type plain struct{ creds *Credentials }
+
+func (m *plain) Credentials() *Credentials { return m.creds }
+func (m *plain) Next(response []byte) ([]byte, bool, error) {
+	if response == nil {
+		return []byte{}, false, nil
+	}
+	sp := bytes.Split(response, []byte("\x00"))
+	if len(sp) != 3 {
+		m.creds.Response = string(bytes.Replace(response, []byte("\x00"), []byte(" "), -1))
+		return nil, true, ErrMalformed
+	}
+	m.creds.Authzid, m.creds.Authcid, m.creds.Secret = string(sp[0]), string(sp[1]), string(sp[2])
+	return nil, true, nil
+}
+
+// LOGIN, the obsolete but ubiquitous username then password prompts
+type login struct {
+	creds *Credentials
+	step  int
+}
+
+func (m *login) Credentials() *Credentials { return m.creds }
+func (m *login) Next(response []byte) ([]byte, bool, error) {
+	if response == nil && m.step == 0 {
+		m.step++
+		return []byte("Username:"), false, nil
+	}
+	if m.step < 2 {
+		m.step = 2
+		m.creds.Authcid = string(response)
+		return []byte("Password:"), false, nil
+	}
+	m.creds.Secret = string(response)
+	return nil, true, nil
+}
+
+// CRAM-MD5, RFC 2195: the client answers "user hex(hmac-md5(pass, challenge))"
+type cramMD5 struct {
+	creds    *Credentials
+	hostname string
+}
+
+func (m *cramMD5) Credentials() *Credentials { return m.creds }
+func (m *cramMD5) Next(response []byte) ([]byte, bool, error) {
+	if m.creds.Challenge == "" {
+		var b [4]byte
+		rand.Read(b[:])
+		m.creds.Challenge = fmt.Sprintf("<%d.%d@%s>", uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8|uint32(b[3]), time.Now().Unix(), m.hostname)
+		return []byte(m.creds.Challenge), false, nil
+	}
+	i := bytes.LastIndexByte(response, ' ')
+	if i < 0 {
+		m.creds.Response = string(response)
+		return nil, true, ErrMalformed
+	}
+	m.creds.Authcid, m.creds.Response = string(response[:i]), string(response[i+1:])
+	if _, err := hex.DecodeString(m.creds.Response); err != nil {
+		return nil, true, ErrMalformed
+	}
+	return nil, true, nil
+}
+
+// DIGEST-MD5, RFC 2831. The exchange stops at the client response, we
+// cannot compute rspauth without the password.
+type digestMD5 struct {
+	creds    *Credentials
+	hostname string
+}
+
+func (m *digestMD5) Credentials() *Credentials { return m.creds }
+func (m *digestMD5) Next(response []byte) ([]byte, bool, error) {
+	if m.creds.Challenge == "" {
+		m.creds.Challenge = fmt.Sprintf(`realm="%s",nonce="%s",qop="auth",charset=utf-8,algorithm=md5-sess`, m.hostname, nonce(24))
+		return []byte(m.creds.Challenge), false, nil
+	}
+	m.creds.Response = string(response)
+	d, err := Directives(m.creds.Response)
+	if err != nil || d["username"] == "" || d["response"] == "" {
+		return nil, true, ErrMalformed
+	}
+	m.creds.Authcid, m.creds.Authzid, m.creds.Realm = d["username"], d["authzid"], d["realm"]
+	return nil, true, nil
+}
+
+// Directives parses a DIGEST-MD5 comma separated list of name=value, the
+// values optionally quoted
+func Directives(s string) (map[string]string, error) {
+	d := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return d, ErrMalformed
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return d, ErrMalformed
+			}
+			s = s[i+1:]
+		} else {
+			i := strings.IndexByte(s, ',')
+			if i < 0 {
+				i = len(s)
+			}
+			value.WriteString(strings.TrimSpace(s[:i]))
+			s = s[i:]
+		}
+		d[name] = value.String()
+		s = strings.TrimLeft(s, " \t,")
+	}
+	return d, nil
+}
+
+// SCRAM-SHA-*, RFC 5802. The exchange stops at the client final message,
+// Challenge is the AuthMessage and Response the ClientProof, which with
+// the salt and iteration count is enough to test passwords offline.
+type scram struct {
+	creds       *Credentials
+	clientFirst string
+	serverFirst string
+}
+
+func (m *scram) Credentials() *Credentials { return m.creds }
+func (m *scram) Next(response []byte) ([]byte, bool, error) {
+	if response == nil && m.clientFirst == "" {
+		return []byte{}, false, nil
+	}
+	if m.clientFirst == "" {
+		// gs2-header: cbind-flag "," [ "a=" authzid ] ","
+		sp := strings.SplitN(string(response), ",", 3)
+		if len(sp) != 3 || sp[0] == "" {
+			m.creds.Response = string(response)
+			return nil, true, ErrMalformed
+		}
+		if strings.HasPrefix(sp[1], "a=") {
+			m.creds.Authzid = saslname(sp[1][2:])
+		}
+		m.clientFirst = sp[2]
+		attrs := scramAttributes(m.clientFirst)
+		if attrs["n"] == "" || attrs["r"] == "" {
+			m.creds.Response = string(response)
+			return nil, true, ErrMalformed
+		}
+		m.creds.Authcid = saslname(attrs["n"])
+		salt := make([]byte, 16)
+		rand.Read(salt)
+		m.serverFirst = fmt.Sprintf("r=%s%s,s=%s,i=4096", attrs["r"], nonce(18), base64.StdEncoding.EncodeToString(salt))
+		return []byte(m.serverFirst), false, nil
+	}
+	final := string(response)
+	i := strings.LastIndex(final, ",p=")
+	if i < 0 {
+		m.creds.Response = final
+		return nil, true, ErrMalformed
+	}
+	m.creds.Challenge = m.clientFirst + "," + m.serverFirst + "," + final[:i]
+	m.creds.Response = final[i+3:]
+	return nil, true, nil
+}
+
+func scramAttributes(s string) map[string]string {
+	a := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		if len(kv) > 1 && kv[1] == '=' {
+			a[kv[:1]] = kv[2:]
+		}
+	}
+	return a
+}
+
+func saslname(s string) string {
+	return strings.NewReplacer("=2C", ",", "=3D", "=").Replace(s)
+}
+
+// XOAUTH2: "user=" user ^A "auth=Bearer " token ^A ^A
+type xoauth2 struct{ creds *Credentials }
+
+func (m *xoauth2) Credentials() *Credentials { return m.creds }
+func (m *xoauth2) Next(response []byte) ([]byte, bool, error) {
+	if response == nil {
+		return []byte{}, false, nil
+	}
+	kv := keyValues(string(response))
+	if kv["user"] == "" && kv["auth"] == "" {
+		m.creds.Response = string(response)
+		return nil, true, ErrMalformed
+	}
+	m.creds.Authcid, m.creds.Secret = kv["user"], bearer(kv["auth"])
+	return nil, true, nil
+}
+
+// OAUTHBEARER, RFC 7628: gs2-header ^A key=value ^A ... ^A
+type oauthBearer struct{ creds *Credentials }
+
+func (m *oauthBearer) Credentials() *Credentials { return m.creds }
+func (m *oauthBearer) Next(response []byte) ([]byte, bool, error) {
+	if response == nil {
+		return []byte{}, false, nil
+	}
+	s := string(response)
+	i := strings.IndexByte(s, '\x01')
+	if i < 0 {
+		m.creds.Response = s
+		return nil, true, ErrMalformed
+	}
+	for _, f := range strings.Split(s[:i], ",") {
+		if strings.HasPrefix(f, "a=") {
+			m.creds.Authzid = saslname(f[2:])
+		}
+	}
+	kv := keyValues(s[i+1:])
+	m.creds.Secret = bearer(kv["auth"])
+	return nil, true, nil
+}
+
+func keyValues(s string) map[string]string {
+	kv := map[string]string{}
+	for _, f := range strings.Split(s, "\x01") {
+		if i := strings.IndexByte(f, '='); i > 0 {
+			kv[f[:i]] = f[i+1:]
+		}
+	}
+	return kv
+}
+
+func bearer(auth string) string {
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return auth[7:]
+	}
+	return auth
+}
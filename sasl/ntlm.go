@@ -0,0 +1,183 @@
+package sasl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLM negotiate flags, MS-NLMP 2.2.2.5
+const (
+	ntlmUnicode       = 0x00000001
+	ntlmOEM           = 0x00000002
+	ntlmRequestTarget = 0x00000004
+	ntlmNTLM          = 0x00000200
+	ntlmAlwaysSign    = 0x00008000
+	ntlmTargetDomain  = 0x00010000
+	ntlmESS           = 0x00080000
+	ntlmTargetInfo    = 0x00800000
+	ntlmVersion       = 0x02000000
+	ntlm128           = 0x20000000
+	ntlmKeyExchange   = 0x40000000
+	ntlm56            = 0x80000000
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// Windows Server 2016, NTLM revision 15
+var ntlmServerVersion = []byte{10, 0, 0x39, 0x38, 0, 0, 0, 15}
+
+// NTLM, MS-NLMP: negotiate, challenge, authenticate. Challenge is the hex
+// server challenge and Response the hex LM and NT responses separated by
+// a colon, a NT response longer than 24 bytes is NTLMv2.
+type ntlm struct {
+	creds  *Credentials
+	config *Config
+	flags  uint32
+}
+
+func (m *ntlm) Credentials() *Credentials { return m.creds }
+func (m *ntlm) Next(response []byte) ([]byte, bool, error) {
+	if response == nil && m.creds.Challenge == "" {
+		return []byte{}, false, nil
+	}
+	if m.creds.Challenge == "" {
+		if !bytes.HasPrefix(response, ntlmSignature) || len(response) < 16 || binary.LittleEndian.Uint32(response[8:]) != 1 {
+			m.creds.Response = hex.EncodeToString(response)
+			return nil, true, ErrMalformed
+		}
+		m.flags = binary.LittleEndian.Uint32(response[12:])
+		challenge := make([]byte, 8)
+		rand.Read(challenge)
+		m.creds.Challenge = hex.EncodeToString(challenge)
+		return m.challenge(challenge), false, nil
+	}
+	if !bytes.HasPrefix(response, ntlmSignature) || len(response) < 64 || binary.LittleEndian.Uint32(response[8:]) != 3 {
+		m.creds.Response = hex.EncodeToString(response)
+		return nil, true, ErrMalformed
+	}
+	unicode := binary.LittleEndian.Uint32(response[60:])&ntlmUnicode != 0
+	field := func(offset int) []byte {
+		// in uint64, as an int offset may be negative on 386
+		l := uint64(binary.LittleEndian.Uint16(response[offset:]))
+		o := uint64(binary.LittleEndian.Uint32(response[offset+4:]))
+		if o+l > uint64(len(response)) {
+			return nil
+		}
+		return response[o : o+l]
+	}
+	text := func(offset int) string {
+		if unicode {
+			return decodeUTF16(field(offset))
+		}
+		return string(field(offset))
+	}
+	m.creds.Response = hex.EncodeToString(field(12)) + ":" + hex.EncodeToString(field(20))
+	m.creds.Realm, m.creds.Authcid, m.creds.Workstation = text(28), text(36), text(44)
+	return nil, true, nil
+}
+
+// challenge builds the CHALLENGE_MESSAGE with the target info of a domain
+// member server
+func (m *ntlm) challenge(serverChallenge []byte) []byte {
+	flags := uint32(ntlmRequestTarget | ntlmNTLM | ntlmAlwaysSign | ntlmTargetDomain | ntlmTargetInfo | ntlmVersion)
+	flags |= m.flags & (ntlmESS | ntlm128 | ntlmKeyExchange | ntlm56)
+	if m.flags&ntlmUnicode != 0 || m.flags&ntlmOEM == 0 {
+		flags |= ntlmUnicode
+	} else {
+		flags |= ntlmOEM
+	}
+	domain, dnsDomain, computer, dnsComputer := m.config.ntlmNames()
+	target := encodeUTF16(domain)
+	if flags&ntlmUnicode == 0 {
+		target = []byte(domain)
+	}
+
+	var info bytes.Buffer
+	pair := func(id uint16, value []byte) {
+		binary.Write(&info, binary.LittleEndian, id)
+		binary.Write(&info, binary.LittleEndian, uint16(len(value)))
+		info.Write(value)
+	}
+	pair(2, encodeUTF16(domain))
+	pair(1, encodeUTF16(computer))
+	pair(4, encodeUTF16(dnsDomain))
+	pair(3, encodeUTF16(dnsComputer))
+	pair(5, encodeUTF16(dnsDomain))
+	filetime := make([]byte, 8)
+	binary.LittleEndian.PutUint64(filetime, uint64(time.Now().UnixNano()/100+116444736000000000))
+	pair(7, filetime)
+	pair(0, nil)
+
+	const header = 56
+	msg := make([]byte, header, header+len(target)+info.Len())
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint16(msg[12:], uint16(len(target)))
+	binary.LittleEndian.PutUint16(msg[14:], uint16(len(target)))
+	binary.LittleEndian.PutUint32(msg[16:], header)
+	binary.LittleEndian.PutUint32(msg[20:], flags)
+	copy(msg[24:], serverChallenge)
+	binary.LittleEndian.PutUint16(msg[40:], uint16(info.Len()))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(info.Len()))
+	binary.LittleEndian.PutUint32(msg[44:], uint32(header+len(target)))
+	copy(msg[48:], ntlmServerVersion)
+	msg = append(msg, target...)
+	return append(msg, info.Bytes()...)
+}
+
+// ntlmNames derives the names missing from the config from the
+// hostname, mail.corp.example.com is MAIL in the CORP domain
+func (c *Config) ntlmNames() (domain string, dnsDomain string, computer string, dnsComputer string) {
+	dnsComputer = strings.ToLower(c.Hostname)
+	computer = dnsComputer
+	if i := strings.IndexByte(dnsComputer, '.'); i > 0 {
+		computer, dnsDomain = dnsComputer[:i], dnsComputer[i+1:]
+	}
+	if c.DNSDomain != "" {
+		dnsDomain = strings.ToLower(c.DNSDomain)
+		dnsComputer = computer + "." + dnsDomain
+	}
+	domain = c.Domain
+	if domain == "" {
+		domain = dnsDomain
+		if i := strings.IndexByte(domain, '.'); i > 0 {
+			domain = domain[:i]
+		}
+	}
+	if domain == "" {
+		domain = "WORKGROUP"
+	}
+	if dnsDomain == "" {
+		dnsDomain = strings.ToLower(domain)
+	}
+	return strings.ToUpper(truncate(domain, 15)), dnsDomain, strings.ToUpper(truncate(computer, 15)), dnsComputer
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func encodeUTF16(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, r := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	return b
+}
+
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
@@ -0,0 +1,138 @@
+package sasl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// authenticateMessage builds a unicode NTLM AUTHENTICATE_MESSAGE
+func authenticateMessage(lm []byte, nt []byte, domain string, user string, workstation string) []byte {
+	fields := [][]byte{lm, nt, encodeUTF16(domain), encodeUTF16(user), encodeUTF16(workstation), nil}
+	msg := make([]byte, 72)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	offset := len(msg)
+	for i, f := range fields {
+		binary.LittleEndian.PutUint16(msg[12+8*i:], uint16(len(f)))
+		binary.LittleEndian.PutUint16(msg[14+8*i:], uint16(len(f)))
+		binary.LittleEndian.PutUint32(msg[16+8*i:], uint32(offset))
+		offset += len(f)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], ntlmUnicode|ntlmNTLM|ntlmESS)
+	for _, f := range fields {
+		msg = append(msg, f...)
+	}
+	return msg
+}
+
+// targetInfo returns the AV pairs of a CHALLENGE_MESSAGE as strings
+func targetInfo(t *testing.T, msg []byte) map[uint16]string {
+	l := int(binary.LittleEndian.Uint16(msg[40:]))
+	o := int(binary.LittleEndian.Uint32(msg[44:]))
+	if o+l != len(msg) {
+		t.Fatalf("target info %d+%d in %d bytes", o, l, len(msg))
+	}
+	info := map[uint16]string{}
+	for b := msg[o : o+l]; len(b) >= 4; {
+		id, n := binary.LittleEndian.Uint16(b), int(binary.LittleEndian.Uint16(b[2:]))
+		if id == 0 {
+			break
+		}
+		info[id] = decodeUTF16(b[4 : 4+n])
+		b = b[4+n:]
+	}
+	return info
+}
+
+func TestNTLM(t *testing.T) {
+	negotiate, _ := hex.DecodeString("4e544c4d5353500001000000078208a2000000000000000000000000000000000a00614a0000000f")
+	ntv2, _ := hex.DecodeString("0101000000000000c0653150de09d201" + strings.Repeat("11", 16) + "00000000")
+	ntv2 = append(bytes.Repeat([]byte{0xaa}, 16), ntv2...)
+
+	var tests = []struct {
+		config   Config
+		nt       []byte
+		names    [4]string // NetBIOS domain, computer, DNS domain, DNS computer
+		hashcat  int
+		john     string
+		exported string
+	}{
+		{Config{Hostname: "mail.corp.example.com"}, bytes.Repeat([]byte{0xbb}, 24),
+			[4]string{"CORP", "MAIL", "corp.example.com", "mail.corp.example.com"},
+			5500, "netntlm", "jdoe::CORP:" + strings.Repeat("cc", 24) + ":" + strings.Repeat("bb", 24) + ":"},
+		{Config{Hostname: "EXCH01", Domain: "contoso", DNSDomain: "Contoso.local"}, ntv2,
+			[4]string{"CONTOSO", "EXCH01", "contoso.local", "exch01.contoso.local"},
+			5600, "netntlmv2", "jdoe::CORP:"},
+	}
+	for _, tt := range tests {
+		s, _ := NewServer("NTLM", &tt.config)
+		if c, done, err := s.Next(nil); len(c) != 0 || done || err != nil {
+			t.Fatalf("no initial response: %q %v %v", c, done, err)
+		}
+		challenge, done, err := s.Next(negotiate)
+		if done || err != nil || !bytes.HasPrefix(challenge, ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+			t.Fatalf("CHALLENGE_MESSAGE %x %v", challenge, err)
+		}
+		if flags := binary.LittleEndian.Uint32(challenge[20:]); flags&ntlmUnicode == 0 || flags&ntlmESS == 0 || flags&ntlmTargetInfo == 0 {
+			t.Errorf("flags %08x", flags)
+		}
+		info := targetInfo(t, challenge)
+		if names := [4]string{info[2], info[1], info[4], info[3]}; names != tt.names {
+			t.Errorf("%s: names %q, want %q", tt.config.Hostname, names, tt.names)
+		}
+		serverChallenge := hex.EncodeToString(challenge[24:32])
+
+		_, done, err = s.Next(authenticateMessage(bytes.Repeat([]byte{0xcc}, 24), tt.nt, "CORP", "jdoe", "WS042"))
+		creds := s.Credentials()
+		if !done || err != nil || creds.Authcid != "jdoe" || creds.Realm != "CORP" || creds.Workstation != "WS042" || creds.Challenge != serverChallenge {
+			t.Fatalf("credentials %+v %v", creds, err)
+		}
+		mode, line, ok := creds.Hashcat()
+		if !ok || mode != tt.hashcat || !strings.HasPrefix(line, tt.exported) {
+			t.Errorf("hashcat %d %q", mode, line)
+		}
+		if tt.hashcat == 5500 && !strings.HasSuffix(line, ":"+serverChallenge) {
+			t.Errorf("NTLMv1 line %q", line)
+		}
+		if tt.hashcat == 5600 && line != "jdoe::CORP:"+serverChallenge+":"+strings.Repeat("aa", 16)+":"+hex.EncodeToString(ntv2[16:]) {
+			t.Errorf("NTLMv2 line %q", line)
+		}
+		if format, jline, _ := creds.John(); format != tt.john || jline != line {
+			t.Errorf("john %s %q", format, jline)
+		}
+	}
+
+	s, _ := NewServer("NTLM", &Config{})
+	if _, done, err := s.Next([]byte("NTLMSSP\x00\x03")); !done || err != ErrMalformed {
+		t.Errorf("truncated NEGOTIATE_MESSAGE: %v", err)
+	}
+}
+
+func TestNTLMOffsets(t *testing.T) {
+	negotiate, _ := hex.DecodeString("4e544c4d5353500001000000078208a2000000000000000000000000000000000a00614a0000000f")
+	var tests = []struct {
+		offset uint32 // of the user name field
+		length uint16
+		user   string
+	}{
+		{72 + 24 + 24 + 8, 8, "jdoe"},
+		{0xFFFFFFFF, 8, ""},
+		{0x80000000, 8, ""},
+		{0xFFFFFFF8, 0xFFFF, ""},
+		{72 + 24 + 24 + 8, 0xFFFF, ""},
+	}
+	for _, tt := range tests {
+		msg := authenticateMessage(make([]byte, 24), make([]byte, 24), "CORP", "jdoe", "WS042")
+		binary.LittleEndian.PutUint16(msg[36:], tt.length)
+		binary.LittleEndian.PutUint32(msg[40:], tt.offset)
+		s, _ := NewServer("NTLM", &Config{})
+		s.Next(nil)
+		s.Next(negotiate)
+		if _, done, err := s.Next(msg); !done || err != nil || s.Credentials().Authcid != tt.user {
+			t.Errorf("offset %#x length %d: user %q %v", tt.offset, tt.length, s.Credentials().Authcid, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Package sasl implements the server side of the SASL mechanisms offered
+// by the honeypots. Nothing is ever verified, the mechanisms only go far
+// enough in the exchange to capture what the client sends.
+package sasl
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"imap-honey/event"
+)
+
+var (
+	ErrCancelled   = errors.New("sasl: authentication cancelled")
+	ErrUnsupported = errors.New("sasl: unsupported mechanism")
+	ErrMalformed   = errors.New("sasl: malformed response")
+)
+
+// Credentials captured from an exchange. Secret is the cleartext password
+// or bearer token, challenge-response mechanisms fill Challenge and
+// Response instead.
+type Credentials struct {
+	Mechanism   string
+	Authzid     string
+	Authcid     string
+	Secret      string
+	Realm       string
+	Workstation string
+	Challenge   string
+	Response    string
+}
+
+// Username is the authentication identity, or the authorization identity
+// for mechanisms without one (OAUTHBEARER)
+func (c *Credentials) Username() string {
+	if c.Authcid != "" {
+		return c.Authcid
+	}
+	return c.Authzid
+}
+
+func (c *Credentials) String() string {
+	s := fmt.Sprintf("%s %s", c.Mechanism, strconv.QuoteToASCII(c.Username()))
+	if c.Authzid != "" && c.Authzid != c.Username() {
+		s += " AUTHZID " + strconv.QuoteToASCII(c.Authzid)
+	}
+	if c.Secret != "" {
+		s += " " + strconv.QuoteToASCII(c.Secret)
+	}
+	if c.Response != "" {
+		s += " RESPONSE " + strconv.QuoteToASCII(c.Response)
+	}
+	return s
+}
+
+// Log fills a login event with the credentials, the same way for every
+// protocol and mechanism
+func (c *Credentials) Log(ev *event.Event) *event.Event {
+	ev.Username, ev.Password = c.Username(), c.Secret
+	ev.Set("mechanism", c.Mechanism)
+	if c.Authzid != "" {
+		ev.Set("authzid", c.Authzid)
+	}
+	if c.Authcid != "" {
+		ev.Set("authcid", c.Authcid)
+	}
+	if c.Realm != "" {
+		ev.Set("realm", c.Realm)
+	}
+	if c.Workstation != "" {
+		ev.Set("workstation", c.Workstation)
+	}
+	if c.Challenge != "" {
+		ev.Set("challenge", c.Challenge)
+	}
+	if c.Response != "" {
+		ev.Set("response", c.Response)
+	}
+	return ev
+}
+
+// Server is one exchange. Next is called with the decoded client
+// response, nil when the client sent no initial response, and returns
+// the next challenge until done.
+type Server interface {
+	Next(response []byte) (challenge []byte, done bool, err error)
+	Credentials() *Credentials
+}
+
+// Mechanisms lists what NewServer knows
+var Mechanisms = []string{"PLAIN", "LOGIN", "CRAM-MD5", "DIGEST-MD5", "SCRAM-SHA-1", "SCRAM-SHA-256", "XOAUTH2", "OAUTHBEARER", "NTLM"}
+
+// Config is what the mechanisms tell about the server
+type Config struct {
+	Hostname  string // in CRAM-MD5, DIGEST-MD5 and NTLM challenges
+	Domain    string // NTLM NetBIOS domain, from the hostname if empty
+	DNSDomain string // NTLM DNS domain, from the hostname if empty
+}
+
+// NewServer starts an exchange
+func NewServer(mechanism string, config *Config) (Server, error) {
+	mechanism = strings.ToUpper(mechanism)
+	c := &Credentials{Mechanism: mechanism}
+	switch mechanism {
+	case "PLAIN":
+		return &plain{c}, nil
+	case "LOGIN":
+		return &login{creds: c}, nil
+	case "CRAM-MD5":
+		return &cramMD5{creds: c, hostname: config.Hostname}, nil
+	case "DIGEST-MD5":
+		return &digestMD5{creds: c, hostname: config.Hostname}, nil
+	case "SCRAM-SHA-1", "SCRAM-SHA-256":
+		return &scram{creds: c}, nil
+	case "XOAUTH2":
+		return &xoauth2{c}, nil
+	case "OAUTHBEARER":
+		return &oauthBearer{c}, nil
+	case "NTLM":
+		return &ntlm{creds: c, config: config}, nil
+	}
+	return nil, ErrUnsupported
+}
+
+// Authenticate drives an exchange over a protocol. initial is the
+// base64 initial response, nil if none was given, "=" if empty. send
+// writes a base64 challenge and recv reads the next client line, a "*"
+// line cancels. The credentials are returned with errors too, they may
+// hold what the client sent before giving up.
+func Authenticate(s Server, initial *string, send func(challenge string) error, recv func() (string, error)) (*Credentials, error) {
+	var response []byte
+	if initial != nil {
+		r, err := decode(*initial)
+		if err != nil {
+			return s.Credentials(), err
+		}
+		response = r
+	}
+	for {
+		challenge, done, err := s.Next(response)
+		if err != nil || done {
+			return s.Credentials(), err
+		}
+		if err = send(base64.StdEncoding.EncodeToString(challenge)); err != nil {
+			return s.Credentials(), err
+		}
+		line, err := recv()
+		if err != nil {
+			return s.Credentials(), err
+		}
+		if response, err = decode(line); err != nil {
+			return s.Credentials(), err
+		}
+	}
+}
+
+func decode(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "\r\n")
+	switch s {
+	case "*":
+		return nil, ErrCancelled
+	case "=", "":
+		return []byte{}, nil
+	}
+	d, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return d, nil
+}
+
+// Cleartext mechanisms send the secret as is, servers refuse them
+// without TLS when LOGINDISABLED or the like is in effect
+func Cleartext(mechanism string) bool {
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN", "LOGIN", "XOAUTH2", "OAUTHBEARER":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package sasl
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+type step func(challenge string) string
+
+func reply(s string) step { return func(string) string { return s } }
+
+// run plays the client side, replies are encoded unless they are "*"
+func run(mechanism string, initial *string, steps []step) ([]string, *Credentials, error) {
+	s, err := NewServer(mechanism, &Config{Hostname: "mail.example.com"})
+	if err != nil {
+		return nil, nil, err
+	}
+	var challenges []string
+	creds, err := Authenticate(s, initial,
+		func(c string) error {
+			d, _ := base64.StdEncoding.DecodeString(c)
+			challenges = append(challenges, string(d))
+			return nil
+		},
+		func() (string, error) {
+			if len(challenges) > len(steps) {
+				return "*", nil
+			}
+			r := steps[len(challenges)-1](challenges[len(challenges)-1])
+			if r == "*" {
+				return r, nil
+			}
+			return base64.StdEncoding.EncodeToString([]byte(r)), nil
+		})
+	return challenges, creds, err
+}
+
+func str(s string) *string { return &s }
+
+func TestAuthenticate(t *testing.T) {
+	cram := func(c string) string {
+		h := hmac.New(md5.New, []byte("tanstaaftanstaaf"))
+		h.Write([]byte(c))
+		return "tim " + hex.EncodeToString(h.Sum(nil))
+	}
+	digest := func(c string) string {
+		d, _ := Directives(c)
+		return `charset=utf-8,username="chris",realm="` + d["realm"] + `",nonce="` + d["nonce"] +
+			`",nc=00000001,cnonce="OA6MHXh6VqTrRk",digest-uri="imap/elwood.innosoft.com",response=d388dad90d4bbd760a152321f2143af7,qop=auth`
+	}
+	scramFinal := func(c string) string {
+		return "c=biws," + strings.Split(c, ",")[0] + ",p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	}
+	var tests = []struct {
+		mechanism string
+		initial   *string
+		steps     []step
+		want      Credentials
+		err       error
+	}{
+		{"PLAIN", nil, []step{reply("\x00joe\x00secret")}, Credentials{Authcid: "joe", Secret: "secret"}, nil},
+		{"plain", str(base64.StdEncoding.EncodeToString([]byte("admin\x00joe\x00a b"))), nil, Credentials{Authzid: "admin", Authcid: "joe", Secret: "a b"}, nil},
+		{"PLAIN", str("not base64!"), nil, Credentials{}, ErrMalformed},
+		{"PLAIN", nil, []step{reply("joe")}, Credentials{Response: "joe"}, ErrMalformed},
+		{"LOGIN", nil, []step{reply("joe"), reply("secret")}, Credentials{Authcid: "joe", Secret: "secret"}, nil},
+		{"LOGIN", str("am9l"), []step{reply("secret")}, Credentials{Authcid: "joe", Secret: "secret"}, nil},
+		{"LOGIN", nil, []step{reply("joe"), reply("*")}, Credentials{Authcid: "joe"}, ErrCancelled},
+		{"CRAM-MD5", nil, []step{cram}, Credentials{Authcid: "tim"}, nil},
+		{"DIGEST-MD5", nil, []step{digest}, Credentials{Authcid: "chris", Realm: "mail.example.com"}, nil},
+		{"SCRAM-SHA-256", str(base64.StdEncoding.EncodeToString([]byte("n,a=adm=2Cin,n=user,r=rOprNGfwEbeRWgbNEkqO"))), []step{scramFinal},
+			Credentials{Authzid: "adm,in", Authcid: "user", Response: "dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="}, nil},
+		{"SCRAM-SHA-1", nil, []step{reply("n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL"), scramFinal},
+			Credentials{Authcid: "user", Response: "dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="}, nil},
+		{"XOAUTH2", nil, []step{reply("user=someuser@example.com\x01auth=Bearer ya29.vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg\x01\x01")},
+			Credentials{Authcid: "someuser@example.com", Secret: "ya29.vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg"}, nil},
+		{"OAUTHBEARER", nil, []step{reply("n,a=user@example.com,\x01host=server.example.com\x01port=143\x01auth=Bearer vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg==\x01\x01")},
+			Credentials{Authzid: "user@example.com", Secret: "vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg=="}, nil},
+		{"PLAIN", nil, []step{reply("*")}, Credentials{}, ErrCancelled},
+	}
+
+	for _, tt := range tests {
+		challenges, creds, err := run(tt.mechanism, tt.initial, tt.steps)
+		if err != tt.err {
+			t.Errorf("%s: error %v, want %v", tt.mechanism, err, tt.err)
+			continue
+		}
+		got := *creds
+		if got.Mechanism != strings.ToUpper(tt.mechanism) {
+			t.Errorf("%s: mechanism %q", tt.mechanism, got.Mechanism)
+		}
+		got.Mechanism = ""
+		if tt.want.Challenge == "" {
+			got.Challenge = ""
+		}
+		if tt.want.Response == "" && tt.err == nil {
+			got.Response = ""
+		}
+		if got != tt.want {
+			t.Errorf("%s: %+v\n want %+v (challenges %q)", tt.mechanism, got, tt.want, challenges)
+		}
+	}
+}
+
+func TestCaptures(t *testing.T) {
+	// CRAM-MD5 captures are enough to check a password guess
+	key := []byte("tanstaaftanstaaf")
+	_, creds, err := run("CRAM-MD5", nil, []step{func(c string) string {
+		if !strings.HasPrefix(c, "<") || !strings.HasSuffix(c, "@mail.example.com>") {
+			t.Errorf("challenge %q", c)
+		}
+		h := hmac.New(md5.New, key)
+		h.Write([]byte(c))
+		return "tim " + hex.EncodeToString(h.Sum(nil))
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hmac.New(md5.New, key)
+	h.Write([]byte(creds.Challenge))
+	if creds.Response != hex.EncodeToString(h.Sum(nil)) {
+		t.Errorf("CRAM-MD5 %+v", creds)
+	}
+
+	// SCRAM AuthMessage is client-first-bare,server-first,client-final-without-proof
+	_, creds, _ = run("SCRAM-SHA-1", nil, []step{reply("n,,n=user,r=abc"), func(c string) string {
+		if !strings.HasPrefix(c, "r=abc") || !strings.Contains(c, ",s=") || !strings.HasSuffix(c, ",i=4096") {
+			t.Errorf("server-first %q", c)
+		}
+		return "c=biws," + strings.Split(c, ",")[0] + ",p=cHJvb2Y="
+	}})
+	sp := strings.Split(creds.Challenge, ",")
+	if len(sp) != 7 || sp[0] != "n=user" || sp[1] != "r=abc" || sp[5] != "c=biws" {
+		t.Errorf("SCRAM AuthMessage %q", creds.Challenge)
+	}
+
+	if _, err := NewServer("GSSAPI", &Config{}); err != ErrUnsupported {
+		t.Errorf("GSSAPI: %v", err)
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	d, err := Directives(`username="chris \"c\"", realm=elwood.innosoft.com ,nc=00000001,qop=auth`)
+	if err != nil || d["username"] != `chris "c"` || d["realm"] != "elwood.innosoft.com" || d["nc"] != "00000001" || d["qop"] != "auth" {
+		t.Errorf("Directives() = %q, %v", d, err)
+	}
+	if _, err := Directives(`username="chris`); err != ErrMalformed {
+		t.Errorf("unterminated quote: %v", err)
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	digest := `username="chris",realm="mail.example.com",nonce="abc",nc=00000001,cnonce="def",digest-uri="imap/mail.example.com",response=d388dad90d4bbd760a152321f2143af7,qop=auth`
+	var tests = []struct {
+		creds   Credentials
+		hashcat int // 0 for no line
+		john    string
+	}{
+		{Credentials{Mechanism: "CRAM-MD5", Authcid: "tim", Challenge: "<1.2@mail>", Response: "b913a602c7eda7a495b4e6e7334d3890"}, 16400, "hmac-md5"},
+		{Credentials{Mechanism: "CRAM-MD5", Authcid: "tim", Challenge: "<1.2@mail>"}, 0, ""},
+		{Credentials{Mechanism: "DIGEST-MD5", Challenge: `realm="mail.example.com",nonce="abc"`, Response: digest}, 0, "dmd5"},
+		{Credentials{Mechanism: "DIGEST-MD5", Challenge: `realm="mail.example.com",nonce="abc"`, Response: `username="chris"`}, 0, ""},
+		{Credentials{Mechanism: "SCRAM-SHA-1", Authcid: "user", Challenge: "n=user,r=abc,r=abcdef,s=c2FsdA==,i=4096,c=biws,r=abcdef", Response: "cHJvb2Y="}, 0, ""},
+		{Credentials{Mechanism: "SCRAM-SHA-256", Authcid: "user", Challenge: "n=user,r=abc,r=abcdef,s=c2FsdA==,i=4096,c=biws,r=abcdef", Response: "cHJvb2Y="}, 0, ""},
+		{Credentials{Mechanism: "NTLM", Authcid: "jdoe", Realm: "CORP", Challenge: "1122334455667788", Response: strings.Repeat("cc", 24) + ":" + strings.Repeat("bb", 24)}, 5500, "netntlm"},
+		{Credentials{Mechanism: "NTLM", Authcid: "jdoe", Realm: "CORP", Challenge: "1122334455667788", Response: ":" + strings.Repeat("aa", 48)}, 5600, "netntlmv2"},
+		{Credentials{Mechanism: "NTLM", Challenge: "1122334455667788", Response: ":"}, 0, ""}, // anonymous
+		{Credentials{Mechanism: "PLAIN", Authcid: "joe", Secret: "secret"}, 0, ""},
+	}
+	for _, tt := range tests {
+		mode, _, ok := tt.creds.Hashcat()
+		if ok != (tt.hashcat != 0) || mode != tt.hashcat {
+			t.Errorf("%s hashcat: %d %v, wait %d", tt.creds.Mechanism, mode, ok, tt.hashcat)
+		}
+		format, _, ok := tt.creds.John()
+		if ok != (tt.john != "") || format != tt.john {
+			t.Errorf("%s john: %q %v, wait %q", tt.creds.Mechanism, format, ok, tt.john)
+		}
+		if captured := tt.creds.Mechanism != "PLAIN"; tt.creds.Captured() != captured {
+			t.Errorf("%s captured: %v", tt.creds.Mechanism, !captured)
+		}
+	}
+}
@@ -0,0 +1,703 @@
+package smtp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"imap-honey/core"
+	"imap-honey/event"
+	"imap-honey/persona"
+	"imap-honey/sasl"
+	"imap-honey/spool"
+)
+
+type Client struct {
+	socket net.Conn
+	reader *bufio.Reader
+}
+
+func (client *Client) Send(msg string) {
+	client.socket.Write([]byte(msg + "\r\n"))
+}
+
+func (client *Client) Read() string {
+	for {
+		message, err := client.reader.ReadString('\n')
+		if err != nil {
+			client.socket.Close()
+			return ""
+		}
+		return string(message)
+	}
+}
+
+func NewClient(addr string) (*Client, string) {
+	connection, err := net.Dial("tcp", addr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	client := &Client{socket: connection, reader: bufio.NewReader(connection)}
+	hello := client.Read()
+	return client, hello
+}
+
+func TestMail(t *testing.T) {
+	var listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		{"EHLO truc", "250-localhost"},
+		{"MAIL FROM: <test@example.org>", "250 Recipient ok"},
+		{"RCPT TO: Some One <to@example.org>", "550 <to@example.org>... Denied due to spam list"},
+	}
+
+	s := NewServer("localhost", ":1993",
+		"", "", false,
+		false, false, false)
+	//s.SetDebug(true)
+	//s.SetQuiet(false)
+
+	//u := strings.ReplaceAll(capFlag, ";", "\r\n")
+	//s.SetCapability(u)
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+
+	go Serve(s)
+
+	client, hello := NewClient("localhost:1993")
+	println("hello from server : ", hello)
+
+	for _, tt := range listTests {
+		println("write to server: ", tt.message)
+
+		client.Send(tt.message)
+		reply := client.Read()
+
+		println(" wait from server: ", tt.response)
+		println("reply from server: ", reply)
+
+		if strings.TrimSuffix(reply, "\r\n") != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+	}
+
+}
+
+func TestAuth(t *testing.T) {
+	var listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		{"EHLO truc", "250-localhost"},
+		{"AUTH LOGIN", "334 VXNlcm5hbWU6"},
+		{"YWRtaW4=", "334 UGFzc3dvcmQ6"},
+		{"YWRtaW4=", "535 5.7.0 Error: authentication failed"},
+	}
+
+	s := NewServer("localhost", ":1994",
+		"", "", false,
+		true, false, false)
+	//s.SetDebug(true)
+	//s.SetQuiet(false)
+
+	//u := strings.ReplaceAll(capFlag, ";", "\r\n")
+	//s.SetCapability(u)
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+
+	go Serve(s)
+
+	client, hello := NewClient("localhost:1994")
+	println("hello from server : ", hello)
+
+	for _, tt := range listTests {
+		println("write to server: ", tt.message)
+
+		client.Send(tt.message)
+		reply := client.Read()
+
+		println(" wait from server: ", tt.response)
+		println("reply from server: ", reply)
+
+		if strings.TrimSuffix(reply, "\r\n") != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+	}
+
+}
+
+func TestErrors(t *testing.T) {
+	var listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		{"HELO", "250-localhost"},
+		{"EHLO", "250-localhost"},
+		{"MAIL FROM: ", "250 Recipient ok"},
+		{"RCPT TO: ", "550 <>... Denied due to spam list"},
+	}
+
+	s := NewServer("localhost", ":1995",
+		"", "", false,
+		false, false, false)
+	//s.SetDebug(true)
+	//s.SetQuiet(false)
+
+	//u := strings.ReplaceAll(capFlag, ";", "\r\n")
+	//s.SetCapability(u)
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+
+	go Serve(s)
+
+	client, hello := NewClient("localhost:1995")
+	println("hello from server : ", hello)
+
+	for _, tt := range listTests {
+		println("write to server: ", tt.message)
+
+		client.Send(tt.message)
+		reply := client.Read()
+
+		println(" wait from server: ", tt.response)
+		println("reply from server: ", reply)
+
+		if strings.TrimSuffix(reply, "\r\n") != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+	}
+
+}
+func TestErrors2(t *testing.T) {
+	var listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		{"HELO", "250-localhost"},
+		{"RCPT TO ", "221 2.0.0 Bye"},
+		{"MAIL FROM", ""},
+	}
+
+	s := NewServer("localhost", ":1996",
+		"", "", false,
+		false, false, false)
+	//s.SetDebug(true)
+	//s.SetQuiet(false)
+
+	//u := strings.ReplaceAll(capFlag, ";", "\r\n")
+	//s.SetCapability(u)
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+
+	go Serve(s)
+
+	client, hello := NewClient("localhost:1996")
+	println("hello from server : ", hello)
+
+	for _, tt := range listTests {
+		println("write to server: ", tt.message)
+
+		client.Send(tt.message)
+		reply := client.Read()
+
+		println(" wait from server: ", tt.response)
+		println("reply from server: ", reply)
+
+		if strings.TrimSuffix(reply, "\r\n") != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+	}
+
+}
+
+func TestData(t *testing.T) {
+	var listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		{"EHLO truc", "250-localhost"},
+		{"DATA", "503 5.5.1 Error: need RCPT command"},
+		{"MAIL FROM: <test@example.org> SIZE=100", "250 Recipient ok"},
+		{"RCPT TO: <to@example.org>", "250 Sender ok"},
+		{"DATA", "354 Enter mail, end with \".\" on a line by itself"},
+		{"Subject: test\r\n\r\nQUIT\r\nDATA\r\n..hidden\r\n.", "250 2.0.0 Ok: queued"},
+		{"MAIL FROM: <test@example.org>", "250 Recipient ok"},
+		{"RCPT TO: <to@example.org>", "250 Sender ok"},
+		{"DATA", "354 Enter mail, end with \".\" on a line by itself"},
+		{"Subject: hi\nX-Second: line\r\nBody\r\n.", "250 2.0.0 Ok: queued"}, // bare LF
+		{"MAIL FROM: <test@example.org> SIZE=100000", "552 5.3.4 Message size exceeds fixed limit"},
+		{"MAIL FROM: <test@example.org>", "250 Recipient ok"},
+		{"RCPT TO: <to@example.org>", "250 Sender ok"},
+		{"DATA", "354 Enter mail, end with \".\" on a line by itself"},
+		{strings.Repeat("0123456789", 200) + "\r\n.", "552 5.3.4 Error: message file too big"},
+		{"QUIT", "221 2.0.0 Bye"},
+	}
+
+	s := NewServer("localhost", "127.0.0.1:0",
+		"", "", false,
+		false, true, false)
+	s.SetQuiet(true)
+	s.SetCapability("250-localhost\r\n250-SIZE 1024\r\n250 8BITMIME\r\n")
+	dir := t.TempDir()
+	sp, _ := spool.New(dir)
+	s.SetSpool(sp)
+	rec := &recorder{}
+	s.Subscribe(rec)
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+
+	go Serve(s)
+
+	client, hello := NewClient(s.Addr().String())
+	println("hello from server : ", hello)
+
+	for _, tt := range listTests {
+		println("write to server: ", tt.message)
+
+		client.Send(tt.message)
+		reply := client.Read()
+
+		if strings.TrimSuffix(reply, "\r\n") != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+		for strings.HasPrefix(reply, "250-") { // skip EHLO capabilities
+			reply = client.Read()
+		}
+	}
+
+	eml, _ := filepath.Glob(filepath.Join(dir, "*/*/*/*.eml"))
+	if len(eml) != 2 {
+		t.Fatalf("wait 2 stored messages, receive %v", eml)
+	}
+	const payload = "Subject: test\r\n\r\nQUIT\r\nDATA\r\n.hidden\r\n"
+	const bareLF = "Subject: hi\r\nX-Second: line\r\nBody\r\n"
+	var b []byte
+	for _, path := range eml {
+		stored, _ := os.ReadFile(path)
+		switch string(stored) {
+		case payload:
+			b = stored
+		case bareLF:
+		default:
+			t.Errorf("bad stored message %q", stored)
+		}
+	}
+	// commands in the body are data, dots are unstuffed, the final dot dropped
+	if !strings.Contains(string(b), "\r\nQUIT\r\nDATA\r\n") || strings.Contains(string(b), "..hidden") || strings.HasSuffix(string(b), ".\r\n") {
+		t.Errorf("bad payload %q", b)
+	}
+
+	time.Sleep(100 * time.Millisecond) // the session goroutine logs after replying
+	sums := map[string]int{}
+	for _, m := range []string{payload, bareLF} {
+		sum := sha256.Sum256([]byte(m))
+		sums[hex.EncodeToString(sum[:])] = len(m)
+	}
+	var messages, stored int
+	rec.Lock()
+	defer rec.Unlock()
+	for _, ev := range rec.events {
+		switch ev.Type {
+		case event.Message:
+			messages++
+			if size, ok := ev.Data["size"]; ok && size != len(payload) && size != len(bareLF) {
+				t.Errorf("message event size %v", size)
+			}
+		case event.Stored:
+			stored++
+			if _, ok := sums[ev.Data["sha256"].(string)]; !ok {
+				t.Errorf("stored event %v", ev.Data)
+			}
+		}
+	}
+	if messages != 3 || stored != 2 {
+		t.Errorf("%d message and %d stored events, wait 3 and 2", messages, stored)
+	}
+}
+
+func TestSASL(t *testing.T) {
+	var listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		{"EHLO truc", "250-localhost"},
+		{"AUTH", "501 5.5.4 Syntax: AUTH mechanism"},
+		{"AUTH GSSAPI", "504 5.5.4 Unrecognized authentication type"},
+		{"AUTH LOGIN", "334 VXNlcm5hbWU6"},
+		{"*", "501 5.7.0 Authentication aborted"},
+		{"AUTH NTLM TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKAGFKAAAADw==", "334 TlRMTVNTUAACAAAA..."},
+		{"*", "501 5.7.0 Authentication aborted"},
+		{"AUTH PLAIN AGpvZQBzZWNyZXQ=", "235 2.7.0 Authentication successful"},
+		{"QUIT", "221 2.0.0 Bye"},
+	}
+
+	rec := &recorder{}
+	s := NewServer("localhost", "127.0.0.1:0",
+		"", "", false,
+		true, false, true)
+	s.SetLogger(&event.Logger{Quiet: true, Outputs: []event.Output{rec}})
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+	go Serve(s)
+	defer s.Close()
+
+	client, _ := NewClient(s.Addr().String())
+	for _, tt := range listTests {
+		client.Send(tt.message)
+		reply := strings.TrimSuffix(client.Read(), "\r\n")
+		if prefix := strings.TrimSuffix(tt.response, "..."); prefix != tt.response && strings.HasPrefix(reply, prefix) {
+			continue
+		}
+		if reply != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+	}
+
+	rec.Lock()
+	defer rec.Unlock()
+	for _, ev := range rec.events {
+		if ev.Type == event.Login {
+			if ev.Username != "joe" || ev.Password != "secret" || ev.Data["mechanism"] != "PLAIN" || ev.Data["accepted"] != true {
+				t.Errorf("login event: %+v", ev)
+			}
+			return
+		}
+	}
+	t.Errorf("no login event")
+}
+
+// ReadReply reads a multiline reply
+func (client *Client) ReadReply() []string {
+	var lines []string
+	for {
+		l := client.Read()
+		if l == "" {
+			return lines
+		}
+		lines = append(lines, strings.TrimSuffix(l, "\r\n"))
+		if len(l) < 4 || l[3] != '-' {
+			return lines
+		}
+	}
+}
+
+type recorder struct {
+	sync.Mutex
+	events []*event.Event
+}
+
+func (r *recorder) Log(ev *event.Event) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func TestStartTLS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour),
+		DNSNames: []string{"localhost"}}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	k, _ := x509.MarshalECPrivateKey(key)
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: k}), 0o600)
+
+	rec := &recorder{}
+	s := NewServer("localhost", "127.0.0.1:0",
+		certPath, keyPath, false,
+		false, true, false)
+	s.SetLogger(&event.Logger{Quiet: true, Outputs: []event.Output{rec}})
+	s.SetDebug(true)
+	s.SetStartTLS(true)
+	s.SetCapability("250-localhost\r\n250-SIZE 1024\r\n250 8BITMIME\r\n")
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+	go Serve(s)
+	defer s.Close()
+
+	var listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		{"EHLO truc", "250-localhost|250-STARTTLS|250-SIZE 1024|250 8BITMIME"},
+		{"MAIL FROM: <before@example.org>", "250 Recipient ok"},
+		{"STARTTLS now", "501 5.5.4 Syntax: STARTTLS"},
+		{"STARTTLS\r\nRCPT TO: <injected@example.org>", "220 2.0.0 Ready to start TLS"},
+	}
+	client, _ := NewClient(s.Addr().String())
+	for _, tt := range listTests {
+		client.Send(tt.message)
+		if reply := strings.Join(client.ReadReply(), "|"); reply != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+	}
+
+	conn := tls.Client(client.socket, &tls.Config{InsecureSkipVerify: true})
+	if err := conn.Handshake(); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	client = &Client{socket: conn, reader: bufio.NewReader(conn)}
+	listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		{"EHLO truc", "250-localhost|250-SIZE 1024|250 8BITMIME"},
+		{"STARTTLS", "554 5.5.1 Error: TLS already active"},
+		{"DATA", "503 5.5.1 Error: need RCPT command"},
+		{"QUIT", "221 2.0.0 Bye"},
+	}
+	for _, tt := range listTests {
+		client.Send(tt.message)
+		if reply := strings.Join(client.ReadReply(), "|"); reply != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+	}
+
+	rec.Lock()
+	defer rec.Unlock()
+	for _, ev := range rec.events {
+		if ev.Command == "DATA" && (ev.TLS == nil || ev.TLS.Version == "") {
+			t.Errorf("no TLS details in event after STARTTLS: %+v", ev)
+		}
+		if strings.Contains(ev.Command, "injected") {
+			t.Errorf("command pipelined after STARTTLS was read: %q", ev.Command)
+		}
+	}
+}
+
+func TestPersona(t *testing.T) {
+	var listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		{"EHLO truc", "250-mx.example.com"},
+		{"VRFY root", "502 5.5.2 Error: command not recognized"},
+		{"AUTH PLAIN AGpvZQBzZWNyZXQ=", "503 5.5.1 Error: authentication not enabled"},
+		{"MAIL FROM: <a@example.com>", "250 2.1.0 Ok"},
+		{"RSET", "250 2.0.0 Ok"},
+		{"QUIT", "221 2.0.0 Bye"},
+	}
+
+	s := NewServer("mx.example.com", "127.0.0.1:0",
+		"", "", false,
+		false, false, false)
+	p, _ := persona.Get("smtp", "postfix")
+	s.SetPersona(p)
+
+	e := Listen(s)
+	if e != nil {
+		fmt.Printf("Listen() ERROR: %v\n", e)
+		return
+	}
+	go Serve(s)
+	defer s.Close()
+
+	client, hello := NewClient(s.Addr().String())
+	if hello != "220 mx.example.com ESMTP Postfix (Ubuntu)\r\n" {
+		t.Errorf("greeting %q", hello)
+	}
+	for _, tt := range listTests {
+		client.Send(tt.message)
+		reply := strings.TrimSuffix(client.Read(), "\r\n")
+		if tt.message == "EHLO truc" {
+			lines := append([]string{reply}, client.ReadReply()...)
+			if lines[len(lines)-1] != "250 SMTPUTF8" {
+				t.Errorf("EHLO %q", lines)
+			}
+		}
+		if reply != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	logins := make(chan *sasl.Credentials, 4)
+	closed := make(chan *event.Event, 1)
+	pers, _ := persona.Get("smtp", "postfix")
+	p := *pers
+	p.GreetingDelay, p.LoginDelay, p.FailDelay = 0, 0, 0
+	s, err := New("127.0.0.1:0",
+		WithHostname("mx.example.com"),
+		WithPersona(&p),
+		WithAuth(false),
+		WithSubscriber(core.Credentials(func(ev *event.Event, c *sasl.Credentials) { logins <- c })),
+		WithSubscriber(core.Sessions(func(ev *event.Event) { closed <- ev })))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go s.Serve()
+	defer s.Close()
+
+	var listTests = []struct {
+		message  string // input
+		response string // expected result
+	}{
+		{"HELO truc", "250 mx.example.com"},
+		{"AUTH PLAIN AGpvZQBzZWNyZXQ=", "535 5.7.8 Error: authentication failed: authentication failure"},
+		{"QUIT", "221 2.0.0 Bye"},
+	}
+	client, hello := NewClient(s.Addr().String())
+	if hello != "220 mx.example.com ESMTP Postfix (Ubuntu)\r\n" {
+		t.Errorf("greeting %q", hello)
+	}
+	for _, tt := range listTests {
+		client.Send(tt.message)
+		reply := strings.TrimSuffix(client.Read(), "\r\n")
+		if reply != tt.response {
+			t.Errorf("send: \"%s\"\n wait: \"%s\"\n receive: \"%s\"\n", tt.message, tt.response, reply)
+		}
+	}
+	select {
+	case c := <-logins:
+		if c.Mechanism != "PLAIN" || c.Authcid != "joe" || c.Secret != "secret" {
+			t.Errorf("credentials %+v", c)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no credentials")
+	}
+	select {
+	case ev := <-closed:
+		if ev.Data["reason"] != event.ClientQuit {
+			t.Errorf("close reason %v", ev.Data["reason"])
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no session close")
+	}
+
+	if _, err := New("127.0.0.1:0", WithTLS(nil)); err == nil {
+		t.Errorf("New: no error without a TLS configuration")
+	}
+	if _, err := New("127.0.0.1:0", WithCapability("250 {{.Nope}}")); err == nil {
+		t.Errorf("New: no error with a bad capability")
+	}
+}
+
+func TestReadData(t *testing.T) {
+	long := strings.Repeat("x", 15) // fills the 16 byte buffer up to the CR
+	var listTests = []struct {
+		data string // input, up to the terminator
+		want string // stored
+		max  int
+		err  error
+	}{
+		{"a\r\nb\r\n.\r\n", "a\r\nb\r\n", 0, nil},
+		{"a\nb\n.\n", "a\r\nb\r\n", 0, nil},
+		{long + "\r\nb\r\n.\r\n", long + "\r\nb\r\n", 0, nil},
+		{long + "\rb\r\n.\r\n", long + "\rb\r\n", 0, nil},
+		{strings.Repeat("y", 40) + "\r\n..z\r\n.\r\n", strings.Repeat("y", 40) + "\r\n.z\r\n", 0, nil},
+		{strings.Repeat("y", 40) + "\r\n.\r\n", "", 20, ErrTooBig},
+	}
+	for _, tt := range listTests {
+		sess := &Session{reader: bufio.NewReaderSize(strings.NewReader(tt.data+"NEXT\r\n"), 16)}
+		b, err := sess.ReadData(tt.max)
+		if string(b) != tt.want || err != tt.err {
+			t.Errorf("data %q: receive %q %v, wait %q", tt.data, b, err, tt.want)
+		}
+		if next, _ := sess.reader.ReadString('\n'); next != "NEXT\r\n" {
+			t.Errorf("data %q: next line %q", tt.data, next)
+		}
+	}
+}
+
+func TestCapability(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	var listTests = []struct {
+		capability string // EHLO reply
+		response   string // expected with STARTTLS offered
+	}{
+		{"250-localhost\r\n", "250-localhost\r\n250 STARTTLS\r\n"},
+		{"250 localhost\r\n", "250-localhost\r\n250 STARTTLS\r\n"},
+		{"250 ok", "250-ok\r\n250 STARTTLS\r\n"},
+		{"250-mx\r\n250 8BITMIME\r\n", "250-mx\r\n250-STARTTLS\r\n250 8BITMIME\r\n"},
+		{"ok\r\n250 8BITMIME\r\n", "ok\r\n250-STARTTLS\r\n250 8BITMIME\r\n"},
+		{"x", "250-x\r\n250 STARTTLS\r\n"},
+	}
+	for _, tt := range listTests {
+		s, _ := New("127.0.0.1:0")
+		s.SetCapability(tt.capability)
+		s.startTLS = true
+		sess := NewSession(s, c1, nil, nil)
+		if c := sess.Capability(); c != tt.response {
+			t.Errorf("capability %q\n wait: %q\n receive: %q\n", tt.capability, tt.response, c)
+		}
+	}
+
+	for _, c := range []string{"ok", "250-mx\r\n8BITMIME", "250"} {
+		if _, err := New("127.0.0.1:0", WithCapability(c)); err == nil {
+			t.Errorf("WithCapability(%q): no error", c)
+		}
+	}
+}
+
+func TestReadline(t *testing.T) {
+	var listTests = []struct {
+		data string // client input
+		line string // expected line
+		err  error
+	}{
+		{"DONE\r\nNEXT\r\n", "DONE\r\n", nil},
+		{strings.Repeat("A", maxLine-2) + "\r\nNEXT\r\n", strings.Repeat("A", maxLine-2) + "\r\n", nil},
+		{strings.Repeat("A", maxLine) + "\r\n", "", ErrLineTooLong},
+		{"DONE", "DONE", io.EOF},
+	}
+	for _, tt := range listTests {
+		sess := &Session{reader: bufio.NewReaderSize(strings.NewReader(tt.data), 16)}
+		line, err := sess.Readline()
+		if line != tt.line || err != tt.err {
+			t.Errorf("read %.20q\n wait: %.20q, %v\n receive: %.20q, %v\n", tt.data, tt.line, tt.err, line, err)
+		}
+	}
+}
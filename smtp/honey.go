@@ -0,0 +1,763 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"imap-honey/core"
+	"imap-honey/event"
+	"imap-honey/persona"
+	"imap-honey/sasl"
+	"imap-honey/spool"
+)
+
+type Server struct {
+	debug       bool
+	addr        string
+	hostname    string
+	capability  string
+	listener    net.Listener
+	closed      bool
+	withTLS     bool
+	startTLS    bool // STARTTLS on a plaintext listener
+	logAuth     bool
+	logData     bool
+	authOK      bool
+	maxSize     int // advertised SIZE, 0 for no limit
+	tlsConfig   *tls.Config
+	spool       *spool.Spool
+	logger      *event.Logger
+	ntlmDomain  string // NetBIOS domain in NTLM challenges
+	ntlmDNS     string
+	persona     *persona.Persona
+	subscribers core.Subscribers
+}
+
+func (server *Server) IsDebug() bool {
+	return server.debug
+}
+func (server *Server) SetDebug(d bool) {
+	server.debug = d
+}
+func (server *Server) IsQuiet() bool {
+	return server.logger.Quiet
+}
+func (server *Server) SetQuiet(q bool) {
+	server.logger.Quiet = q
+}
+func (server *Server) SetLogger(l *event.Logger) {
+	server.logger = l
+}
+
+// Subscribe passes every event to s after the logger
+func (server *Server) Subscribe(s core.Subscriber) {
+	server.subscribers = append(server.subscribers, s)
+}
+
+func (server *Server) SetCapability(s string) {
+	server.capability = s
+	server.maxSize = 0
+	for _, l := range strings.Split(s, "\r\n") {
+		if len(l) > 4 && strings.HasPrefix(strings.ToUpper(l[4:]), "SIZE ") {
+			server.maxSize, _ = strconv.Atoi(strings.TrimSpace(l[9:]))
+		}
+	}
+}
+
+// SetPersona switches the replies, EHLO and timing to those of a server
+// product, -cap is to be set afterwards
+func (server *Server) SetPersona(p *persona.Persona) {
+	server.persona = p
+	server.capability = p.Capability
+}
+
+// SetNTLMDomain sets the domain names sent in NTLM challenges, they are
+// derived from the hostname when empty
+func (server *Server) SetNTLMDomain(domain string, dnsDomain string) {
+	server.ntlmDomain, server.ntlmDNS = domain, dnsDomain
+}
+func (server *Server) SASL() *sasl.Config {
+	return &sasl.Config{Hostname: server.hostname, Domain: server.ntlmDomain, DNSDomain: server.ntlmDNS}
+}
+func (server *Server) SetStartTLS(ok bool) {
+	server.startTLS = ok
+}
+func (server *Server) SetSpool(sp *spool.Spool) {
+	server.spool = sp
+}
+func (server *Server) Closed() bool { return server.closed }
+func (server *Server) Close() {
+	server.closed = true
+	server.listener.Close()
+}
+
+// NewServer loads the certificate of a TLS listener, nil if it fails. See
+// New and core.LoadTLS for the options and errors of a server embedded in
+// another program.
+func NewServer(hostname string, addr string, certPath string, keyPath string, withTLS bool, logAuth bool, logData bool, authOK bool) *Server {
+	var tlsConfig *tls.Config
+	if withTLS || (certPath != "" && keyPath != "") {
+		var err error
+		if tlsConfig, err = core.LoadTLS(certPath, keyPath); err != nil {
+			return nil
+		}
+
+	}
+
+	//server := &Server{false, false, addr, hostname, "250-localhost\r\n", nil, false, withTLS, logAuth, logData, authOK, tlsConfig}
+	server := &Server{
+		debug:      false,
+		addr:       addr,
+		hostname:   hostname,
+		capability: "250-localhost\r\n",
+		listener:   nil,
+		closed:     false,
+		withTLS:    withTLS,
+		logAuth:    logAuth,
+		logData:    logData,
+		authOK:     authOK,
+		tlsConfig:  tlsConfig,
+		logger:     &event.Logger{},
+		persona:    persona.Default("smtp"),
+	}
+	return server
+}
+
+// Session
+
+const (
+	// commandTimeout is how long a client may take to send a command
+	commandTimeout = 3 * time.Minute
+	// dataTimeout bounds the DATA block, the RFC 5321 termination timeout
+	dataTimeout = 10 * time.Minute
+	// writeTimeout is how long a reply may wait on a client not reading
+	writeTimeout = time.Minute
+	// maxLine is the AUTH line RFC 4954 asks to accept, commands are shorter
+	maxLine = 12288
+)
+
+type Session struct {
+	server  *Server
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	summary *event.Summary
+	// Stateful stuff
+	state    int
+	username string
+	helo     string
+	from     string
+	to       []string
+	errors   int // errors counted against the persona limit
+}
+
+func NewSession(
+	server *Server, conn net.Conn,
+	reader *bufio.Reader, writer *bufio.Writer,
+) *Session {
+	s := &Session{server: server, conn: conn, reader: reader, writer: writer,
+		summary: event.NewSummary()}
+	return s
+}
+func (sess *Session) Sendf(format string, args ...interface{}) {
+	fmt.Fprintf(sess.writer, format, args...)
+	sess.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	sess.writer.Flush()
+}
+
+// SetTimeout gives the client d to send what the session reads next
+func (sess *Session) SetTimeout(d time.Duration) {
+	sess.conn.SetReadDeadline(time.Now().Add(d))
+}
+
+// Readline reads a line of at most maxLine bytes, CRLF included, as SASL
+// responses and commands
+func (sess *Session) Readline() (string, error) {
+	var line []byte
+	for {
+		l, err := sess.reader.ReadSlice('\n')
+		if len(line)+len(l) > maxLine {
+			return "", ErrLineTooLong
+		}
+		line = append(line, l...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+func (sess *Session) SetUsername(username string) {
+	sess.username = username
+}
+func (sess *Session) GetUsername() string {
+	return sess.username
+}
+func (sess *Session) IsTLS() bool {
+	_, ok := sess.conn.(*tls.Conn)
+	return ok
+}
+
+// Capability is the EHLO response, with STARTTLS until TLS is active
+func (sess *Session) Capability() string {
+	c, err := persona.Render(sess.server.capability, sess.vars(nil))
+	if err != nil {
+		sess.Error(err)
+	}
+	if !sess.server.startTLS || sess.IsTLS() {
+		return c
+	}
+	sp := strings.SplitN(c, "\r\n", 2)
+	if len(sp) < 2 || sp[1] == "" || strings.HasPrefix(sp[0], "250 ") {
+		first := strings.TrimPrefix(strings.TrimPrefix(sp[0], "250 "), "250-")
+		return "250-" + first + "\r\n250 STARTTLS\r\n"
+	}
+	return sp[0] + "\r\n250-STARTTLS\r\n" + sp[1]
+}
+
+// StartTLS upgrades the connection and forgets what the client said
+// before, as RFC 3207 requires
+func (sess *Session) StartTLS() error {
+	conn := tls.Server(sess.conn, sess.server.tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	sess.conn = conn
+	sess.reader = bufio.NewReader(sess.summary.Reader(conn))
+	sess.writer = bufio.NewWriter(sess.summary.Writer(conn))
+	sess.Reset()
+	sess.helo, sess.username = "", ""
+	return nil
+}
+func (sess *Session) TLSInfo() *event.TLS {
+	if c, ok := sess.conn.(*tls.Conn); ok {
+		return event.NewTLS(c.ConnectionState())
+	}
+	return nil
+}
+func (sess *Session) Reset() {
+	sess.from = ""
+	sess.to = nil
+}
+
+var (
+	ErrTooBig      = errors.New("message exceeds fixed maximum message size")
+	ErrLineTooLong = errors.New("line too long")
+)
+
+// ReadData reads a DATA payload up to the "." terminator and removes
+// dot-stuffing. Lines are kept with CRLF endings. When max is reached the
+// rest of the payload is consumed and ErrTooBig is returned.
+func (sess *Session) ReadData(max int) ([]byte, error) {
+	var buf bytes.Buffer
+	tooBig := false
+	write := func(p []byte) {
+		if tooBig {
+			return
+		}
+		if max > 0 && buf.Len()+len(p) > max {
+			tooBig = true
+			buf.Reset()
+			return
+		}
+		buf.Write(p)
+	}
+	bol := true // at beginning of line
+	cr := false // the previous chunk of the line ended with CR
+	for {
+		// l is bufio's buffer, never appended to
+		l, e := sess.reader.ReadSlice('\n')
+		if e != nil && e != bufio.ErrBufferFull {
+			return nil, e
+		}
+		eol := e == nil
+		if bol && eol && strings.TrimRight(string(l), "\r\n") == "." {
+			break
+		}
+		if bol && len(l) > 0 && l[0] == '.' {
+			l = l[1:]
+		}
+		bol = eol
+		if cr && !(eol && len(l) == 1) { // a CR not followed by LF is data
+			write([]byte{'\r'})
+		}
+		cr = false
+		if eol {
+			write(bytes.TrimRight(l, "\r\n"))
+			write([]byte("\r\n"))
+			continue
+		}
+		if n := len(l); n > 0 && l[n-1] == '\r' {
+			l, cr = l[:n-1], true
+		}
+		write(l)
+	}
+	if tooBig {
+		return nil, ErrTooBig
+	}
+	return buf.Bytes(), nil
+}
+func (sess *Session) RemoteIP() string {
+	s := sess.conn.RemoteAddr().String()
+	ip, _, _ := net.SplitHostPort(s)
+	return ip
+}
+
+// Event returns a new event for this session, text is the legacy log line
+func (sess *Session) Event(typ string, text string) *event.Event {
+	ev := event.New(typ, "smtp", sess.conn)
+	ev.Text = text
+	return ev
+}
+func (sess *Session) Emit(ev *event.Event) {
+	ev.Session = sess.summary.ID
+	sess.summary.Record(ev)
+	sess.server.logger.Log(ev)
+	sess.server.subscribers.Log(ev)
+}
+
+// Summarize emits the session summary
+func (sess *Session) Summarize(reason string) {
+	sess.Emit(sess.summary.Close(sess.Event(event.Close, ""), reason))
+}
+
+// Command
+
+type Command struct {
+	Command   string
+	Arguments string
+	Size      int // SIZE= parameter of MAIL FROM
+}
+
+// Message
+
+type Message struct {
+	Helo     string
+	From     string
+	To       []string
+	Data     []byte
+	Received time.Time
+}
+
+func (msg *Message) String() string {
+	return fmt.Sprintf("HELO: %s, MAIL FROM: %s, RCPT TO: %s, SIZE: %d, DATA: %q",
+		msg.Helo, msg.From, strings.Join(msg.To, ", "), len(msg.Data), msg.Data)
+}
+
+// Store writes msg to the server spool, if any
+func (sess *Session) Store(msg *Message) {
+	core.Store(sess, sess.server.spool, &spool.Meta{
+		SessionID:    sess.summary.ID,
+		Protocol:     "smtp",
+		RemoteIP:     sess.RemoteIP(),
+		Helo:         msg.Helo,
+		MailFrom:     msg.From,
+		RcptTo:       msg.To,
+		Username:     sess.username,
+		TLS:          sess.TLSInfo(),
+		SessionStart: sess.summary.Start,
+		Received:     msg.Received,
+	}, msg.Data)
+}
+
+// Extract logs the MIME parts and indicators of msg
+func (sess *Session) Extract(msg *Message) {
+	core.Extract(sess, sess.server.spool, msg.Data)
+}
+
+func cleanMail(mails string) string {
+	emails, _ := mail.ParseAddressList(mails)
+	var u []string
+	for _, v := range emails {
+		u = append(u, v.Address)
+	}
+	return strings.Join(u, ", ")
+}
+
+// stripParams removes ESMTP parameters following "<address>"
+func stripParams(s string) string {
+	if i := strings.Index(s, ">"); i >= 0 {
+		return s[:i+1]
+	}
+	return s
+}
+
+func sizeParam(s string) int {
+	for _, p := range strings.Fields(s) {
+		if strings.HasPrefix(strings.ToUpper(p), "SIZE=") {
+			n, _ := strconv.Atoi(p[5:])
+			return n
+		}
+	}
+	return 0
+}
+
+func ParseCommand(s string) (*Command, error) {
+
+	command := &Command{}
+
+	matched, _ := regexp.MatchString(`^\.$`, s)
+	switch {
+	case strings.HasPrefix(strings.ToUpper(s), "AUTH"):
+		sp := strings.SplitN(s, " ", 2)
+		command.Command = "AUTH"
+		if len(sp) > 1 {
+			command.Arguments = strings.TrimSpace(sp[1])
+		}
+	case strings.Contains(s, "EHLO"):
+		sp := strings.Split(s, " ")
+		command.Command = "EHLO"
+		if len(sp) > 1 {
+			command.Arguments = sp[1]
+		}
+	case strings.Contains(s, "HELO"):
+		sp := strings.Split(s, " ")
+		command.Command = "HELO"
+		if len(sp) > 1 {
+			command.Arguments = sp[1]
+		}
+	case strings.Contains(s, "DATA"):
+		command.Command = "DATA"
+	case matched:
+		command.Command = "END"
+	case strings.Contains(s, "QUIT"):
+		command.Command = "QUIT"
+	case strings.Contains(s, "MAIL FROM"):
+		sp := strings.SplitN(s, ":", 2)
+		command.Command = "QUIT"
+		if len(sp) > 1 {
+			command.Command = "MAIL"
+			command.Arguments = cleanMail(stripParams(sp[1]))
+			command.Size = sizeParam(sp[1])
+		}
+	case strings.Contains(s, "RCPT TO"):
+		sp := strings.SplitN(s, ":", 2)
+		command.Command = "QUIT"
+		if len(sp) > 1 {
+			command.Command = "TO"
+			command.Arguments = cleanMail(stripParams(sp[1]))
+		}
+	case strings.Contains(s, "STARTTLS"):
+		sp := strings.SplitN(strings.TrimSpace(s), " ", 2)
+		command.Command = "STARTTLS"
+		if len(sp) > 1 {
+			command.Arguments = sp[1]
+		}
+	default:
+		command.Command = s
+	}
+	return command, nil
+}
+
+// vars are the persona template variables answering command, without
+// the capability
+func (sess *Session) vars(command *Command) *persona.Vars {
+	v := &persona.Vars{
+		Hostname: sess.server.hostname,
+		ClientIP: sess.RemoteIP(),
+		Session:  sess.summary.ID,
+		Username: sess.username,
+		Helo:     sess.helo,
+		Date:     time.Now(),
+	}
+	if command != nil {
+		v.Command, v.Address = command.Command, command.Arguments
+		switch command.Command {
+		case "AUTH":
+			v.Mechanism = strings.SplitN(command.Arguments, " ", 2)[0]
+		case "MAIL":
+			v.Address = sess.from
+		}
+	}
+	return v
+}
+
+// Reply sends the persona response id
+func (sess *Session) Reply(id string, command *Command) {
+	v := sess.vars(command)
+	v.Capability = sess.Capability()
+	sess.send(sess.server.persona.Reply(id, v))
+}
+
+// send writes a persona reply, template errors are logged
+func (sess *Session) send(reply string, err error) {
+	if err != nil {
+		sess.Error(err)
+	}
+	sess.Sendf("%s", reply)
+}
+
+// Error logs a server side error of the session
+func (sess *Session) Error(err error) {
+	sess.Emit(sess.Event(event.Error, fmt.Sprintf("IP: %s, ERROR: %v", sess.RemoteIP(), err)).
+		Set("error", err.Error()))
+}
+
+// fail answers an error that ends the session with the default persona,
+// false once the persona error limit is reached
+func (sess *Session) fail(id string, command *Command) bool {
+	sess.Reply(id, command)
+	sess.errors++
+	if p := sess.server.persona; p.MaxErrors > 0 && sess.errors >= p.MaxErrors {
+		sess.Reply("too_many_errors", command)
+		return false
+	}
+	return true
+}
+
+func handle_session(sess *Session) error {
+	if sess.server.IsDebug() {
+		sess.Emit(sess.Event(event.Open, fmt.Sprintf("IP: %s, OPENED", sess.RemoteIP())))
+	}
+
+	// Send greeting
+	time.Sleep(sess.server.persona.GreetingDelay)
+	sess.Reply("greeting", nil)
+
+	var command *Command
+	var data []byte
+	reason := event.ServerClose
+
+command:
+	sess.SetTimeout(commandTimeout)
+	s, e := sess.Readline()
+
+	if e != nil {
+		goto err
+	}
+	s = strings.TrimRight(s, "\r\n")
+	if sess.server.IsDebug() {
+		ev := sess.Event(event.Command, fmt.Sprintf("IP: %s, COMMAND: %s", sess.RemoteIP(), s))
+		ev.Command = s
+		sess.Emit(ev)
+	}
+	sess.summary.AddCommand(s)
+
+	command, e = ParseCommand(s)
+	if e != nil {
+		goto err
+	}
+
+	// Handle commands
+
+	switch command.Command {
+	case "HELO":
+		sess.helo = command.Arguments
+		sess.Reply("helo", command)
+		goto command
+	case "EHLO":
+		sess.helo = command.Arguments
+		sess.Reply("ehlo", command)
+		goto command
+	case "TO":
+		if sess.server.logData {
+			sess.to = append(sess.to, command.Arguments)
+			sess.Reply("rcpt_ok", command)
+			goto command
+		}
+		if sess.fail("rcpt_denied", command) {
+			goto command
+		}
+		goto close
+	case "MAIL":
+		if sess.server.maxSize > 0 && command.Size > sess.server.maxSize {
+			sess.Reply("mail_too_big", command)
+			goto command
+		}
+		sess.Reset()
+		sess.from = command.Arguments
+		sess.Reply("mail_ok", command)
+		goto command
+	case "DATA":
+		if len(sess.to) == 0 {
+			sess.Reply("data_need_rcpt", command)
+			goto command
+		}
+		sess.Reply("data_start", command)
+		sess.SetTimeout(dataTimeout)
+		data, e = sess.ReadData(sess.server.maxSize)
+		if e == ErrTooBig {
+			sess.Emit(sess.Event(event.Message, fmt.Sprintf("IP: %s, MAIL FROM: %s, RCPT TO: %s, DATA: too big", sess.RemoteIP(), sess.from, strings.Join(sess.to, ", "))).
+				Set("mail_from", sess.from).Set("rcpt_to", sess.to).Set("too_big", true))
+			sess.Reset()
+			sess.Reply("data_too_big", command)
+			goto command
+		}
+		if e != nil {
+			goto err
+		}
+		msg := &Message{sess.helo, sess.from, sess.to, data, time.Now()}
+		sess.Emit(sess.Event(event.Message, fmt.Sprintf("IP: %s, %s", sess.RemoteIP(), msg)).
+			Set("helo", msg.Helo).Set("mail_from", msg.From).Set("rcpt_to", msg.To).Set("size", len(msg.Data)))
+		sess.Store(msg)
+		sess.Extract(msg)
+		sess.Reset()
+		sess.Reply("queued", command)
+		goto command
+	case "END":
+		sess.Reply("end", command)
+		goto command
+	case "RSET":
+		sess.Reset()
+		sess.Reply("rset", command)
+		goto command
+	case "QUIT":
+		sess.Reply("quit", command)
+		reason = event.ClientQuit
+		goto close
+	case "AUTH":
+		if !sess.server.logAuth {
+			if sess.fail("auth_disabled", command) {
+				goto command
+			}
+			goto close
+		}
+		sp := strings.Fields(command.Arguments)
+		if len(sp) == 0 {
+			sess.Reply("auth_syntax", command)
+			goto command
+		}
+		mechanism, merr := sasl.NewServer(sp[0], sess.server.SASL())
+		if merr != nil {
+			sess.Reply("auth_unsupported", command)
+			goto command
+		}
+		var initial *string
+		if len(sp) > 1 {
+			initial = &sp[1]
+		}
+		creds, aerr := sasl.Authenticate(mechanism, initial,
+			func(challenge string) error {
+				sess.Sendf("334 %s\r\n", challenge)
+				return nil
+			},
+			func() (string, error) {
+				line, err := sess.Readline()
+				sess.summary.AddCommand(strings.TrimRight(line, "\r\n"))
+				return line, err
+			})
+		switch aerr {
+		case nil, sasl.ErrMalformed:
+		case sasl.ErrCancelled:
+			sess.Reply("auth_aborted", command)
+			goto command
+		default:
+			e = aerr
+			goto err
+		}
+		ev := creds.Log(sess.Event(event.Login, fmt.Sprintf("IP: %s, LOGIN: %s", sess.RemoteIP(), creds)))
+		if aerr != nil {
+			sess.Emit(ev.Set("malformed", true))
+			sess.Reply("auth_malformed", command)
+			goto command
+		}
+		if sess.server.authOK {
+			ev.Set("accepted", true)
+		}
+		sess.Emit(ev)
+		time.Sleep(sess.server.persona.LoginDelay)
+		if sess.server.authOK {
+			sess.SetUsername(creds.Username())
+			sess.Reply("auth_ok", command)
+			goto command
+		}
+		time.Sleep(sess.server.persona.FailDelay)
+		if sess.fail("auth_failed", command) {
+			goto command
+		}
+		goto close
+	case "STARTTLS":
+		//sess.Sendf("502 5.5.2 Error: command not recognized\r\n")
+		//goto close
+		if sess.IsTLS() {
+			sess.Reply("starttls_active", command)
+			goto command
+		}
+		if !sess.server.startTLS {
+			if sess.fail("starttls_unavailable", command) {
+				goto command
+			}
+			goto close
+		}
+		if command.Arguments != "" {
+			sess.Reply("starttls_syntax", command)
+			goto command
+		}
+		sess.Reply("starttls", command)
+		if e = sess.StartTLS(); e != nil {
+			reason = event.ProtocolError
+			goto err
+		}
+		goto command
+	default:
+		if sess.fail("unknown", command) {
+			goto command
+		}
+		goto close
+	}
+
+close:
+	sess.conn.Close()
+	sess.Summarize(reason)
+	return nil
+
+err:
+	sess.conn.Close()
+	if reason == event.ServerClose {
+		reason = event.Reason(e)
+	}
+	sess.Summarize(reason)
+	return fmt.Errorf("handle_session: %v", e)
+}
+
+// Server
+
+func Listen(server *Server) error {
+	var ln net.Listener
+	var e error
+
+	if server.withTLS {
+		ln, e = tls.Listen("tcp", server.addr, server.tlsConfig)
+	} else {
+		ln, e = net.Listen("tcp", server.addr)
+	}
+	if e != nil {
+		return e
+	} else {
+		server.listener = ln
+	}
+	return nil
+}
+
+func Serve(server *Server) error {
+	for {
+		conn, e := server.listener.Accept()
+		if e != nil {
+			if server.Closed() {
+				break
+			}
+			return e
+		}
+		go func(conn_pointer *net.Conn) {
+			conn := *conn_pointer
+			summary := event.NewSummary()
+			sess := NewSession(
+				server, conn,
+				bufio.NewReader(summary.Reader(conn)), bufio.NewWriter(summary.Writer(conn)),
+			)
+			sess.summary = summary
+
+			handle_session(sess) // the close event carries the reason
+
+		}(&conn) //goroutine
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"imap-honey/core"
+	"imap-honey/event"
+	"imap-honey/persona"
+	"imap-honey/spool"
+)
+
+// Option configures a server built by New
+type Option func(*Server) error
+
+// New builds a plaintext server on addr refusing AUTH and recipients,
+// with the default persona and a quiet logger, see the options for the
+// rest. It is started with its Listen and Serve methods.
+//
+//	server, err := smtp.New(":25",
+//		smtp.WithAuth(false),
+//		smtp.WithSubscriber(core.Credentials(record)))
+func New(addr string, opts ...Option) (*Server, error) {
+	server := NewServer("localhost", addr, "", "", false, false, false, false)
+	server.SetPersona(persona.Default("smtp"))
+	server.logger = &event.Logger{Quiet: true}
+	for _, opt := range opts {
+		if err := opt(server); err != nil {
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+// Listen opens the listener on the address given to New
+func (server *Server) Listen() error {
+	return Listen(server)
+}
+
+// Addr returns the address listened on, nil before Listen. It holds the
+// port chosen for an address like "127.0.0.1:0".
+func (server *Server) Addr() net.Addr {
+	if server.listener == nil {
+		return nil
+	}
+	return server.listener.Addr()
+}
+
+// Serve accepts connections until the server is closed
+func (server *Server) Serve() error {
+	return Serve(server)
+}
+
+// WithHostname sets the name in greetings and NTLM challenges
+func WithHostname(hostname string) Option {
+	return func(server *Server) error {
+		server.hostname = hostname
+		return nil
+	}
+}
+
+// WithTLS serves implicit TLS, as on port 465
+func WithTLS(c *tls.Config) Option {
+	return func(server *Server) error {
+		if c == nil {
+			return errors.New("WithTLS: no TLS configuration")
+		}
+		server.tlsConfig, server.withTLS = c, true
+		return nil
+	}
+}
+
+// WithStartTLS offers STARTTLS on a plaintext listener
+func WithStartTLS(c *tls.Config) Option {
+	return func(server *Server) error {
+		if c == nil {
+			return errors.New("WithStartTLS: no TLS configuration")
+		}
+		server.tlsConfig, server.startTLS = c, true
+		return nil
+	}
+}
+
+// WithPersona answers like a server product, to be given before
+// WithCapability
+func WithPersona(p *persona.Persona) Option {
+	return func(server *Server) error {
+		if p == nil || p.Protocol != "smtp" {
+			return errors.New("WithPersona: not an smtp persona")
+		}
+		server.SetPersona(p)
+		return nil
+	}
+}
+
+// WithCapability replaces the EHLO reply of the persona, lines starting
+// with "250-" or "250 "
+func WithCapability(capability string) Option {
+	return func(server *Server) error {
+		if err := persona.Check(capability); err != nil {
+			return err
+		}
+		for _, l := range strings.Split(strings.TrimRight(strings.ReplaceAll(capability, "\r\n", "\n"), "\n"), "\n") {
+			if !strings.HasPrefix(l, "250-") && !strings.HasPrefix(l, "250 ") {
+				return fmt.Errorf("WithCapability: %q does not start with \"250-\" or \"250 \"", l)
+			}
+		}
+		server.SetCapability(capability)
+		return nil
+	}
+}
+
+// WithLogger sends the events to the outputs of a logger
+func WithLogger(l *event.Logger) Option {
+	return func(server *Server) error {
+		server.SetLogger(l)
+		return nil
+	}
+}
+
+// WithSubscriber passes every event to s, see Subscribe
+func WithSubscriber(s core.Subscriber) Option {
+	return func(server *Server) error {
+		server.Subscribe(s)
+		return nil
+	}
+}
+
+// WithDebug emits the session open and command events
+func WithDebug() Option {
+	return func(server *Server) error {
+		server.SetDebug(true)
+		return nil
+	}
+}
+
+// WithNTLMDomain sets the domain names sent in NTLM challenges
+func WithNTLMDomain(domain string, dnsDomain string) Option {
+	return func(server *Server) error {
+		server.SetNTLMDomain(domain, dnsDomain)
+		return nil
+	}
+}
+
+// WithAuth offers AUTH and logs the credentials, accepting them if
+// accept is set
+func WithAuth(accept bool) Option {
+	return func(server *Server) error {
+		server.logAuth, server.authOK = true, accept
+		return nil
+	}
+}
+
+// WithData accepts recipients and logs the messages sent
+func WithData() Option {
+	return func(server *Server) error {
+		server.logData = true
+		return nil
+	}
+}
+
+// WithSpool keeps the messages sent by clients
+func WithSpool(sp *spool.Spool) Option {
+	return func(server *Server) error {
+		server.SetSpool(sp)
+		return nil
+	}
+}
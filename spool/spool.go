@@ -0,0 +1,124 @@
+package spool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"imap-honey/event"
+)
+
+// Meta is written as a JSON sidecar next to each stored message
+type Meta struct {
+	SessionID    string     `json:"session_id"`
+	Protocol     string     `json:"protocol"`
+	RemoteIP     string     `json:"remote_ip"`
+	Helo         string     `json:"helo,omitempty"`
+	MailFrom     string     `json:"mail_from,omitempty"`
+	RcptTo       []string   `json:"rcpt_to,omitempty"`
+	Username     string     `json:"username,omitempty"`
+	Mailbox      string     `json:"mailbox,omitempty"`
+	Flags        []string   `json:"flags,omitempty"`
+	TLS          *event.TLS `json:"tls,omitempty"`
+	SessionStart time.Time  `json:"session_start"`
+	Received     time.Time  `json:"received"`
+	Size         int        `json:"size"`
+	SHA256       string     `json:"sha256"`
+	File         string     `json:"file"`
+}
+
+// Spool stores messages under dir/YYYY/MM/DD/<sha256>.eml with one
+// <sha256>.<session>.json sidecar per delivery, numbered .2, .3, ... when a
+// session sends the same body again, and MIME parts under dir/parts.
+type Spool struct {
+	dir string
+}
+
+func New(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &Spool{dir}, nil
+}
+
+func (sp *Spool) Dir() string { return sp.dir }
+
+// Store writes data and its metadata, returning the .eml path
+func (sp *Spool) Store(meta *Meta, data []byte) (string, error) {
+	if meta.Received.IsZero() {
+		meta.Received = time.Now()
+	}
+	sum := sha256.Sum256(data)
+	meta.SHA256 = hex.EncodeToString(sum[:])
+	meta.Size = len(data)
+
+	dir := filepath.Join(sp.dir, meta.Received.UTC().Format("2006/01/02"))
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	eml := filepath.Join(dir, meta.SHA256+".eml")
+	if _, err := os.Stat(eml); os.IsNotExist(err) {
+		if err := writeFile(eml, data); err != nil {
+			return "", err
+		}
+	}
+	meta.File, _ = filepath.Rel(sp.dir, eml)
+
+	j, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := writeFile(sidecar(dir, meta), append(j, '\n')); err != nil {
+		return "", err
+	}
+	return eml, nil
+}
+
+// sidecar returns the first free <sha256>.<session>[.<n>].json name, so a
+// session delivering the same body twice keeps both sidecars. A session
+// stores its messages one at a time, so checking then writing is enough.
+func sidecar(dir string, meta *Meta) string {
+	name := filepath.Join(dir, fmt.Sprintf("%s.%s.json", meta.SHA256, meta.SessionID))
+	for n := 2; ; n++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = filepath.Join(dir, fmt.Sprintf("%s.%s.%d.json", meta.SHA256, meta.SessionID, n))
+	}
+}
+
+// StoreBlob writes a MIME part once under dir/parts/<2 hex>/<sha256>
+func (sp *Spool) StoreBlob(sha256 string, data []byte) (string, error) {
+	dir := filepath.Join(sp.dir, "parts", sha256[:2])
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, sha256)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	return path, writeFile(path, data)
+}
+
+// writeFile writes through a temporary file so rsync never sees partial files
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Chmod(tmp.Name(), 0640)
+	return os.Rename(tmp.Name(), path)
+}
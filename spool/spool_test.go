@@ -0,0 +1,68 @@
+package spool
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("Subject: test\r\n\r\nhello\r\n")
+	received := time.Date(2022, 4, 22, 10, 0, 0, 0, time.UTC)
+	for _, id := range []string{"s1", "s2", "s1"} {
+		meta := &Meta{SessionID: id, Protocol: "smtp", RemoteIP: "192.0.2.1",
+			MailFrom: "a@example.org", RcptTo: []string{"b@example.org"}, Received: received}
+		eml, err := sp.Store(meta, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := filepath.Join(dir, "2022/04/22", meta.SHA256+".eml")
+		if eml != want {
+			t.Errorf("eml path: wait %q, receive %q", want, eml)
+		}
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "2022/04/22/*"))
+	if len(matches) != 4 {
+		t.Fatalf("wait 1 eml and 3 sidecars, receive %v", matches)
+	}
+
+	sidecars, _ := filepath.Glob(filepath.Join(dir, "2022/04/22/*.s1*.json"))
+	if len(sidecars) != 2 {
+		t.Fatalf("wait 2 sidecars for s1: %v", matches)
+	}
+	b, err := os.ReadFile(sidecars[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta Meta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size != len(data) || meta.RcptTo[0] != "b@example.org" {
+		t.Errorf("bad sidecar: %s", b)
+	}
+}
+
+func TestStoreBlob(t *testing.T) {
+	dir := t.TempDir()
+	sp, _ := New(dir)
+	sum := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	for i := 0; i < 2; i++ {
+		path, err := sp.StoreBlob(sum, []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != filepath.Join(dir, "parts", "2c", sum) {
+			t.Errorf("bad blob path %q", path)
+		}
+	}
+}